@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errInvalidUserToken = &requestError{http.StatusBadRequest, "This link is invalid or has expired"}
+
+// hashPassword hashes a password for storage
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// hashUserToken is the form a mailed token is stored and looked up in
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueUserToken stores a new single-use token for a user and returns it
+func issueUserToken(ctx context.Context, user User, purpose string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	now := time.Now()
+	err := store.UserTokens().Create(ctx, UserToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Hash:      hashUserToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	return token, err
+}
+
+// useUserToken checks a mailed token and marks it used, along with the
+// user's other tokens for the same purpose
+func useUserToken(ctx context.Context, purpose, token string) (User, error) {
+	stored, err := store.UserTokens().GetActive(ctx, purpose, hashUserToken(token))
+	if errors.Is(err, ErrNotFound) {
+		return User{}, errInvalidUserToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	used, err := store.UserTokens().Use(ctx, stored.ID)
+	if err != nil {
+		return User{}, err
+	}
+	if !used {
+		return User{}, errInvalidUserToken
+	}
+	if err := store.UserTokens().UseAllForUser(ctx, stored.UserID, purpose); err != nil {
+		return User{}, err
+	}
+
+	user, err := store.Users().Get(ctx, stored.UserID)
+	if errors.Is(err, ErrNotFound) {
+		return User{}, errInvalidUserToken
+	}
+	return user, err
+}
+
+// appLink is a page of the web app with a token in its query string
+func appLink(path, token string) string {
+	return config.AppURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail mails a user a link to verify their email address
+func sendVerificationEmail(ctx context.Context, user User) error {
+	token, err := issueUserToken(ctx, user, tokenVerifyEmail, config.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	sendMail(Mail{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen this link to verify your email address:\n\n%s\n\nThe link expires in %s.\n",
+			user.Name, appLink("/verify-email", token), config.EmailVerificationTTL),
+	})
+	return nil
+}
+
+// sendPasswordReset mails a user a link to choose a new password
+func sendPasswordReset(ctx context.Context, user User) error {
+	token, err := issueUserToken(ctx, user, tokenResetPassword, config.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	sendMail(Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen this link to choose a new password:\n\n%s\n\nThe link expires in %s. "+
+			"If you did not ask to reset your password you can ignore this email.\n",
+			user.Name, appLink("/reset-password", token), config.PasswordResetTTL),
+	})
+	return nil
+}
+
+// VerifyEmail marks the email address of the user a verification token was
+// mailed to as verified
+func VerifyEmail(c *gin.Context) {
+	type VerifyRequest struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	var req VerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		user, err := useUserToken(ctx, tokenVerifyEmail, req.Token)
+		if err != nil {
+			return err
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		return store.Users().Update(ctx, user)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to verify email")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+}
+
+// ResendVerification mails a new verification link to an unverified
+// account. The response is the same whether or not the account exists.
+func ResendVerification(c *gin.Context) {
+	type ResendRequest struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	var req ResendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := store.Users().GetByEmail(ctx, req.Email)
+	if err == nil && user.EmailVerifiedAt == nil {
+		err = sendVerificationEmail(ctx, user)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address has an unverified account, a new link has been sent"})
+}
+
+// ForgotPassword mails a password reset link. The response is the same
+// whether or not the account exists.
+func ForgotPassword(c *gin.Context) {
+	type ForgotRequest struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	var req ForgotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := store.Users().GetByEmail(ctx, req.Email)
+	if err == nil {
+		err = sendPasswordReset(ctx, user)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reset email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address has an account, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword. The
+// account's sessions are ended and its lockout lifted, and its email counts
+// as verified since the link reached it.
+func ResetPassword(c *gin.Context) {
+	type ResetRequest struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	var req ResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var email string
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		user, err := useUserToken(ctx, tokenResetPassword, req.Token)
+		if err != nil {
+			return err
+		}
+		if err := checkPassword(req.Password, user.Email); err != nil {
+			return &requestError{http.StatusBadRequest, err.Error()}
+		}
+		if user.Password, err = hashPassword(req.Password); err != nil {
+			return err
+		}
+		email = user.Email
+		if user.EmailVerifiedAt == nil {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+		if err := store.Users().Update(ctx, user); err != nil {
+			return err
+		}
+		return store.Sessions().RevokeAllForUser(ctx, user.ID)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to reset password")
+		return
+	}
+	// Whoever reset the password can read the account's mail, so a lockout
+	// has served its purpose
+	if err := store.LoginFailures().Clear(ctx, normalizeEmail(email)); err != nil {
+		log.Printf("failed to clear login failures for %s: %v", email, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset; please log in"})
+}
+
+// ChangePassword replaces the caller's password after checking the current
+// one. Every session of the account is ended and the caller gets a new one.
+func ChangePassword(c *gin.Context) {
+	type ChangeRequest struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+
+	var req ChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	caller, _ := currentUser(c)
+	user, err := store.Users().Get(ctx, caller.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Guessing the current password counts as failed logins
+	email := normalizeEmail(user.Email)
+	if !checkLoginAllowed(ctx, c, email, user.ID) {
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		recordLoginFailure(ctx, email, c.ClientIP(), loginBadPassword, user.ID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if err := checkPassword(req.NewPassword, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Password, err = hashPassword(req.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Users().Update(ctx, user); err != nil {
+			return err
+		}
+		return store.Sessions().RevokeAllForUser(ctx, user.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	tokens, err := startSession(ctx, user)
+	if err != nil {
+		log.Printf("failed to start session after password change: %v", err)
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed; please log in again"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed", "tokens": tokens})
+}
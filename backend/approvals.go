@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Volunteer request statuses
+const (
+	requestPending   = "pending"
+	requestApproved  = "approved"
+	requestRejected  = "rejected"
+	requestWithdrawn = "withdrawn" // cancelled by the teacher before a decision
+)
+
+// needsApproval reports whether sign-ups for a role wait for an admin's
+// approval, which is the case for roles worth APPROVAL_POINTS or more
+func needsApproval(role Role) bool {
+	return config.ApprovalPoints > 0 && role.Point >= config.ApprovalPoints
+}
+
+// requestVolunteer records a teacher's pending sign-up for a role. It must
+// run in a transaction.
+func requestVolunteer(ctx context.Context, event Event, role Role, teacher Teacher) (VolunteerRequest, error) {
+	if event.Status != eventPublished {
+		return VolunteerRequest{}, &requestError{http.StatusConflict, "Roles can only be staffed while the event is published"}
+	}
+	if teacher.DeactivatedAt != nil {
+		return VolunteerRequest{}, errTeacherDeactivated
+	}
+
+	assigned, err := store.Assignments().Count(ctx, AssignmentFilter{TeacherID: teacher.ID, RoleID: role.ID})
+	if err != nil {
+		return VolunteerRequest{}, err
+	}
+	if assigned > 0 {
+		return VolunteerRequest{}, &requestError{http.StatusBadRequest, "Teacher is already assigned to this role in this event"}
+	}
+	pending, err := store.VolunteerRequests().List(ctx, VolunteerRequestFilter{
+		TeacherID: teacher.ID,
+		RoleID:    role.ID,
+		Status:    requestPending,
+	})
+	if err != nil {
+		return VolunteerRequest{}, err
+	}
+	if len(pending) > 0 {
+		return VolunteerRequest{}, &requestError{http.StatusBadRequest, "You already have a pending request for this role"}
+	}
+
+	request := VolunteerRequest{
+		ID:          primitive.NewObjectID(),
+		EventID:     event.ID,
+		EventName:   event.Name,
+		RoleID:      role.ID,
+		RoleName:    role.Name,
+		Points:      role.Point,
+		TeacherID:   teacher.ID,
+		TeacherName: teacher.Name,
+		Status:      requestPending,
+		CreatedAt:   time.Now(),
+	}
+	return request, store.VolunteerRequests().Create(ctx, request)
+}
+
+// closeVolunteerRequests rejects the pending requests of an event that has
+// been completed or cancelled
+func closeVolunteerRequests(ctx context.Context, event Event, status string, actor User, now time.Time) error {
+	requests, err := store.VolunteerRequests().List(ctx, VolunteerRequestFilter{EventID: event.ID, Status: requestPending})
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		request.Status = requestRejected
+		request.DecidedAt = &now
+		request.DecidedBy = actor.ID
+		request.Reason = "Event " + status
+		if err := store.VolunteerRequests().Update(ctx, request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VolunteerDecision is the outcome of deciding one volunteer request
+type VolunteerDecision struct {
+	RequestID     primitive.ObjectID `json:"request_id"`
+	Status        string             `json:"status,omitempty"`
+	Assignment    *Assignment        `json:"assignment,omitempty"`
+	WaitlistEntry *WaitlistEntry     `json:"waitlist_entry,omitempty"`
+	Error         string             `json:"error,omitempty"`
+}
+
+// decideVolunteerRequest approves or rejects a pending request. Approving
+// assigns the teacher with the usual checks, or puts them on the waitlist if
+// the role has filled up.
+func decideVolunteerRequest(ctx context.Context, id primitive.ObjectID, approve bool, reason string, actor User) (VolunteerDecision, error) {
+	decision := VolunteerDecision{RequestID: id}
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		decision = VolunteerDecision{RequestID: id}
+		request, err := store.VolunteerRequests().Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Request not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if request.Status != requestPending {
+			return &requestError{http.StatusConflict, "Request has already been " + request.Status}
+		}
+
+		if approve {
+			assignment, err := assignTeacher(ctx, request.TeacherID, request.RoleID, request.EventID, true)
+			if errors.Is(err, errRoleFull) {
+				entry, err := joinWaitlist(ctx, request.RoleID, request.EventID, request.TeacherID, true)
+				if err != nil {
+					return err
+				}
+				decision.WaitlistEntry = &entry
+			} else if err != nil {
+				return err
+			} else {
+				decision.Assignment = &assignment
+				request.AssignmentID = assignment.ID
+			}
+			request.Status = requestApproved
+		} else {
+			request.Status = requestRejected
+		}
+
+		now := time.Now()
+		request.DecidedAt = &now
+		request.DecidedBy = actor.ID
+		request.Reason = reason
+		decision.Status = request.Status
+		return store.VolunteerRequests().Update(ctx, request)
+	})
+	return decision, err
+}
+
+// ListEventVolunteerRequests lists an event's volunteer requests, oldest
+// first. Only pending requests are listed unless ?status= names another
+// status or is "all".
+func ListEventVolunteerRequests(c *gin.Context) {
+	eventID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID format"})
+		return
+	}
+	filter := VolunteerRequestFilter{EventID: eventID, Status: c.DefaultQuery("status", requestPending)}
+	switch filter.Status {
+	case "all":
+		filter.Status = ""
+	case requestPending, requestApproved, requestRejected, requestWithdrawn:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown request status " + filter.Status})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	requests, err := store.VolunteerRequests().List(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if requests == nil {
+		requests = []VolunteerRequest{}
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// DecideVolunteerRequests approves or rejects volunteer requests in bulk.
+// Each request is decided on its own, so one failure does not hold back the
+// others; the response reports the outcome of each. Rejections need a
+// reason.
+func DecideVolunteerRequests(c *gin.Context) {
+	type DecisionRequest struct {
+		RequestIDs []primitive.ObjectID `json:"request_ids" binding:"required"`
+		Decision   string               `json:"decision" binding:"required"`
+		Reason     string               `json:"reason"`
+	}
+
+	var req DecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Decision != "approve" && req.Decision != "reject" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "decision must be approve or reject"})
+		return
+	}
+	if req.Decision == "reject" && req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to reject requests"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	decisions := make([]VolunteerDecision, 0, len(req.RequestIDs))
+	decided := 0
+	for _, id := range req.RequestIDs {
+		decision, err := decideVolunteerRequest(ctx, id, req.Decision == "approve", req.Reason, actor)
+		var reqErr *requestError
+		switch {
+		case errors.As(err, &reqErr):
+			decision.Error = reqErr.message
+		case err != nil:
+			decision.Error = "Failed to decide request"
+		default:
+			decided++
+		}
+		decisions = append(decisions, decision)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"decided":   decided,
+		"decisions": decisions,
+	})
+}
+
+// GetVolunteerRequestHistory lists approved and rejected requests, most
+// recent decision first, optionally for one event or teacher
+func GetVolunteerRequestHistory(c *gin.Context) {
+	eventID, err := queryID(c, "event_id")
+	if err != nil {
+		respondTransactionError(c, err, "Invalid event ID")
+		return
+	}
+	teacherID, err := queryID(c, "teacher_id")
+	if err != nil {
+		respondTransactionError(c, err, "Invalid teacher ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	requests, err := store.VolunteerRequests().List(ctx, VolunteerRequestFilter{
+		EventID:   eventID,
+		TeacherID: teacherID,
+		Decided:   true,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if requests == nil {
+		requests = []VolunteerRequest{}
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].DecidedAt.After(*requests[j].DecidedAt) })
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// ListMyVolunteerRequests lists the calling teacher's volunteer requests
+func ListMyVolunteerRequests(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	teacher, ok := callerTeacher(ctx, c)
+	if !ok {
+		return
+	}
+	requests, err := store.VolunteerRequests().List(ctx, VolunteerRequestFilter{TeacherID: teacher.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if requests == nil {
+		requests = []VolunteerRequest{}
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+// WithdrawVolunteerRequest cancels one of the calling teacher's pending
+// requests
+func WithdrawVolunteerRequest(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	teacher, ok := callerTeacher(ctx, c)
+	if !ok {
+		return
+	}
+
+	var request VolunteerRequest
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		request, err = store.VolunteerRequests().Get(ctx, id)
+		if errors.Is(err, ErrNotFound) || (err == nil && request.TeacherID != teacher.ID) {
+			return &requestError{http.StatusNotFound, "Request not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if request.Status != requestPending {
+			return &requestError{http.StatusConflict, "Request has already been " + request.Status}
+		}
+		request.Status = requestWithdrawn
+		return store.VolunteerRequests().Update(ctx, request)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to withdraw request")
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Assignment attendance values
+const (
+	attendanceAssigned = "assigned" // not confirmed yet
+	attendanceAttended = "attended"
+	attendanceAbsent   = "absent"
+	attendanceExcused  = "excused"
+)
+
+// validAttendance reports whether status may be recorded for an assignment
+func validAttendance(status string) bool {
+	return status == attendanceAttended || status == attendanceAbsent || status == attendanceExcused
+}
+
+// requireAttendanceOpen rejects recording attendance for an event that has
+// not started, was never published or has been cancelled
+func requireAttendanceOpen(event Event, now time.Time) error {
+	switch event.Status {
+	case eventPublished, eventInProgress, eventCompleted:
+	default:
+		return &requestError{http.StatusConflict, "Attendance cannot be recorded for a " + event.Status + " event"}
+	}
+	if !event.StartsAt.IsZero() && event.StartsAt.After(now) {
+		return &requestError{http.StatusConflict, "Attendance can only be recorded once the event has started"}
+	}
+	return nil
+}
+
+// recordAttendance stores a teacher's attendance and settles the
+// assignment's points. Once the event is completed, attending credits the
+// role's points and being absent or excused takes back points already
+// credited. Before that, points stay pending for attendees and are voided
+// for everyone else.
+func recordAttendance(ctx context.Context, event Event, assignment Assignment, status string, actor User, now time.Time) (Assignment, error) {
+	assignment.Attendance = status
+	assignment.AttendanceAt = &now
+	assignment.AttendanceBy = actor.ID
+
+	switch {
+	case status == attendanceAttended && event.Status == eventCompleted:
+		if assignment.PointsStatus == pointsAwarded {
+			break
+		}
+		role, err := store.Roles().Get(ctx, assignment.RoleID)
+		if errors.Is(err, ErrNotFound) {
+			assignment.PointsStatus = pointsVoided
+			break
+		}
+		if err != nil {
+			return assignment, err
+		}
+		if err := recordLedgerEntries(ctx, assignmentAward(event, assignment, role, actor, "Attendance confirmed")); err != nil {
+			return assignment, err
+		}
+		assignment.PointsStatus = pointsAwarded
+		assignment.AwardedPoints = role.Point
+	case status == attendanceAttended:
+		assignment.PointsStatus = pointsPending
+	case assignment.PointsStatus == pointsAwarded:
+		deduction, ok, err := assignmentDeduction(ctx, assignment, actor, "Marked "+status)
+		if err != nil {
+			return assignment, err
+		}
+		if ok {
+			if err := recordLedgerEntries(ctx, deduction); err != nil {
+				return assignment, err
+			}
+		}
+		assignment.PointsStatus = pointsReversed
+	case assignment.PointsStatus == pointsPending:
+		assignment.PointsStatus = pointsVoided
+	}
+	return assignment, store.Assignments().Update(ctx, assignment)
+}
+
+// AttendanceRequest is the body accepted when recording one assignment's
+// attendance
+type AttendanceRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// MarkAttendance records whether the teacher of an assignment attended
+func MarkAttendance(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assignment ID format"})
+		return
+	}
+
+	var req AttendanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validAttendance(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be attended, absent or excused"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	var assignment Assignment
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		assignment, err = store.Assignments().Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Assignment not found"}
+		}
+		if err != nil {
+			return err
+		}
+		event, err := store.Events().Get(ctx, assignment.EventID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Event not found"}
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := requireAttendanceOpen(event, now); err != nil {
+			return err
+		}
+		assignment, err = recordAttendance(ctx, event, assignment, req.Status, actor, now)
+		return err
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to record attendance")
+		return
+	}
+
+	c.JSON(http.StatusOK, assignment)
+}
+
+// EventAttendanceRequest records attendance for many assignments of one
+// event. Unconfirmed, if set, is recorded for every assignment that is still
+// unconfirmed and not listed in Records.
+type EventAttendanceRequest struct {
+	Records []struct {
+		AssignmentID primitive.ObjectID `json:"assignment_id"`
+		Status       string             `json:"status"`
+	} `json:"records"`
+	Unconfirmed string `json:"unconfirmed"`
+}
+
+// MarkEventAttendance records attendance for an event in bulk
+func MarkEventAttendance(c *gin.Context) {
+	eventID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req EventAttendanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, record := range req.Records {
+		if !validAttendance(record.Status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be attended, absent or excused"})
+			return
+		}
+	}
+	if req.Unconfirmed != "" && !validAttendance(req.Unconfirmed) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unconfirmed must be attended, absent or excused"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	var updated []Assignment
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		updated = nil
+		event, err := store.Events().Get(ctx, eventID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Event not found"}
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := requireAttendanceOpen(event, now); err != nil {
+			return err
+		}
+
+		roles, err := store.Roles().ListByEvent(ctx, eventID)
+		if err != nil {
+			return err
+		}
+		assignments, err := eventAssignments(ctx, eventID, roles)
+		if err != nil {
+			return err
+		}
+
+		statuses := make(map[primitive.ObjectID]string, len(assignments))
+		for _, record := range req.Records {
+			statuses[record.AssignmentID] = record.Status
+		}
+		known := make(map[primitive.ObjectID]bool, len(assignments))
+		for _, assignment := range assignments {
+			known[assignment.ID] = true
+			if _, ok := statuses[assignment.ID]; !ok && req.Unconfirmed != "" && assignment.Attendance == attendanceAssigned {
+				statuses[assignment.ID] = req.Unconfirmed
+			}
+		}
+		for id := range statuses {
+			if !known[id] {
+				return &requestError{http.StatusBadRequest, "Assignment " + id.Hex() + " does not belong to this event"}
+			}
+		}
+
+		for _, assignment := range assignments {
+			status, ok := statuses[assignment.ID]
+			if !ok {
+				continue
+			}
+			assignment, err := recordAttendance(ctx, event, assignment, status, actor, now)
+			if err != nil {
+				return err
+			}
+			updated = append(updated, assignment)
+		}
+		return nil
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to record attendance")
+		return
+	}
+	if updated == nil {
+		updated = []Assignment{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"updated":     len(updated),
+		"assignments": updated,
+	})
+}
+
+// UnconfirmedAssignment is an assignment of an event that has ended without
+// its attendance being recorded
+type UnconfirmedAssignment struct {
+	Assignment
+	EventEndsAt time.Time `json:"event_ends_at"`
+}
+
+// ListUnconfirmedAttendance lists assignments of past events whose
+// attendance has not been recorded, oldest event first. Cancelled events are
+// left out because their points are already settled.
+func ListUnconfirmedAttendance(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	assignments, err := store.Assignments().List(ctx, AssignmentFilter{Attendance: attendanceAssigned})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	events := make(map[primitive.ObjectID]*Event)
+	result := []UnconfirmedAssignment{}
+	for _, assignment := range assignments {
+		event, ok := events[assignment.EventID]
+		if !ok {
+			loaded, err := store.Events().Get(ctx, assignment.EventID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if err == nil {
+				event = &loaded
+			}
+			events[assignment.EventID] = event
+		}
+		if event == nil || event.Status == eventCancelled || event.Status == eventDraft ||
+			event.EndsAt.IsZero() || event.EndsAt.After(now) {
+			continue
+		}
+		result = append(result, UnconfirmedAssignment{Assignment: assignment, EventEndsAt: event.EndsAt})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].EventEndsAt.Before(result[j].EventEndsAt) })
+
+	c.JSON(http.StatusOK, result)
+}
+
+// migrateAttendance gives assignments saved before attendance existed an
+// attendance: attended if their points were credited, unconfirmed otherwise
+func migrateAttendance(ctx context.Context, s Store) error {
+	assignments, err := s.Assignments().List(ctx, AssignmentFilter{})
+	if err != nil {
+		return err
+	}
+	for _, assignment := range assignments {
+		if assignment.Attendance != "" {
+			continue
+		}
+		assignment.Attendance = attendanceAssigned
+		if assignment.PointsStatus == pointsAwarded || assignment.PointsStatus == pointsReversed {
+			assignment.Attendance = attendanceAttended
+		}
+		if err := s.Assignments().Update(ctx, assignment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Token types carried in the "typ" claim
+const (
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+	mfaTokenType     = "mfa" // the password step of a two-factor login
+)
+
+// Keys used to expose the authenticated caller to handlers
+const (
+	contextUserKey    = "user"
+	contextSessionKey = "session_id"
+)
+
+// TokenClaims are the claims signed into access and refresh tokens
+type TokenClaims struct {
+	Type      string `json:"typ"`
+	SessionID string `json:"sid"`
+	Role      string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair is returned by Login and Refresh
+type TokenPair struct {
+	AccessToken      string    `json:"token"`
+	RefreshToken     string    `json:"refresh_token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+// signToken signs a token of the given type for a user session
+func signToken(user User, sessionID primitive.ObjectID, tokenType, tokenID string, expiresAt time.Time) (string, error) {
+	claims := TokenClaims{
+		Type:      tokenType,
+		SessionID: sessionID.Hex(),
+		Role:      user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.AuthSecret)
+}
+
+// parseToken verifies the signature, expiry and type of a token
+func parseToken(tokenString, tokenType string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return config.AuthSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	if claims.Type != tokenType {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// issueTokens signs a fresh access/refresh pair for a session. The refresh
+// token's ID is returned so the caller can record it on the session.
+func issueTokens(user User, session Session) (TokenPair, string, error) {
+	now := time.Now()
+	refreshID := primitive.NewObjectID().Hex()
+
+	accessExpiresAt := now.Add(config.AccessTokenTTL)
+	accessToken, err := signToken(user, session.ID, accessTokenType, primitive.NewObjectID().Hex(), accessExpiresAt)
+	if err != nil {
+		return TokenPair{}, "", err
+	}
+
+	refreshToken, err := signToken(user, session.ID, refreshTokenType, refreshID, session.ExpiresAt)
+	if err != nil {
+		return TokenPair{}, "", err
+	}
+
+	return TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresAt:        accessExpiresAt,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, refreshID, nil
+}
+
+// startSession creates a session for a user and returns its first token pair
+func startSession(ctx context.Context, user User) (TokenPair, error) {
+	now := time.Now()
+	session := Session{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.RefreshTokenTTL),
+	}
+
+	tokens, refreshID, err := issueTokens(user, session)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	session.RefreshID = refreshID
+
+	if err := store.Sessions().Create(ctx, session); err != nil {
+		return TokenPair{}, err
+	}
+	return tokens, nil
+}
+
+// findActiveSession loads a session that is neither revoked nor expired
+func findActiveSession(ctx context.Context, sessionID string) (Session, error) {
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return Session{}, errInvalidToken
+	}
+
+	session, err := store.Sessions().GetActive(ctx, oid)
+	if errors.Is(err, ErrNotFound) {
+		return session, errInvalidToken
+	}
+	return session, err
+}
+
+// findUserByID loads the user a token was issued to
+func findUserByID(ctx context.Context, userID string) (User, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return User{}, errInvalidToken
+	}
+
+	user, err := store.Users().Get(ctx, oid)
+	if errors.Is(err, ErrNotFound) {
+		return user, errInvalidToken
+	}
+	return user, err
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// AuthRequired rejects requests without a valid access token and stores the
+// caller's User in the context
+func AuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := bearerToken(c)
+		if tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing access token"})
+			return
+		}
+
+		claims, err := parseToken(tokenString, accessTokenType)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired access token"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		session, err := findActiveSession(ctx, claims.SessionID)
+		if err != nil {
+			if err == errInvalidToken {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session has ended"})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
+			return
+		}
+
+		user, err := findUserByID(ctx, claims.Subject)
+		if err != nil || user.ID != session.UserID {
+			if err == nil || err == errInvalidToken {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
+			return
+		}
+
+		if twoFactorSetupRequired(user) && !twoFactorSetupRoutes[c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":                     "Admins must set up two-factor authentication first",
+				"two_factor_setup_required": true,
+			})
+			return
+		}
+
+		user.Password = ""
+		c.Set(contextUserKey, user)
+		c.Set(contextSessionKey, session.ID)
+		c.Next()
+	}
+}
+
+// currentUser returns the authenticated caller set by AuthRequired
+func currentUser(c *gin.Context) (User, bool) {
+	value, ok := c.Get(contextUserKey)
+	if !ok {
+		return User{}, false
+	}
+	user, ok := value.(User)
+	return user, ok
+}
+
+// Refresh exchanges a refresh token for a new token pair. Refresh tokens
+// rotate on every use; presenting an already used one ends the session.
+func Refresh(c *gin.Context) {
+	type RefreshRequest struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	claims, err := parseToken(req.RefreshToken, refreshTokenType)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := findActiveSession(ctx, claims.SessionID)
+	if err != nil {
+		if err == errInvalidToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has ended"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	if session.RefreshID != claims.ID {
+		// An old refresh token was replayed, so assume it leaked
+		_ = store.Sessions().Revoke(ctx, session.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has already been used"})
+		return
+	}
+
+	user, err := findUserByID(ctx, claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+		return
+	}
+
+	tokens, refreshID, err := issueTokens(user, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue tokens"})
+		return
+	}
+
+	// Only rotate if nobody else rotated this session in the meantime
+	rotated, err := store.Sessions().RotateRefresh(ctx, session.ID, claims.ID, refreshID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !rotated {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has already been used"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the caller's session, or all of their sessions with "all"
+func Logout(c *gin.Context) {
+	type LogoutRequest struct {
+		All bool `json:"all"`
+	}
+
+	var req LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	user, _ := currentUser(c)
+	sessionID := c.MustGet(contextSessionKey).(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	if req.All {
+		err = store.Sessions().RevokeAllForUser(ctx, user.ID)
+	} else {
+		err = store.Sessions().Revoke(ctx, sessionID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// Me returns the authenticated caller
+func Me(c *gin.Context) {
+	user, _ := currentUser(c)
+	c.JSON(http.StatusOK, user)
+}
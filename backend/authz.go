@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Values stored in User.Role
+const (
+	userRoleAdmin       = "admin"
+	userRoleCoordinator = "coordinator"
+	userRoleTeacher     = "teacher"
+)
+
+// Permission names an operation that can be granted to a user role
+type Permission string
+
+const (
+	PermEventsRead       Permission = "events:read"
+	PermEventsWrite      Permission = "events:write"
+	PermTeachersRead     Permission = "teachers:read"
+	PermTeachersWrite    Permission = "teachers:write"
+	PermDepartmentsWrite Permission = "departments:write"
+	PermTermsWrite       Permission = "terms:write"
+	PermAssignmentsRead  Permission = "assignments:read"
+	PermAssignmentsWrite Permission = "assignments:write"
+	PermAttendanceWrite  Permission = "attendance:write"
+	PermSignup           Permission = "assignments:signup" // volunteer for roles as oneself
+	PermPointsRead       Permission = "points:read"
+	PermPointsWrite      Permission = "points:write"
+	PermUsersManage      Permission = "users:manage"
+)
+
+// rolePermissions is the permission matrix. Teachers can additionally read
+// their own assignments and points, see RequireSelfOrPermission.
+var rolePermissions = map[string][]Permission{
+	userRoleAdmin: {
+		PermEventsRead,
+		PermEventsWrite,
+		PermTeachersRead,
+		PermTeachersWrite,
+		PermDepartmentsWrite,
+		PermTermsWrite,
+		PermAssignmentsRead,
+		PermAssignmentsWrite,
+		PermAttendanceWrite,
+		PermSignup,
+		PermPointsRead,
+		PermPointsWrite,
+		PermUsersManage,
+	},
+	// Coordinators run events on the day and record who attended
+	userRoleCoordinator: {
+		PermEventsRead,
+		PermTeachersRead,
+		PermAssignmentsRead,
+		PermAttendanceWrite,
+		PermSignup,
+	},
+	userRoleTeacher: {
+		PermEventsRead,
+		PermTeachersRead,
+		PermSignup,
+	},
+}
+
+// validUserRole reports whether a role appears in the permission matrix
+func validUserRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// hasPermission reports whether a user's role grants a permission
+func hasPermission(user User, perm Permission) bool {
+	for _, p := range rolePermissions[user.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission rejects callers whose role lacks the permission
+func RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		if !hasPermission(user, perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You do not have permission to perform this action"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrPermission lets a caller through when the teacher ID in the
+// named path parameter is their own teacher record, or when their role
+// grants the permission
+func RequireSelfOrPermission(perm Permission, teacherParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		if hasPermission(user, perm) {
+			c.Next()
+			return
+		}
+
+		teacherID, err := primitive.ObjectIDFromHex(c.Param(teacherParam))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		teacher, err := findTeacherForUser(ctx, user)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if err != nil || teacher.ID != teacherID {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You can only view your own records"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// findTeacherForUser returns the teacher record linked to a user account
+func findTeacherForUser(ctx context.Context, user User) (Teacher, error) {
+	if user.UserID.IsZero() {
+		return Teacher{}, ErrNotFound
+	}
+	teacher, err := store.Teachers().Get(ctx, user.UserID)
+	if err != nil {
+		return Teacher{}, err
+	}
+	// Both sides of a link name each other
+	if teacher.UserID != user.ID {
+		return Teacher{}, ErrNotFound
+	}
+	return teacher, nil
+}
+
+// ListUsers returns every user account without password hashes
+func ListUsers(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	users, err := store.Users().List(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range users {
+		users[i].Password = ""
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// UpdateUserRole promotes or demotes a user. The last admin cannot be demoted.
+func UpdateUserRole(c *gin.Context) {
+	type UpdateRoleRequest struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if !validUserRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		user, err := store.Users().Get(ctx, userID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return &requestError{http.StatusNotFound, "User not found"}
+			}
+			return err
+		}
+
+		if user.Role == userRoleAdmin && req.Role != userRoleAdmin {
+			admins, err := store.Users().CountByRole(ctx, userRoleAdmin)
+			if err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return &requestError{http.StatusBadRequest, "Cannot demote the last admin"}
+			}
+		}
+
+		user.Role = req.Role
+		return store.Users().Update(ctx, user)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to update role")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User role updated successfully",
+		"user_id": userID,
+		"role":    req.Role,
+	})
+}
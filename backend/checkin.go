@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// checkInEarly is how long before an event starts teachers may check in
+const checkInEarly = 30 * time.Minute
+
+// checkInMACSize is the number of HMAC bytes kept in a check-in code
+const checkInMACSize = 16
+
+// checkInCode is what a check-in code proves: that it was issued for an
+// event, optionally for one of its roles, during a rotation window
+type checkInCode struct {
+	EventID primitive.ObjectID
+	RoleID  primitive.ObjectID
+	Window  int64
+}
+
+// checkInTTL is the rotation period of check-in codes
+func checkInTTL() time.Duration {
+	if config.CheckInCodeTTL < time.Second {
+		return time.Minute
+	}
+	return config.CheckInCodeTTL
+}
+
+// checkInWindow is the rotation window containing t
+func checkInWindow(t time.Time) int64 {
+	return t.Unix() / int64(checkInTTL()/time.Second)
+}
+
+// checkInMAC signs a code's payload with a key derived from the auth secret,
+// so check-in codes cannot be used as tokens or vice versa
+func checkInMAC(payload []byte) []byte {
+	key := hmac.New(sha256.New, config.AuthSecret)
+	key.Write([]byte("event check-in"))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write(payload)
+	return mac.Sum(nil)[:checkInMACSize]
+}
+
+func (code checkInCode) payload() []byte {
+	payload := make([]byte, 0, 32)
+	payload = append(payload, code.EventID[:]...)
+	payload = append(payload, code.RoleID[:]...)
+	return binary.BigEndian.AppendUint64(payload, uint64(code.Window))
+}
+
+// String encodes the code for display or a QR image
+func (code checkInCode) String() string {
+	payload := code.payload()
+	return base64.RawURLEncoding.EncodeToString(append(payload, checkInMAC(payload)...))
+}
+
+// parseCheckInCode verifies a code's signature and that it belongs to the
+// current or the previous rotation window, which gives a teacher who scanned
+// just before the code rotated time to submit it
+func parseCheckInCode(value string, now time.Time) (checkInCode, error) {
+	invalid := &requestError{http.StatusBadRequest, "Invalid check-in code"}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) != 32+checkInMACSize {
+		return checkInCode{}, invalid
+	}
+	payload, mac := data[:32], data[32:]
+	if !hmac.Equal(mac, checkInMAC(payload)) {
+		return checkInCode{}, invalid
+	}
+
+	var code checkInCode
+	copy(code.EventID[:], payload[:12])
+	copy(code.RoleID[:], payload[12:24])
+	code.Window = int64(binary.BigEndian.Uint64(payload[24:]))
+	if current := checkInWindow(now); code.Window != current && code.Window != current-1 {
+		return checkInCode{}, &requestError{http.StatusBadRequest, "Check-in code has expired; scan the current code"}
+	}
+	return code, nil
+}
+
+// checkInLink is the text placed in a QR image: a link to the check-in page
+// when CHECKIN_URL is set, otherwise the bare code
+func checkInLink(code string) string {
+	if config.CheckInURL == "" {
+		return code
+	}
+	link, err := url.Parse(config.CheckInURL)
+	if err != nil {
+		return code
+	}
+	query := link.Query()
+	query.Set("code", code)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// issueCheckInCode builds the current code for an event and optional role
+// given as the role_id query parameter. On failure the error response has
+// been written.
+func issueCheckInCode(c *gin.Context) (checkInCode, bool) {
+	eventID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID format"})
+		return checkInCode{}, false
+	}
+	roleID, err := queryID(c, "role_id")
+	if err != nil {
+		respondTransactionError(c, err, "Invalid role ID")
+		return checkInCode{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	event, err := store.Events().Get(ctx, eventID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return checkInCode{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return checkInCode{}, false
+	}
+	if event.Status != eventPublished && event.Status != eventInProgress {
+		c.JSON(http.StatusConflict, gin.H{"error": "Check-in is only open for published or in progress events"})
+		return checkInCode{}, false
+	}
+	if !roleID.IsZero() {
+		role, err := store.Roles().Get(ctx, roleID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return checkInCode{}, false
+		}
+		if err != nil || role.EventID != eventID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role does not belong to this event"})
+			return checkInCode{}, false
+		}
+	}
+
+	return checkInCode{EventID: eventID, RoleID: roleID, Window: checkInWindow(time.Now())}, true
+}
+
+// GetCheckInCode returns the current check-in code of an event, or of one
+// of its roles with ?role_id=, and when it rotates
+func GetCheckInCode(c *gin.Context) {
+	code, ok := issueCheckInCode(c)
+	if !ok {
+		return
+	}
+
+	text := code.String()
+	rotatesAt := time.Unix((code.Window+1)*int64(checkInTTL()/time.Second), 0)
+	c.JSON(http.StatusOK, gin.H{
+		"code":       text,
+		"link":       checkInLink(text),
+		"rotates_at": rotatesAt,
+	})
+}
+
+// GetCheckInQR renders the current check-in code as a QR PNG. The size query
+// parameter sets the width in pixels.
+func GetCheckInQR(c *gin.Context) {
+	size := 256
+	if value := c.Query("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size < 64 || size > 2048 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "size must be between 64 and 2048"})
+			return
+		}
+	}
+
+	code, ok := issueCheckInCode(c)
+	if !ok {
+		return
+	}
+
+	png, err := qrcode.Encode(checkInLink(code.String()), qrcode.Medium, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.DataFromReader(http.StatusOK, int64(len(png)), "image/png", bytes.NewReader(png), nil)
+}
+
+// CheckIn records the calling teacher's presence at an event from a scanned
+// check-in code. Check-in is open from 30 minutes before the event starts
+// until it ends. It confirms the attendance of each of the teacher's
+// assignments in the event (or in the code's role) that is still
+// unconfirmed.
+func CheckIn(c *gin.Context) {
+	type CheckInRequest struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	var req CheckInRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	now := time.Now()
+	code, err := parseCheckInCode(req.Code, now)
+	if err != nil {
+		respondTransactionError(c, err, "Invalid check-in code")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, _ := currentUser(c)
+	teacher, ok := callerTeacher(ctx, c)
+	if !ok {
+		return
+	}
+
+	var checkedIn []Assignment
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		checkedIn = nil
+		event, err := store.Events().Get(ctx, code.EventID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Event not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if event.Status != eventPublished && event.Status != eventInProgress {
+			return &requestError{http.StatusConflict, "Check-in is closed for this event"}
+		}
+		if event.StartsAt.IsZero() || now.Before(event.StartsAt.Add(-checkInEarly)) || now.After(event.EndsAt) {
+			return &requestError{http.StatusConflict, "Check-in is only open from 30 minutes before the event until it ends"}
+		}
+
+		assignments, err := store.Assignments().List(ctx, AssignmentFilter{
+			TeacherID: teacher.ID,
+			EventID:   event.ID,
+			RoleID:    code.RoleID,
+		})
+		if err != nil {
+			return err
+		}
+		if len(assignments) == 0 && !code.RoleID.IsZero() {
+			return &requestError{http.StatusNotFound, "You are not assigned to this role"}
+		}
+		if len(assignments) == 0 {
+			return &requestError{http.StatusNotFound, "You are not assigned to this event"}
+		}
+
+		for _, assignment := range assignments {
+			if assignment.CheckedInAt == nil {
+				assignment.CheckedInAt = &now
+			}
+			if assignment.Attendance == attendanceAssigned {
+				assignment, err = recordAttendance(ctx, event, assignment, attendanceAttended, user, now)
+			} else {
+				err = store.Assignments().Update(ctx, assignment)
+			}
+			if err != nil {
+				return err
+			}
+			checkedIn = append(checkedIn, assignment)
+		}
+		return nil
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to check in")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Checked in",
+		"assignments": checkedIn,
+	})
+}
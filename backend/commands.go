@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runCommand runs a CLI subcommand and returns the process exit code
+func runCommand(name string, args []string) int {
+	switch name {
+	case "create-admin":
+		return createAdminCommand(args)
+	case "reconcile-points":
+		return reconcileCommand(args)
+	case "import-teachers":
+		return importTeachersCommand(args)
+	case "repair-user-links":
+		return repairUserLinksCommand(args)
+	case "reset-2fa":
+		return resetTwoFactorCommand(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		fmt.Fprintln(os.Stderr, "commands: create-admin, reconcile-points, import-teachers, repair-user-links, reset-2fa")
+		return 2
+	}
+}
+
+// createAdminCommand creates an admin account, or promotes the existing user
+// with that email. This is how the first admin gets into the system.
+func createAdminCommand(args []string) int {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := fs.String("email", "", "admin email (required)")
+	name := fs.String("name", "", "display name for a new account")
+	password := fs.String("password", os.Getenv("ADMIN_PASSWORD"), "password for a new account (or ADMIN_PASSWORD)")
+	fs.Parse(args)
+
+	*email = normalizeEmail(*email)
+	if *email == "" {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := store.Users().GetByEmail(ctx, *email)
+	if err == nil {
+		user.Role = userRoleAdmin
+		if err := store.Users().Update(ctx, user); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to promote user:", err)
+			return 1
+		}
+		fmt.Printf("promoted %s to admin\n", *email)
+		return 0
+	}
+	if !errors.Is(err, ErrNotFound) {
+		fmt.Fprintln(os.Stderr, "database error:", err)
+		return 1
+	}
+
+	if *password == "" {
+		fmt.Fprintln(os.Stderr, "a password is required to create a new admin")
+		return 2
+	}
+	if err := checkPassword(*password, *email); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	hashedPassword, err := hashPassword(*password)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to hash password:", err)
+		return 1
+	}
+
+	// The operator vouches for the address
+	now := time.Now()
+	user = User{
+		ID:              primitive.NewObjectID(),
+		Name:            *name,
+		Email:           *email,
+		Password:        hashedPassword,
+		Role:            userRoleAdmin,
+		EmailVerifiedAt: &now,
+	}
+	if err := store.Users().Create(ctx, user); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create admin:", err)
+		return 1
+	}
+
+	fmt.Printf("created admin %s\n", *email)
+	return 0
+}
+
+// importTeachersCommand imports teachers from a CSV or XLSX file as
+// POST /teachers/import does, printing what happens to each row
+func importTeachersCommand(args []string) int {
+	fs := flag.NewFlagSet("import-teachers", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what the import would do")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: import-teachers [-dry-run] FILE.csv|FILE.xlsx")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	rows, err := readTeacherImport(file.Name(), file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	report, err := importTeachers(ctx, rows, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROW\tACTION\tEMAIL\tNAME\tERRORS")
+	for _, row := range report.Rows {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", row.Row, row.Action, row.Email, row.Name, strings.Join(row.Errors, "; "))
+	}
+	w.Flush()
+
+	summary := fmt.Sprintf("%d created, %d updated, %d unchanged, %d invalid",
+		report.Created, report.Updated, report.Unchanged, report.Invalid)
+	switch {
+	case report.Invalid > 0:
+		fmt.Printf("%s; nothing was imported\n", summary)
+		return 1
+	case !report.Applied:
+		fmt.Printf("%s (dry run)\n", summary)
+	default:
+		fmt.Println(summary)
+	}
+	return 0
+}
+
+// repairUserLinksCommand reports links between users and teachers that are
+// one-sided or broken, and the users and teachers without a link
+func repairUserLinksCommand(args []string) int {
+	fs := flag.NewFlagSet("repair-user-links", flag.ExitOnError)
+	commit := fs.Bool("commit", false, "apply the fixes instead of only reporting them")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var report UserLinkReport
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		report, err = repairUserLinks(ctx, store, *commit, true)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repair failed:", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tUSER\tTEACHER\tREASON")
+	for _, fix := range report.Fixes {
+		user, teacher := fix.UserEmail, fix.TeacherEmail
+		if user == "" {
+			user = fix.UserID.Hex()
+		}
+		if teacher == "" {
+			teacher = fix.TeacherID.Hex()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", fix.Action, user, teacher, fix.Reason)
+	}
+	w.Flush()
+	for _, orphan := range report.OrphanUsers {
+		fmt.Printf("user without a teacher: %s (%s)\n", orphan.Email, orphan.ID.Hex())
+	}
+	for _, orphan := range report.OrphanTeachers {
+		fmt.Printf("teacher without an account: %s <%s> (%s)\n", orphan.Name, orphan.Email, orphan.ID.Hex())
+	}
+
+	if *commit {
+		fmt.Printf("%d fixes applied\n", len(report.Fixes))
+	} else {
+		fmt.Printf("%d fixes needed (dry run, use -commit to apply)\n", len(report.Fixes))
+	}
+	return 0
+}
+
+// resetTwoFactorCommand turns off two-factor authentication for an account
+// and ends its sessions, for an admin who has lost their authenticator when
+// no other admin can reset it
+func resetTwoFactorCommand(args []string) int {
+	fs := flag.NewFlagSet("reset-2fa", flag.ExitOnError)
+	email := fs.String("email", "", "account email (required)")
+	fs.Parse(args)
+
+	if *email == "" {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		user, err := store.Users().GetByEmail(ctx, *email)
+		if err != nil {
+			return err
+		}
+		if err := resetTwoFactor(ctx, user); err != nil {
+			return err
+		}
+		return store.LoginFailures().Clear(ctx, normalizeEmail(user.Email))
+	})
+	if errors.Is(err, ErrNotFound) {
+		fmt.Fprintln(os.Stderr, "no account with email", *email)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to reset two-factor authentication:", err)
+		return 1
+	}
+
+	fmt.Printf("reset two-factor authentication for %s\n", *email)
+	return 0
+}
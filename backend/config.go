@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // school timezones must load on hosts without a zoneinfo database
+)
+
+// Config holds the settings read from the environment at startup
+type Config struct {
+	Storage         string
+	MongoURI        string
+	MongoDatabase   string
+	PostgresURL     string
+	AuthSecret      []byte
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// SchoolLocation is the timezone event dates and times are entered in
+	SchoolLocation *time.Location
+	// CheckInCodeTTL is how often event check-in codes rotate
+	CheckInCodeTTL time.Duration
+	// CheckInURL, if set, is the page check-in QR codes link to; the code is
+	// appended as the code query parameter
+	CheckInURL string
+	// WithdrawCutoff is how long before an event starts teachers can still
+	// withdraw from roles they signed up for
+	WithdrawCutoff time.Duration
+	// ApprovalPoints, if set, makes sign-ups for roles worth at least that
+	// many points wait for an admin's approval
+	ApprovalPoints int
+	// RepricePolicy says what happens to points already awarded for a role
+	// when its point value changes: "keep" or "adjust"
+	RepricePolicy string
+	// AppURL is the address of the web app; links in emails point there
+	AppURL string
+	// RequireEmailVerification stops accounts logging in before their email
+	// address is verified
+	RequireEmailVerification bool
+	EmailVerificationTTL     time.Duration
+	PasswordResetTTL         time.Duration
+	// MailSender is how mail is delivered: "log", "file" or "smtp". It
+	// defaults to "file" so that a local setup can follow verification links.
+	MailSender string
+	MailFrom   string
+	// MailDir is where the file sender writes messages
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	// PasswordMinLength and PasswordMinClasses are the rules new passwords
+	// must meet. The classes are lower case letters, upper case letters,
+	// digits and everything else.
+	PasswordMinLength  int
+	PasswordMinClasses int
+	// LoginMaxFailures failed logins lock an account for LoginLockout, which
+	// doubles with each further failure up to LoginMaxLockout. Failures older
+	// than LoginFailureWindow are forgotten.
+	LoginMaxFailures   int
+	LoginLockout       time.Duration
+	LoginMaxLockout    time.Duration
+	LoginFailureWindow time.Duration
+	// LoginIPLimit failed logins from one address within LoginIPWindow stop
+	// it logging in until the oldest of them is older than the window
+	LoginIPLimit  int
+	LoginIPWindow time.Duration
+	// TrustedProxies are the proxies whose X-Forwarded-For header is believed
+	// when working out a client's address
+	TrustedProxies []string
+	// TOTPIssuer names the service in authenticator apps
+	TOTPIssuer string
+	// RequireAdminTwoFactor keeps admins without two-factor authentication
+	// out of everything but enrolling
+	RequireAdminTwoFactor bool
+}
+
+var config Config
+
+// getEnv returns the value of an environment variable or a fallback
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvDuration parses a duration such as "15m" from the environment
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// getEnvInt parses a whole number from the environment
+func getEnvInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvBool parses true or false from the environment
+func getEnvBool(key string, fallback bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("invalid %s %q, using %t", key, value, fallback)
+		return fallback
+	}
+	return b
+}
+
+// getEnvList splits a comma-separated list from the environment
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvLocation loads an IANA timezone such as "Asia/Kolkata" named in the
+// environment
+func getEnvLocation(key string, fallback *time.Location) *time.Location {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		log.Printf("invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return loc
+}
+
+func loadConfig() {
+	config = Config{
+		Storage:         getEnv("STORAGE", "mongo"),
+		MongoURI:        getEnv("MONGO_URI", "mongodb://localhost:27017"),
+		MongoDatabase:   getEnv("MONGO_DATABASE", "schoolEvents"),
+		PostgresURL:     getEnv("DATABASE_URL", "postgres://localhost:5432/schoolevents?sslmode=disable"),
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+		SchoolLocation:  getEnvLocation("SCHOOL_TIMEZONE", time.UTC),
+		CheckInCodeTTL:  getEnvDuration("CHECKIN_CODE_TTL", time.Minute),
+		CheckInURL:      getEnv("CHECKIN_URL", ""),
+		WithdrawCutoff:  getEnvDuration("WITHDRAW_CUTOFF", 24*time.Hour),
+		ApprovalPoints:  getEnvInt("APPROVAL_POINTS", 0),
+		RepricePolicy:   getEnv("REPRICE_POLICY", repriceKeep),
+
+		AppURL:                   strings.TrimRight(getEnv("APP_URL", "http://localhost:3000"), "/"),
+		RequireEmailVerification: getEnvBool("REQUIRE_EMAIL_VERIFICATION", true),
+		EmailVerificationTTL:     getEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		PasswordResetTTL:         getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		MailSender:               getEnv("MAIL_SENDER", "file"),
+		MailFrom:                 getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailDir:                  getEnv("MAIL_DIR", "mail"),
+		SMTPHost:                 getEnv("SMTP_HOST", ""),
+		SMTPPort:                 getEnv("SMTP_PORT", "587"),
+		SMTPUsername:             getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:             getEnv("SMTP_PASSWORD", ""),
+
+		PasswordMinLength:  getEnvInt("PASSWORD_MIN_LENGTH", 10),
+		PasswordMinClasses: getEnvInt("PASSWORD_MIN_CLASSES", 2),
+		LoginMaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginLockout:       getEnvDuration("LOGIN_LOCKOUT", time.Minute),
+		LoginMaxLockout:    getEnvDuration("LOGIN_MAX_LOCKOUT", time.Hour),
+		LoginFailureWindow: getEnvDuration("LOGIN_FAILURE_WINDOW", 24*time.Hour),
+		LoginIPLimit:       getEnvInt("LOGIN_IP_LIMIT", 50),
+		LoginIPWindow:      getEnvDuration("LOGIN_IP_WINDOW", 15*time.Minute),
+		TrustedProxies:     getEnvList("TRUSTED_PROXIES"),
+
+		TOTPIssuer:            getEnv("TOTP_ISSUER", "School Events"),
+		RequireAdminTwoFactor: getEnvBool("REQUIRE_ADMIN_2FA", false),
+	}
+
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		// Without a configured secret every restart invalidates issued tokens
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		secret = hex.EncodeToString(buf)
+		log.Println("AUTH_SECRET not set, using a random secret for this process")
+	}
+	config.AuthSecret = []byte(secret)
+}
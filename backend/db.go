@@ -1,31 +1,29 @@
 package main
 
 import (
-	"context"
-	"time"
+	"errors"
+	"net/http"
 
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/gin-gonic/gin"
 )
 
-func initMongoDB() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// requestError aborts a transaction with a specific HTTP response
+type requestError struct {
+	status  int
+	message string
+}
 
-	// Replace with your MongoDB connection string
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
-	var err error
-	client, err = mongo.Connect(ctx, clientOptions)
-	if err != nil {
-		return err
-	}
+func (e *requestError) Error() string {
+	return e.message
+}
 
-	// Ping the database to verify connection
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		return err
+// respondTransactionError writes the response for an error returned by
+// store.RunInTransaction
+func respondTransactionError(c *gin.Context, err error, fallback string) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		c.JSON(reqErr.status, gin.H{"error": reqErr.message})
+		return
 	}
-
-	db = client.Database("schoolEvents") // Replace with your database name
-	return nil
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
 }
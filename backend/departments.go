@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DepartmentRequest is the body accepted when creating or renaming a department
+type DepartmentRequest struct {
+	Name string `json:"department_name" binding:"required"`
+}
+
+// resolveTeacherDepartment points a teacher at an existing department,
+// identified by DepartmentID or else by Departmentname, and copies the
+// department's name onto the teacher. Teachers without either are left
+// without a department.
+func resolveTeacherDepartment(ctx context.Context, teacher *Teacher) error {
+	var department Department
+	var err error
+	switch name := strings.TrimSpace(teacher.Departmentname); {
+	case !teacher.DepartmentID.IsZero():
+		department, err = store.Departments().Get(ctx, teacher.DepartmentID)
+	case name != "":
+		department, err = store.Departments().GetByName(ctx, name)
+	default:
+		return nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		return &requestError{http.StatusBadRequest, "Department does not exist"}
+	}
+	if err != nil {
+		return err
+	}
+
+	teacher.DepartmentID = department.ID
+	teacher.Departmentname = department.Name
+	return nil
+}
+
+// checkEventDepartment rejects an event organised by a department that does
+// not exist
+func checkEventDepartment(ctx context.Context, event Event) error {
+	if event.DepartmentID.IsZero() {
+		return nil
+	}
+	_, err := store.Departments().Get(ctx, event.DepartmentID)
+	if errors.Is(err, ErrNotFound) {
+		return &requestError{http.StatusBadRequest, "Department does not exist"}
+	}
+	return err
+}
+
+// migrateDepartments links teachers that only have a free-text department
+// name to a department record, creating departments as needed
+func migrateDepartments(ctx context.Context, s Store) error {
+	teachers, err := s.Teachers().List(ctx, TeacherFilter{})
+	if err != nil {
+		return err
+	}
+
+	for _, teacher := range teachers {
+		name := strings.TrimSpace(teacher.Departmentname)
+		if !teacher.DepartmentID.IsZero() || name == "" {
+			continue
+		}
+
+		department, err := s.Departments().GetByName(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			department = Department{ID: primitive.NewObjectID(), Name: name}
+			err = s.Departments().Create(ctx, department)
+		}
+		if err != nil {
+			return err
+		}
+
+		teacher.DepartmentID = department.ID
+		teacher.Departmentname = department.Name
+		if err := s.Teachers().Update(ctx, teacher); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// departmentParam parses the :id parameter and loads the department,
+// writing the error response itself if that fails
+func departmentParam(ctx context.Context, c *gin.Context) (Department, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID format"})
+		return Department{}, false
+	}
+
+	department, err := store.Departments().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Department not found"})
+		return Department{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return Department{}, false
+	}
+	return department, true
+}
+
+// ListDepartments handler
+func ListDepartments(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	departments, err := store.Departments().List(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if departments == nil {
+		departments = []Department{}
+	}
+
+	c.JSON(http.StatusOK, departments)
+}
+
+// GetDepartment handler
+func GetDepartment(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	department, ok := departmentParam(ctx, c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, department)
+}
+
+// CreateDepartment handler
+func CreateDepartment(c *gin.Context) {
+	var req DepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A department name is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	department := Department{ID: primitive.NewObjectID(), Name: strings.TrimSpace(req.Name)}
+	err := store.Departments().Create(ctx, department)
+	if errors.Is(err, ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A department with this name already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, department)
+}
+
+// UpdateDepartment renames a department and the copy of its name kept on
+// each of its teachers
+func UpdateDepartment(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID format"})
+		return
+	}
+
+	var req DepartmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A department name is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	department := Department{ID: id, Name: strings.TrimSpace(req.Name)}
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		err := store.Departments().Update(ctx, department)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return &requestError{http.StatusNotFound, "Department not found"}
+		case errors.Is(err, ErrDuplicate):
+			return &requestError{http.StatusConflict, "A department with this name already exists"}
+		case err != nil:
+			return err
+		}
+
+		teachers, err := store.Teachers().List(ctx, TeacherFilter{DepartmentID: id})
+		if err != nil {
+			return err
+		}
+		for _, teacher := range teachers {
+			teacher.Departmentname = department.Name
+			if err := store.Teachers().Update(ctx, teacher); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to update department")
+		return
+	}
+
+	c.JSON(http.StatusOK, department)
+}
+
+// DeleteDepartment deletes a department that no teacher or event belongs to
+func DeleteDepartment(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		teachers, err := store.Teachers().List(ctx, TeacherFilter{DepartmentID: id})
+		if err != nil {
+			return err
+		}
+		if len(teachers) > 0 {
+			return &requestError{http.StatusConflict, "Department still has teachers; move them to another department first"}
+		}
+		events, err := store.Events().Count(ctx, EventFilter{DepartmentID: id})
+		if err != nil {
+			return err
+		}
+		if events > 0 {
+			return &requestError{http.StatusConflict, "Department still organises events; move them to another department first"}
+		}
+
+		err = store.Departments().Delete(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Department not found"}
+		}
+		return err
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to delete department")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Department deleted successfully"})
+}
+
+// GetDepartmentPoints lists the active teachers of a department with their
+// ledger balances, highest first, and the department's total. The term_id query
+// parameter scopes the balances like GetTopTeachers.
+func GetDepartmentPoints(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	department, ok := departmentParam(ctx, c)
+	if !ok {
+		return
+	}
+	term, ok := termScope(ctx, c)
+	if !ok {
+		return
+	}
+
+	teacherList, err := store.Teachers().List(ctx, TeacherFilter{DepartmentID: department.ID, Active: true})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	balances, err := store.Ledger().Balances(ctx, LedgerFilter{TermID: term.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type TeacherPoints struct {
+		ID     primitive.ObjectID `json:"teacher_id"`
+		Name   string             `json:"teacher_name"`
+		Points int                `json:"points"`
+	}
+
+	total := 0
+	teachers := make([]TeacherPoints, 0, len(teacherList))
+	for _, teacher := range teacherList {
+		points := balances[teacher.ID]
+		total += points
+		teachers = append(teachers, TeacherPoints{ID: teacher.ID, Name: teacher.Name, Points: points})
+	}
+	sort.SliceStable(teachers, func(i, j int) bool { return teachers[i].Points > teachers[j].Points })
+
+	c.JSON(http.StatusOK, gin.H{
+		"department_id":   department.ID,
+		"department_name": department.Name,
+		"term":            scopeResponse(term),
+		"total_points":    total,
+		"teachers":        teachers,
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Page sizes for the event listing. A listing is only paged when the client
+// asks for a limit or passes a cursor; otherwise every match is returned.
+const (
+	defaultEventLimit = 50
+	maxEventLimit     = 200
+)
+
+// Values of the when query parameter
+const (
+	eventsUpcoming = "upcoming"
+	eventsOngoing  = "ongoing"
+	eventsPast     = "past"
+)
+
+// eventCursor is the position after the last event of a page. It is sent to
+// clients base64 encoded and is only valid with the sort it was made for.
+type eventCursor struct {
+	Sort     string             `json:"o"`
+	ID       primitive.ObjectID `json:"id"`
+	StartsAt time.Time          `json:"s,omitempty"`
+	Name     string             `json:"n,omitempty"`
+}
+
+func encodeEventCursor(sort string, event Event) string {
+	cursor := eventCursor{Sort: sort, ID: event.ID, StartsAt: event.StartsAt, Name: event.Name}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeEventCursor(value, sort string) (*Event, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	var cursor eventCursor
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || cursor.ID.IsZero() {
+		return nil, &requestError{http.StatusBadRequest, "Invalid cursor"}
+	}
+	if cursor.Sort != sort {
+		return nil, &requestError{http.StatusBadRequest, "Cursor was issued for a different sort"}
+	}
+	return &Event{ID: cursor.ID, StartsAt: cursor.StartsAt, Name: cursor.Name}, nil
+}
+
+// parseEventBound parses a from or to parameter: an RFC 3339 instant, or a
+// date in the school timezone. A date used as an upper bound includes the
+// whole day.
+func parseEventBound(name, value string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, ok := parseEventDate(value)
+	if !ok {
+		return time.Time{}, &requestError{http.StatusBadRequest, name + " must be a date in YYYY-MM-DD format or an RFC 3339 time"}
+	}
+	if upper {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+// laterOf returns the later of two bounds, ignoring unset ones
+func laterOf(a, b time.Time) time.Time {
+	if a.IsZero() || b.After(a) {
+		return b
+	}
+	return a
+}
+
+// earlierOf returns the earlier of two bounds, ignoring unset ones
+func earlierOf(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// queryID parses an optional ID query parameter
+func queryID(c *gin.Context, name string) (primitive.ObjectID, error) {
+	value := c.Query(name)
+	if value == "" {
+		return primitive.NilObjectID, nil
+	}
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return id, &requestError{http.StatusBadRequest, "Invalid " + name + " format"}
+	}
+	return id, nil
+}
+
+// eventQuery reads the filter and page of an event listing from the query
+// string:
+//
+//	from, to      events overlapping the range (dates or RFC 3339 times)
+//	when          upcoming, ongoing or past, relative to now
+//	q             text in the name or description
+//	status        events in a lifecycle status
+//	term_id       events in a term
+//	department_id events organised by a department
+//	sort          created (default), starts_at or name; prefix "-" to reverse
+//	limit         page size, at most 200 (50 when only cursor is given)
+//	cursor        next_cursor of the previous page
+func eventQuery(c *gin.Context, now time.Time) (EventFilter, EventPage, error) {
+	var filter EventFilter
+	var page EventPage
+	var err error
+
+	if filter.TermID, err = queryID(c, "term_id"); err != nil {
+		return filter, page, err
+	}
+	if filter.DepartmentID, err = queryID(c, "department_id"); err != nil {
+		return filter, page, err
+	}
+	filter.Search = strings.TrimSpace(c.Query("q"))
+	switch filter.Status = c.Query("status"); filter.Status {
+	case "", eventDraft, eventPublished, eventInProgress, eventCompleted, eventCancelled:
+	default:
+		return filter, page, &requestError{http.StatusBadRequest, "Unknown event status " + filter.Status}
+	}
+
+	if value := c.Query("from"); value != "" {
+		if filter.EndsAfter, err = parseEventBound("from", value, false); err != nil {
+			return filter, page, err
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if filter.StartsBefore, err = parseEventBound("to", value, true); err != nil {
+			return filter, page, err
+		}
+	}
+	switch c.Query("when") {
+	case "":
+	case eventsUpcoming:
+		filter.StartsFrom = laterOf(filter.StartsFrom, now)
+	case eventsOngoing:
+		filter.StartsBefore = earlierOf(filter.StartsBefore, now.Add(time.Nanosecond))
+		filter.EndsAfter = laterOf(filter.EndsAfter, now)
+	case eventsPast:
+		filter.EndsBy = earlierOf(filter.EndsBy, now)
+	default:
+		return filter, page, &requestError{http.StatusBadRequest, "when must be upcoming, ongoing or past"}
+	}
+
+	sort := c.DefaultQuery("sort", eventSortCreated)
+	page.Sort = strings.TrimPrefix(sort, "-")
+	page.Descending = page.Sort != sort
+	switch page.Sort {
+	case eventSortCreated, eventSortName:
+	case eventSortStart:
+		filter.Scheduled = true
+	default:
+		return filter, page, &requestError{http.StatusBadRequest, "sort must be created, starts_at or name"}
+	}
+
+	if c.Query("cursor") != "" {
+		page.Limit = defaultEventLimit
+	}
+	if value := c.Query("limit"); value != "" {
+		page.Limit, err = strconv.Atoi(value)
+		if err != nil || page.Limit < 1 || page.Limit > maxEventLimit {
+			return filter, page, &requestError{http.StatusBadRequest, "limit must be between 1 and " + strconv.Itoa(maxEventLimit)}
+		}
+	}
+	if value := c.Query("cursor"); value != "" {
+		if page.After, err = decodeEventCursor(value, sort); err != nil {
+			return filter, page, err
+		}
+	}
+	return filter, page, nil
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,77 +20,99 @@ func Signup(c *gin.Context) {
 		return
 	}
 
-	// if user.Role != "admin" && user.Role != "teacher" {
-	// 	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
-	// 	return
-	// }
-	user.Role = "teacher"
+	user.Email = normalizeEmail(user.Email)
+	if !validEmail(user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+
+	if err := checkPassword(user.Password, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Self-signup always creates teachers. Admins come from the create-admin
+	// command or are promoted through PUT /users/:id/role.
+	user.Role = userRoleTeacher
+	user.EmailVerifiedAt = nil
+	user.TOTPEnabledAt = nil
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	userCollectionRef := db.Collection(userCollection)
-	teacherCollectionRef := db.Collection(teacherCollection)
-
 	// Check if email already exists in users
-	count, err := userCollectionRef.CountDocuments(ctx, bson.M{"email": user.Email})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	_, err := store.Users().GetByEmail(ctx, user.Email)
+	if err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already in use"})
 		return
 	}
-	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already in use"})
+	if !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	// Check if a teacher exists with the same email
-	var existingTeacher Teacher
-	err = teacherCollectionRef.FindOne(ctx, bson.M{"email": user.Email}).Decode(&existingTeacher)
-	if err == nil {
-		// Teacher found, use Teacher's UserID as the new User's _id
-		user.ID = existingTeacher.UserID
-		user.UserID = existingTeacher.UserID
-	} else {
-		// No matching teacher, generate new ObjectID
-		user.ID = primitive.NewObjectID()
-		user.UserID = user.ID
-	}
+	user.ID = primitive.NewObjectID()
+	user.UserID = primitive.NilObjectID
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	user.Password, err = hashPassword(user.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
-	user.Password = string(hashedPassword)
 
-	// Insert user with assigned ID
-	_, err = userCollectionRef.InsertOne(ctx, user)
+	// A teacher with the same email that has no account yet is linked to
+	// the new one
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		user.UserID = primitive.NilObjectID
+		if err := store.Users().Create(ctx, user); err != nil {
+			return err
+		}
+		teacher, err := store.Teachers().GetByEmail(ctx, user.Email)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return linkTeacherUser(ctx, &teacher)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
+	// The account exists either way; a lost email can be sent again
+	if err := sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("failed to send verification email to %s: %v", user.Email, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "User created successfully",
+		"message": "User created successfully; check your email to verify your address",
 		"user_id": user.ID,
 	})
 }
 
-// Login handler
+// LoginResponse is returned once a login succeeds
+type LoginResponse struct {
+	Message string             `json:"message"`
+	Role    string             `json:"role,omitempty"`
+	Name    string             `json:"name,omitempty"`
+	UserID  primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	// TwoFactorSetupRequired means the account may do nothing but enroll in
+	// two-factor authentication until it has
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+	TokenPair
+}
+
+// Login handler. Accounts with two-factor authentication get an mfa_token
+// instead of a session, to finish logging in with LoginTwoFactor.
 func Login(c *gin.Context) {
 	type LoginRequest struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 
-	type LoginResponse struct {
-		Message string             `json:"message"`
-		Role    string             `json:"role,omitempty"`
-		Name    string             `json:"name,omitempty"`
-		UserID  primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
-	}
-
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
@@ -99,43 +122,112 @@ func Login(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var user User
-	collection := db.Collection(userCollection)
-	err := collection.FindOne(ctx, bson.M{"email": req.Email}).Decode(&user)
+	email := normalizeEmail(req.Email)
+	user, err := store.Users().GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !checkLoginAllowed(ctx, c, email, user.ID) {
+		return
+	}
 	if err != nil {
+		recordLoginFailure(ctx, email, c.ClientIP(), loginUnknownEmail, primitive.NilObjectID)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		recordLoginFailure(ctx, email, c.ClientIP(), loginBadPassword, user.ID)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
+	if config.RequireEmailVerification && user.EmailVerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address has not been verified"})
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		token, expiresAt, err := signMFAToken(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "Enter the code from your authenticator app",
+			"two_factor_required": true,
+			"mfa_token":           token,
+			"mfa_expires_at":      expiresAt,
+		})
+		return
+	}
+
+	completeLogin(ctx, c, user)
+}
+
+// completeLogin starts a session for a user who has passed every login step
+func completeLogin(ctx context.Context, c *gin.Context, user User) {
+	email := normalizeEmail(user.Email)
+	if err := store.LoginFailures().Clear(ctx, email); err != nil {
+		log.Printf("failed to clear login failures for %s: %v", email, err)
+	}
+
+	tokens, err := startSession(ctx, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session"})
+		return
+	}
 
 	c.JSON(http.StatusOK, LoginResponse{
-		Message: "Login successful",
-		Name:    user.Name,
-		Role:    user.Role,
-		UserID:  user.UserID,
+		Message:                "Login successful",
+		Name:                   user.Name,
+		Role:                   user.Role,
+		UserID:                 user.UserID,
+		TwoFactorSetupRequired: twoFactorSetupRequired(user),
+		TokenPair:              tokens,
 	})
 }
 
-// CreateEvent handler
+// CreateEvent handler. Without a term_id the event joins the open term
+// containing its start date. Events start as drafts unless created as
+// published.
 func CreateEvent(c *gin.Context) {
 	var event Event
 	if err := c.ShouldBindJSON(&event); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if event.Status == "" {
+		event.Status = eventDraft
+	}
+	if event.Status != eventDraft && event.Status != eventPublished {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A new event must be draft or published"})
+		return
+	}
+	event.CompletedAt = nil
+	event.CancelledAt = nil
+	event.CancelledBy = primitive.NilObjectID
+	event.CancelPolicy = ""
+	event.CancelReason = ""
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	collection := db.Collection(eventCollection)
+	if err := normalizeEventSchedule(&event); err != nil {
+		respondTransactionError(c, err, "Invalid event schedule")
+		return
+	}
+
 	event.ID = primitive.NewObjectID()
 	event.EventID = event.ID
-	_, err := collection.InsertOne(ctx, event)
-	if err != nil {
+	if err := checkEventDepartment(ctx, event); err != nil {
+		respondTransactionError(c, err, "Failed to create event")
+		return
+	}
+	if err := resolveEventTerm(ctx, &event); err != nil {
+		respondTransactionError(c, err, "Failed to create event")
+		return
+	}
+	if err := store.Events().Create(ctx, event); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -143,25 +235,45 @@ func CreateEvent(c *gin.Context) {
 	c.JSON(http.StatusOK, event)
 }
 
-// ListEvents handler
+// ListEvents returns the events matching the query parameters read by
+// eventQuery, or one page of them when a limit or cursor is given. The body
+// stays a plain array; the number of matching events is sent in
+// X-Total-Count and the cursor for the next page, if there is one, in
+// X-Next-Cursor.
 func ListEvents(c *gin.Context) {
+	filter, page, err := eventQuery(c, time.Now())
+	if err != nil {
+		respondTransactionError(c, err, "Invalid event query")
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	collection := db.Collection(eventCollection)
-	cursor, err := collection.Find(ctx, bson.M{})
+	total, err := store.Events().Count(ctx, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var events []Event
-	if err := cursor.All(ctx, &events); err != nil {
+	// One extra event tells whether there is a next page
+	limit := page.Limit
+	if limit > 0 {
+		page.Limit++
+	}
+	events, err := store.Events().List(ctx, filter, page)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if events == nil {
+		events = []Event{}
+	}
 
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+		c.Header("X-Next-Cursor", encodeEventCursor(c.DefaultQuery("sort", eventSortCreated), events[limit-1]))
+	}
 	c.JSON(http.StatusOK, events)
 }
 
@@ -177,9 +289,7 @@ func GetEventByID(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	collection := db.Collection(eventCollection)
-	var event Event
-	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&event)
+	event, err := store.Events().Get(ctx, objectID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
 		return
@@ -188,7 +298,8 @@ func GetEventByID(c *gin.Context) {
 	c.JSON(http.StatusOK, event)
 }
 
-// UpdateEvent handler
+// UpdateEvent handler. Sending a term_id moves the event to that term, which
+// is only allowed before anyone has been assigned to it.
 func UpdateEvent(c *gin.Context) {
 	id := c.Param("id")
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -197,108 +308,66 @@ func UpdateEvent(c *gin.Context) {
 		return
 	}
 
-	var event Event
-	if err := c.ShouldBindJSON(&event); err != nil {
+	var req Event
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	collection := db.Collection(eventCollection)
-	update := bson.M{
-		"$set": bson.M{
-			"name":        event.Name,
-			"start_date":  event.StartDate,
-			"start_time":  event.StartTime,
-			"end_date":    event.EndDate,
-			"end_time":    event.EndTime,
-			"description": event.Description,
-		},
-	}
-
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := normalizeEventSchedule(&req); err != nil {
+		respondTransactionError(c, err, "Invalid event schedule")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Event updated successfully"})
-}
-
-func GetTopTeachers(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// MongoDB aggregation pipeline to get top teachers
-	pipeline := mongo.Pipeline{
-		{
-			{"$lookup", bson.M{
-				"from":         teacherAssignmentCollection,
-				"localField":   "_id",
-				"foreignField": "teacher_id",
-				"as":           "assignments",
-			}},
-		},
-		{
-			{"$unwind", bson.M{
-				"path":                       "$assignments",
-				"preserveNullAndEmptyArrays": true,
-			}},
-		},
-		{
-			{"$lookup", bson.M{
-				"from":         roleCollection,
-				"localField":   "assignments.role_id",
-				"foreignField": "_id",
-				"as":           "role",
-			}},
-		},
-		{
-			{"$unwind", bson.M{
-				"path":                       "$role",
-				"preserveNullAndEmptyArrays": true,
-			}},
-		},
-		{
-			{"$group", bson.M{
-				"_id":          "$_id",
-				"teacher_name": bson.M{"$first": "$name"},
-				"total_points": bson.M{"$sum": bson.M{
-					"$ifNull": []interface{}{"$role.point", 0},
-				}},
-			}},
-		},
-		{
-			{"$sort", bson.M{"total_points": -1}},
-		},
-		{
-			{"$limit", 10},
-		},
-	}
-
-	collection := db.Collection(teacherCollection)
-	cursor, err := collection.Aggregate(ctx, pipeline)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer cursor.Close(ctx)
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		event, err := store.Events().Get(ctx, objectID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return &requestError{http.StatusNotFound, "Event not found"}
+			}
+			return err
+		}
+		if err := requireEventOpen(event); err != nil {
+			return err
+		}
 
-	type TopTeacher struct {
-		ID     primitive.ObjectID `json:"teacher_id" bson:"_id"`
-		Name   string             `json:"teacher_name" bson:"teacher_name"`
-		Points int                `json:"points" bson:"total_points"`
-	}
+		event.Name = req.Name
+		event.StartDate = req.StartDate
+		event.StartTime = req.StartTime
+		event.EndDate = req.EndDate
+		event.EndTime = req.EndTime
+		event.StartsAt = req.StartsAt
+		event.EndsAt = req.EndsAt
+		event.AllDay = req.AllDay
+		event.Description = req.Description
+		event.DepartmentID = req.DepartmentID
+		if err := checkEventDepartment(ctx, event); err != nil {
+			return err
+		}
 
-	var teachers []TopTeacher
-	if err := cursor.All(ctx, &teachers); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if !req.TermID.IsZero() && req.TermID != event.TermID {
+			if _, err := requireOpenTerm(ctx, req.TermID); err != nil {
+				return err
+			}
+			assigned, err := store.Assignments().Count(ctx, AssignmentFilter{EventID: event.ID})
+			if err != nil {
+				return err
+			}
+			if assigned > 0 {
+				return &requestError{http.StatusConflict, "An event with assignments cannot be moved to another term"}
+			}
+			event.TermID = req.TermID
+		}
+		return store.Events().Update(ctx, event)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to update event")
 		return
 	}
 
-	c.JSON(http.StatusOK, teachers)
+	c.JSON(http.StatusOK, gin.H{"message": "Event updated successfully"})
 }
 
 func CreateRole(c *gin.Context) {
@@ -320,42 +389,35 @@ func CreateRole(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	roleCollection := db.Collection("roles")
-	eventCollection := db.Collection("events")
-
-	// Retrieve the Event document to get event name BEFORE inserting role
-	var event Event
-	err = eventCollection.FindOne(ctx, bson.M{"_id": oid}).Decode(&event)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
-		return
-	}
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		// Retrieve the Event document to get event name BEFORE inserting role
+		event, err := store.Events().Get(ctx, oid)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return &requestError{http.StatusNotFound, "Event not found"}
+			}
+			return err
+		}
+		if err := requireEventOpen(event); err != nil {
+			return err
+		}
 
-	// Assign all fields before inserting
-	role.ID = primitive.NewObjectID()
-	role.EventID = oid
-	role.EventName = event.Name // <- now it will get saved
-	role.RoleID = role.ID
+		// Assign all fields before inserting
+		role.ID = primitive.NewObjectID()
+		role.EventID = oid
+		role.EventName = event.Name
+		role.RoleID = role.ID
 
-	// Insert the new role document into roles collection
-	_, err = roleCollection.InsertOne(ctx, role)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert role: " + err.Error()})
-		return
-	}
+		if err := store.Roles().Create(ctx, role); err != nil {
+			return err
+		}
 
-	// Update the event document: push role object with id and name to the roles array
-	update := bson.M{
-		"$push": bson.M{
-			"roles": bson.M{
-				"id":   role.ID,
-				"name": role.Name,
-			},
-		},
-	}
-	_, err = eventCollection.UpdateOne(ctx, bson.M{"_id": oid}, update)
+		// Add the role's id and name to the event's roles array
+		event.Roles = append(event.Roles, RoleRef{ID: role.ID, Name: role.Name})
+		return store.Events().Update(ctx, event)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event with role: " + err.Error()})
+		respondTransactionError(c, err, "Failed to create role")
 		return
 	}
 
@@ -372,89 +434,109 @@ func CreateTeacher(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	collection := db.Collection(teacherCollection)
+	// The department, if any, must already exist
+	if err := resolveTeacherDepartment(ctx, &teacher); err != nil {
+		respondTransactionError(c, err, "Failed to look up department")
+		return
+	}
+
 	teacher.ID = primitive.NewObjectID()
-	teacher.UserID = teacher.ID
-	_, err := collection.InsertOne(ctx, teacher)
+	teacher.Email = normalizeEmail(teacher.Email)
+	teacher.UserID = primitive.NilObjectID
+	// Points only ever come from the ledger
+	teacher.Point = 0
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Teachers().Create(ctx, teacher); err != nil {
+			return err
+		}
+		// Link the account with the same email, if it has no teacher yet
+		return linkTeacherUser(ctx, &teacher)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Update user if email matches
-	userCollectionRef := db.Collection(userCollection)
-	filter := bson.M{"email": teacher.Email}
-	update := bson.M{"$set": bson.M{"user_id": teacher.ID}} // Add new field
-	_, _ = userCollectionRef.UpdateOne(ctx, filter, update)
-
 	c.JSON(http.StatusOK, teacher)
 }
 
-// ListTeachers handler
+// ListTeachers handler. ?department_id= limits the list to one department.
+// Deactivated teachers are left out unless ?include_inactive=true.
 func ListTeachers(c *gin.Context) {
+	var filter TeacherFilter
+	if departmentID := c.Query("department_id"); departmentID != "" {
+		oid, err := primitive.ObjectIDFromHex(departmentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID format"})
+			return
+		}
+		filter.DepartmentID = oid
+	}
+	listTeachers(c, filter)
+}
+
+// ListDepartmentTeachers lists the teachers of the department in :id
+func ListDepartmentTeachers(c *gin.Context) {
+	departmentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID format"})
+		return
+	}
+	listTeachers(c, TeacherFilter{DepartmentID: departmentID})
+}
+
+func listTeachers(c *gin.Context, filter TeacherFilter) {
+	filter.Active = c.Query("include_inactive") != "true"
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// MongoDB aggregation pipeline to get teachers with department info
-	pipeline := mongo.Pipeline{
-		{
-			{"$lookup", bson.M{
-				"from":         departmentCollection,
-				"localField":   "department_id",
-				"foreignField": "_id",
-				"as":           "department",
-			}},
-		},
-		{
-			{"$unwind", bson.M{
-				"path":                       "$department",
-				"preserveNullAndEmptyArrays": true,
-			}},
-		},
-		{
-			{"$project", bson.M{
-				"_id":             1,
-				"name":            1,
-				"email":           1,
-				"profile_photo":   1,
-				"point":           1,
-				"department_name": "$departmentname",
-			}},
-		},
-	}
-
-	collection := db.Collection(teacherCollection)
-	cursor, err := collection.Aggregate(ctx, pipeline)
+	teacherList, err := store.Teachers().List(ctx, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
 
 	type TeacherWithDepartment struct {
-		ID             primitive.ObjectID `json:"id" bson:"_id"`
-		Name           string             `json:"name" bson:"name"`
-		Email          string             `json:"email" bson:"email"`
-		ProfilePhoto   string             `json:"profile_photo" bson:"profile_photo"`
-		DepartmentName string             `json:"department_name" bson:"department_name"`
-		Point          int                `json:"point" bson:"point"`
-	}
-
-	var teachers []TeacherWithDepartment
-	if err := cursor.All(ctx, &teachers); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		ID             primitive.ObjectID `json:"id"`
+		Name           string             `json:"name"`
+		Email          string             `json:"email"`
+		ProfilePhoto   string             `json:"profile_photo"`
+		DepartmentID   primitive.ObjectID `json:"department_id,omitempty"`
+		DepartmentName string             `json:"department_name"`
+		Point          int                `json:"point"`
+		UserID         primitive.ObjectID `json:"user_id,omitempty"`
+		DeactivatedAt  *time.Time         `json:"deactivated_at,omitempty"`
+	}
+
+	teachers := make([]TeacherWithDepartment, 0, len(teacherList))
+	for _, teacher := range teacherList {
+		teachers = append(teachers, TeacherWithDepartment{
+			ID:             teacher.ID,
+			Name:           teacher.Name,
+			Email:          teacher.Email,
+			ProfilePhoto:   teacher.ProfilePhoto,
+			DepartmentID:   teacher.DepartmentID,
+			DepartmentName: teacher.Departmentname,
+			Point:          teacher.Point,
+			UserID:         teacher.UserID,
+			DeactivatedAt:  teacher.DeactivatedAt,
+		})
 	}
 
 	c.JSON(http.StatusOK, teachers)
 }
 
-// AssignTeacherToRole assigns a teacher to a role and updates their points
+// AssignTeacherToRole assigns a teacher to a role of a published event. The
+// role's points stay pending until the event is completed and the teacher's
+// attendance is confirmed. If the role is full and "waitlist" is set, the
+// teacher joins the role's waitlist instead.
 func AssignTeacherToRole(c *gin.Context) {
 	type AssignmentRequest struct {
 		TeacherID string `json:"teacher_id" binding:"required"`
 		RoleID    string `json:"role_id" binding:"required"`
 		EventID   string `json:"event_id" binding:"required"`
+		Waitlist  bool   `json:"waitlist"`
 	}
 
 	var req AssignmentRequest
@@ -463,7 +545,7 @@ func AssignTeacherToRole(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	// Convert string IDs to ObjectIDs
@@ -485,112 +567,148 @@ func AssignTeacherToRole(c *gin.Context) {
 		return
 	}
 
-	// Get the role details to obtain points and event name
-	roleCollection := db.Collection(roleCollection)
-	var role Role
-	err = roleCollection.FindOne(ctx, bson.M{"_id": roleID}).Decode(&role)
+	var assignment Assignment
+	var entry WaitlistEntry
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		entry = WaitlistEntry{}
+		assignment, err = assignTeacher(ctx, teacherID, roleID, eventID, false)
+		if errors.Is(err, errRoleFull) && req.Waitlist {
+			entry, err = joinWaitlist(ctx, roleID, eventID, teacherID, false)
+		}
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		respondTransactionError(c, err, "Failed to create assignment")
+		return
+	}
+	if !entry.ID.IsZero() {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":        "Role is full; teacher added to the waitlist",
+			"waitlist_entry": entry,
+		})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Teacher assigned to role successfully",
+		"assignment": assignment,
+	})
+}
+
+// assignTeacher places a teacher on a role of a published event, for
+// AssignTeacherToRole and for teachers signing up themselves. It must run in
+// a transaction: it locks the role first, so concurrent assignments to the
+// same role are serialized instead of overbooking it.
+func assignTeacher(ctx context.Context, teacherID, roleID, eventID primitive.ObjectID, selfSignup bool) (Assignment, error) {
+	// Lock the role first so the head count check below cannot race
+	role, err := store.Roles().GetForUpdate(ctx, roleID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Assignment{}, &requestError{http.StatusNotFound, "Role not found"}
+		}
+		return Assignment{}, err
+	}
+	if role.EventID != eventID {
+		return Assignment{}, &requestError{http.StatusBadRequest, "Role does not belong to this event"}
+	}
+
 	// Get the event name
-	eventCollection := db.Collection(eventCollection)
-	var event Event
-	err = eventCollection.FindOne(ctx, bson.M{"_id": eventID}).Decode(&event)
+	event, err := store.Events().Get(ctx, eventID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
-		return
+		if errors.Is(err, ErrNotFound) {
+			return Assignment{}, &requestError{http.StatusNotFound, "Event not found"}
+		}
+		return Assignment{}, err
+	}
+	if event.Status != eventPublished {
+		return Assignment{}, &requestError{http.StatusConflict, "Roles can only be staffed while the event is published"}
 	}
 
 	// Check if the teacher exists
-	teacherCollection := db.Collection(teacherCollection)
-	var teacher Teacher
-	err = teacherCollection.FindOne(ctx, bson.M{"_id": teacherID}).Decode(&teacher)
+	teacher, err := store.Teachers().Get(ctx, teacherID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Teacher not found"})
-		return
+		if errors.Is(err, ErrNotFound) {
+			return Assignment{}, &requestError{http.StatusNotFound, "Teacher not found"}
+		}
+		return Assignment{}, err
+	}
+	if teacher.DeactivatedAt != nil {
+		return Assignment{}, errTeacherDeactivated
 	}
 
 	// Check if this assignment already exists
-	assignmentCollection := db.Collection(teacherAssignmentCollection)
-	count, err := assignmentCollection.CountDocuments(ctx, bson.M{
-		"teacher_id": teacherID,
-		"role_id":    roleID,
-		"event_id":   eventID,
+	count, err := store.Assignments().Count(ctx, AssignmentFilter{
+		TeacherID: teacherID,
+		RoleID:    roleID,
+		EventID:   eventID,
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
+		return Assignment{}, err
 	}
 	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Teacher is already assigned to this role in this event"})
-		return
+		return Assignment{}, &requestError{http.StatusBadRequest, "Teacher is already assigned to this role in this event"}
 	}
 
 	// Check if the role has reached its head count limit
-	assignedCount, err := assignmentCollection.CountDocuments(ctx, bson.M{"role_id": roleID})
+	assignedCount, err := store.Assignments().Count(ctx, AssignmentFilter{RoleID: roleID})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
+		return Assignment{}, err
 	}
-	if int(assignedCount) >= role.HeadCount {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Role has reached its maximum head count"})
-		return
+	if assignedCount >= role.HeadCount {
+		return Assignment{}, errRoleFull
 	}
 
 	// Create the assignment using the exact Assignment struct
 	assignment := Assignment{
-		ID:        primitive.NewObjectID(),
-		EventID:   eventID,
-		EventName: event.Name,
-		TeacherID: teacherID,
-		RoleID:    roleID,
-		RoletName: role.Name,
-	}
-
-	_, err = assignmentCollection.InsertOne(ctx, assignment)
+		ID:           primitive.NewObjectID(),
+		EventID:      eventID,
+		EventName:    event.Name,
+		TeacherID:    teacherID,
+		RoleID:       roleID,
+		RoletName:    role.Name,
+		PointsStatus: pointsPending,
+		Attendance:   attendanceAssigned,
+		SelfSignup:   selfSignup,
+	}
+	if err := store.Assignments().Create(ctx, assignment); err != nil {
+		return Assignment{}, err
+	}
+
+	// The teacher no longer needs to wait for the role
+	waiting, err := store.Waitlist().List(ctx, WaitlistFilter{RoleID: roleID, TeacherID: teacherID})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create assignment"})
-		return
+		return Assignment{}, err
 	}
-
-	// Update teacher's points
-	_, err = teacherCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": teacherID},
-		bson.M{"$inc": bson.M{"point": role.Point}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update teacher points"})
-		return
+	for _, entry := range waiting {
+		if err := store.Waitlist().Delete(ctx, entry.ID); err != nil {
+			return Assignment{}, err
+		}
 	}
 
-	// Add role reference to teacher's Assginedteachers array using the RoleRef struct
-	roleRef := RoleRef1{
+	// Add the assignment reference to the event's assginedteachers array
+	event.Assginedteachers = append(event.Assginedteachers, RoleRef1{
 		ID:            roleID,
 		RoleName:      role.Name,
 		TeacherleName: teacher.Name,
 		Assignment_ID: assignment.ID,
-	}
+	})
+	return assignment, store.Events().Update(ctx, event)
+}
 
-	_, err = eventCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": eventID},
-		bson.M{"$push": bson.M{"assginedteachers": roleRef}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update teacher's assigned roles"})
-		return
+// removeAssignmentRef drops an assignment from the event's assginedteachers array
+func removeAssignmentRef(event *Event, assignmentID primitive.ObjectID) {
+	var refs []RoleRef1
+	for _, ref := range event.Assginedteachers {
+		if ref.Assignment_ID != assignmentID {
+			refs = append(refs, ref)
+		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message":    "Teacher assigned to role successfully",
-		"assignment": assignment,
-	})
+	event.Assginedteachers = refs
 }
 
-// DeleteRoleAssignment removes a teacher's role assignment with optional point handling
+// DeleteRoleAssignment removes a teacher's role assignment with optional point
+// handling. The freed place goes to the next teacher on the role's waitlist.
 func DeleteRoleAssignment(c *gin.Context) {
 	type DeleteAssignmentRequest struct {
 		AssignmentID string `json:"assignment_id" binding:"required"`
@@ -609,69 +727,75 @@ func DeleteRoleAssignment(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Find the assignment first to get the role ID and teacher ID
-	assignmentCollection := db.Collection(teacherAssignmentCollection)
-	var assignment Assignment
-	err = assignmentCollection.FindOne(ctx, bson.M{"_id": assignmentID}).Decode(&assignment)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Assignment not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		}
-		return
-	}
+	actor, _ := currentUser(c)
+	var promoted []Assignment
 
-	// If we need to deduct points, we need to get the role's point value
-	if req.DeductPoints {
-		// Get the role to determine how many points to deduct
-		roleCollection := db.Collection(roleCollection)
-		var role Role
-		err = roleCollection.FindOne(ctx, bson.M{"_id": assignment.RoleID}).Decode(&role)
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		// Find the assignment first to get the role ID and teacher ID
+		assignment, err := store.Assignments().Get(ctx, assignmentID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Role not found"})
-			return
+			if errors.Is(err, ErrNotFound) {
+				return &requestError{http.StatusNotFound, "Assignment not found"}
+			}
+			return err
 		}
 
-		// Deduct points from the teacher
-		teacherCollection := db.Collection(teacherCollection)
-		_, err = teacherCollection.UpdateOne(
-			ctx,
-			bson.M{"_id": assignment.TeacherID},
-			bson.M{"$inc": bson.M{"point": -role.Point}},
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update teacher points"})
-			return
+		// Completed and cancelled events keep their assignments
+		event, err := store.Events().Get(ctx, assignment.EventID)
+		eventFound := err == nil
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if eventFound {
+			if err := requireEventOpen(event); err != nil {
+				return err
+			}
 		}
-	}
 
-	// Remove the role reference from teacher's assginedteachers array
-	// Match using the exact field name from the Teacher struct
-	eventCollection := db.Collection(eventCollection)
-	_, err = eventCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": assignment.TeacherID},
-		bson.M{"$pull": bson.M{"assginedteachers": bson.M{"id": assignment.RoleID}}},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update teacher's assigned roles"})
-		return
-	}
+		// Deduct whatever the ledger says the teacher still holds for this
+		// assignment, which may differ from the role's current point value
+		if req.DeductPoints {
+			deduction, ok, err := assignmentDeduction(ctx, assignment, actor, "Role assignment deleted")
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := recordLedgerEntries(ctx, deduction); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Remove the assignment reference from the event's assginedteachers array
+		if eventFound {
+			removeAssignmentRef(&event, assignment.ID)
+			if err := store.Events().Update(ctx, event); err != nil {
+				return err
+			}
+		}
 
-	// Delete the assignment
-	_, err = assignmentCollection.DeleteOne(ctx, bson.M{"_id": assignmentID})
+		// Delete the assignment
+		if err := store.Assignments().Delete(ctx, assignmentID); err != nil {
+			return err
+		}
+		promoted, err = promoteWaitlist(ctx, assignment.RoleID)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete assignment"})
+		respondTransactionError(c, err, "Failed to delete assignment")
 		return
 	}
+	if promoted == nil {
+		promoted = []Assignment{}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":         "Role assignment deleted successfully",
 		"deducted_points": req.DeductPoints,
+		"promoted":        promoted,
 	})
 }
 
@@ -687,19 +811,11 @@ func GetTeacherAssignments(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	collection := db.Collection(teacherAssignmentCollection)
-	cursor, err := collection.Find(ctx, bson.M{"teacher_id": objectID})
+	assignments, err := store.Assignments().List(ctx, AssignmentFilter{TeacherID: objectID})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var assignments []Assignment
-	if err := cursor.All(ctx, &assignments); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
 
 	c.JSON(http.StatusOK, assignments)
 }
@@ -716,29 +832,47 @@ func GetRoleAssignments(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	collection := db.Collection(teacherAssignmentCollection)
-	cursor, err := collection.Find(ctx, bson.M{"role_id": objectID})
+	assignments, err := store.Assignments().List(ctx, AssignmentFilter{RoleID: objectID})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var assignments []Assignment
-	if err := cursor.All(ctx, &assignments); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
 
 	c.JSON(http.StatusOK, assignments)
 }
 
-//
+// eventAssignments returns every assignment that belongs to an event or to
+// one of its roles
+func eventAssignments(ctx context.Context, eventID primitive.ObjectID, roles []Role) ([]Assignment, error) {
+	assignments, err := store.Assignments().List(ctx, AssignmentFilter{EventID: eventID})
+	if err != nil {
+		return nil, err
+	}
 
+	seen := make(map[primitive.ObjectID]bool, len(assignments))
+	for _, assignment := range assignments {
+		seen[assignment.ID] = true
+	}
+	for _, role := range roles {
+		roleAssignments, err := store.Assignments().List(ctx, AssignmentFilter{RoleID: role.ID})
+		if err != nil {
+			return nil, err
+		}
+		for _, assignment := range roleAssignments {
+			if !seen[assignment.ID] {
+				seen[assignment.ID] = true
+				assignments = append(assignments, assignment)
+			}
+		}
+	}
+	return assignments, nil
+}
+
+// DeleteEvent deletes a draft event with its roles. Events that have been
+// published keep their history and are cancelled instead.
 func DeleteEvent(c *gin.Context) {
 	type DeleteEventRequest struct {
-		EventID      string `json:"event_id" binding:"required"`
-		DeductPoints bool   `json:"deduct_points"` // Whether to deduct points from teachers
+		EventID string `json:"event_id" binding:"required"`
 	}
 
 	var req DeleteEventRequest
@@ -753,95 +887,45 @@ func DeleteEvent(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Get the event details to confirm it exists
-	eventCollection := db.Collection(eventCollection)
 	var event Event
-	err = eventCollection.FindOne(ctx, bson.M{"_id": eventID}).Decode(&event)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		}
-		return
-	}
-
-	// Find all roles associated with the event
-	roleCollection := db.Collection(roleCollection)
-	roleCursor, err := roleCollection.Find(ctx, bson.M{"event_id": eventID})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query roles"})
-		return
-	}
-	defer roleCursor.Close(ctx)
 
-	var roles []Role
-	if err := roleCursor.All(ctx, &roles); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse roles"})
-		return
-	}
-
-	// Process teacher assignments for each role
-	assignmentCollection := db.Collection(teacherAssignmentCollection)
-	teacherCollection := db.Collection(teacherCollection)
-
-	for _, role := range roles {
-		// Find all assignments for this role
-		assignmentCursor, err := assignmentCollection.Find(ctx, bson.M{"role_id": role.ID})
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		// Get the event details to confirm it exists
+		var err error
+		event, err = store.Events().Get(ctx, eventID)
 		if err != nil {
-			continue // Skip if error
+			if errors.Is(err, ErrNotFound) {
+				return &requestError{http.StatusNotFound, "Event not found"}
+			}
+			return err
 		}
-
-		var assignments []Assignment
-		if err := assignmentCursor.All(ctx, &assignments); err != nil {
-			assignmentCursor.Close(ctx)
-			continue // Skip if error
+		if event.Status != eventDraft {
+			return &requestError{http.StatusConflict, "Only draft events can be deleted; cancel the event instead"}
 		}
-		assignmentCursor.Close(ctx)
 
-		// If deducting points is requested, update each teacher's points
-		if req.DeductPoints {
-			for _, assignment := range assignments {
-				_, _ = teacherCollection.UpdateOne(
-					ctx,
-					bson.M{"_id": assignment.TeacherID},
-					bson.M{"$inc": bson.M{"point": -role.Point}},
-				)
-
-				// Remove role reference from event's assginedteachers array
-				_, _ = eventCollection.UpdateOne(
-					ctx,
-					bson.M{"_id": eventID},
-					bson.M{"$pull": bson.M{"assginedteachers": bson.M{"assignment_id": assignment.ID}}},
-				)
+		// Drafts cannot be staffed, so only their roles need deleting
+		roles, err := store.Roles().ListByEvent(ctx, eventID)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			if err := store.Roles().Delete(ctx, role.ID); err != nil {
+				return err
 			}
 		}
-
-		// Delete all assignments for this role
-		_, _ = assignmentCollection.DeleteMany(ctx, bson.M{"role_id": role.ID})
-	}
-
-	// Delete all roles associated with the event
-	_, err = roleCollection.DeleteMany(ctx, bson.M{"event_id": eventID})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete roles"})
-		return
-	}
-
-	// Finally delete the event itself
-	_, err = eventCollection.DeleteOne(ctx, bson.M{"_id": eventID})
+		return store.Events().Delete(ctx, eventID)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+		respondTransactionError(c, err, "Failed to delete event")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":         "Event and all associated data deleted successfully",
-		"deducted_points": req.DeductPoints,
-		"event_name":      event.Name,
+		"message":    "Event and all associated data deleted successfully",
+		"event_name": event.Name,
 	})
 }
 
@@ -857,19 +941,11 @@ func GetRolesByEventID(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	roleCollection := db.Collection(roleCollection)
-	cursor, err := roleCollection.Find(ctx, bson.M{"event_id": objectID})
+	roles, err := store.Roles().ListByEvent(ctx, objectID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var roles []Role
-	if err := cursor.All(ctx, &roles); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
 
 	c.JSON(http.StatusOK, roles)
 }
@@ -895,69 +971,50 @@ func GetTeacherRolesInEvent(c *gin.Context) {
 	defer cancel()
 
 	// Find all assignments for this teacher in this event
-	assignmentCollection := db.Collection(teacherAssignmentCollection)
-
-	// MongoDB aggregation pipeline to get detailed role information
-	pipeline := mongo.Pipeline{
-		{
-			{"$match", bson.M{
-				"teacher_id": teacherObjID,
-				"event_id":   eventObjID,
-			}},
-		},
-		{
-			{"$lookup", bson.M{
-				"from":         roleCollection,
-				"localField":   "role_id",
-				"foreignField": "_id",
-				"as":           "role_details",
-			}},
-		},
-		{
-			{"$unwind", bson.M{
-				"path":                       "$role_details",
-				"preserveNullAndEmptyArrays": false,
-			}},
-		},
-		{
-			{"$project", bson.M{
-				"_id":              1,
-				"event_id":         1,
-				"event_name":       "$eventname",
-				"teacher_id":       1,
-				"role_id":          1,
-				"role_name":        "$role_details.name",
-				"role_description": "$role_details.description",
-				"role_point":       "$role_details.point",
-			}},
-		},
-	}
-
-	cursor, err := assignmentCollection.Aggregate(ctx, pipeline)
+	assignments, err := store.Assignments().List(ctx, AssignmentFilter{
+		TeacherID: teacherObjID,
+		EventID:   eventObjID,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(ctx)
 
 	type TeacherRoleAssignment struct {
-		ID              primitive.ObjectID `json:"id" bson:"_id"`
-		EventID         primitive.ObjectID `json:"event_id" bson:"event_id"`
-		EventName       string             `json:"event_name" bson:"event_name"`
-		TeacherID       primitive.ObjectID `json:"teacher_id" bson:"teacher_id"`
-		RoleID          primitive.ObjectID `json:"role_id" bson:"role_id"`
-		RoleName        string             `json:"role_name" bson:"role_name"`
-		RoleDescription string             `json:"role_description" bson:"role_description"`
-		RolePoint       int                `json:"role_point" bson:"role_point"`
-	}
-
-	var assignments []TeacherRoleAssignment
-	if err := cursor.All(ctx, &assignments); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		ID              primitive.ObjectID `json:"id"`
+		EventID         primitive.ObjectID `json:"event_id"`
+		EventName       string             `json:"event_name"`
+		TeacherID       primitive.ObjectID `json:"teacher_id"`
+		RoleID          primitive.ObjectID `json:"role_id"`
+		RoleName        string             `json:"role_name"`
+		RoleDescription string             `json:"role_description"`
+		RolePoint       int                `json:"role_point"`
+	}
+
+	// Attach the role details, skipping assignments whose role is gone
+	result := make([]TeacherRoleAssignment, 0, len(assignments))
+	for _, assignment := range assignments {
+		role, err := store.Roles().Get(ctx, assignment.RoleID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		result = append(result, TeacherRoleAssignment{
+			ID:        assignment.ID,
+			EventID:   assignment.EventID,
+			EventName: assignment.EventName,
+			TeacherID: assignment.TeacherID,
+			RoleID:    assignment.RoleID,
+			RoleName:  role.Name,
+			RolePoint: role.Point,
+		})
 	}
 
-	c.JSON(http.StatusOK, assignments)
+	c.JSON(http.StatusOK, result)
 }
 
 func GetAssignedTeachersForEvent(c *gin.Context) {
@@ -971,21 +1028,12 @@ func GetAssignedTeachersForEvent(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	assignmentCollection := db.Collection("assignments")
-
 	// Fetch all assignments for this event
-	cursor, err := assignmentCollection.Find(ctx, bson.M{"event_id": eventID})
+	assignments, err := store.Assignments().List(ctx, AssignmentFilter{EventID: eventID})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch assignments"})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var assignments []Assignment
-	if err = cursor.All(ctx, &assignments); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode assignments"})
-		return
-	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"assignments": assignments,
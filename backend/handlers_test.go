@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testStore opens a fresh, empty store for a test
+type testStore struct {
+	name string
+	open func(t *testing.T) Store
+}
+
+// testStores returns the stores the storage tests run against: the memory
+// store, a scratch MongoDB database on the replica set in MONGO_TEST_URI and
+// a scratch schema in the PostgreSQL database in POSTGRES_TEST_URL. A
+// database whose variable is unset is skipped, or fails the test under CI.
+func testStores() []testStore {
+	return []testStore{
+		{"memory", func(t *testing.T) Store { return newMemoryStore() }},
+		{"mongo", openMongoTestStore},
+		{"postgres", openPostgresTestStore},
+	}
+}
+
+// testDatabaseURL returns the connection string in an environment variable,
+// skipping the test when it is unset
+func testDatabaseURL(t *testing.T, name string) string {
+	t.Helper()
+	url := os.Getenv(name)
+	if url != "" {
+		return url
+	}
+	if os.Getenv("CI") != "" {
+		t.Fatalf("%s is not set; CI must run the storage tests against every database", name)
+	}
+	t.Skipf("SKIPPED: %s is not set, so this test did not run against this database", name)
+	return ""
+}
+
+func openMongoTestStore(t *testing.T) Store {
+	uri := testDatabaseURL(t, "MONGO_TEST_URI")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s, err := newMongoStore(ctx, uri, "test_"+primitive.NewObjectID().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		s.db.Drop(ctx)
+		s.Close(ctx)
+	})
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func openPostgresTestStore(t *testing.T) Store {
+	url := testDatabaseURL(t, "POSTGRES_TEST_URL")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	admin, err := newPostgresStore(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + primitive.NewObjectID().Hex()
+	if _, err := admin.db.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		admin.db.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		admin.Close(ctx)
+	})
+
+	// public stays on the path for extensions such as pg_trgm
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	s, err := newPostgresStore(ctx, url+sep+"search_path="+schema+",public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close(context.Background()) })
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestAssignTeacherConcurrent has more teachers than places race for one
+// role: exactly head count of them must be assigned and the rest turned
+// away as the role is full. The memory store runs transactions one at a
+// time, so it is the MongoDB and PostgreSQL runs that test the role locks.
+func TestAssignTeacherConcurrent(t *testing.T) {
+	const teachers, headCount = 20, 5
+
+	for _, ts := range testStores() {
+		t.Run(ts.name, func(t *testing.T) {
+			store = ts.open(t)
+			ctx := context.Background()
+
+			event := Event{ID: primitive.NewObjectID(), Name: "Sports day", Status: eventPublished}
+			if err := store.Events().Create(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+			role := Role{ID: primitive.NewObjectID(), EventID: event.ID, Name: "Marshal", Point: 10, HeadCount: headCount}
+			if err := store.Roles().Create(ctx, role); err != nil {
+				t.Fatal(err)
+			}
+			teacherIDs := make([]primitive.ObjectID, teachers)
+			for i := range teacherIDs {
+				teacherIDs[i] = primitive.NewObjectID()
+				teacher := Teacher{ID: teacherIDs[i], Name: fmt.Sprint("Teacher ", i), Email: fmt.Sprintf("t%d@school.test", i)}
+				if err := store.Teachers().Create(ctx, teacher); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			errs := make([]error, teachers)
+			var wg sync.WaitGroup
+			for i, teacherID := range teacherIDs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = store.RunInTransaction(ctx, func(ctx context.Context) error {
+						_, err := assignTeacher(ctx, teacherID, role.ID, event.ID, false)
+						return err
+					})
+				}()
+			}
+			wg.Wait()
+
+			assigned := 0
+			for i, err := range errs {
+				var reqErr *requestError
+				switch {
+				case err == nil:
+					assigned++
+				case errors.As(err, &reqErr) && reqErr.status == http.StatusConflict:
+				default:
+					t.Errorf("teacher %d: got %v, want success or 409", i, err)
+				}
+			}
+			if assigned != headCount {
+				t.Errorf("%d assignments succeeded, want %d", assigned, headCount)
+			}
+
+			count, err := store.Assignments().Count(ctx, AssignmentFilter{RoleID: role.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != headCount {
+				t.Errorf("role has %d assignments, want %d", count, headCount)
+			}
+			event, err = store.Events().Get(ctx, event.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(event.Assginedteachers) != headCount {
+				t.Errorf("event lists %d assigned teachers, want %d", len(event.Assginedteachers), headCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limits on teacher import files
+const (
+	maxImportBytes = 5 << 20
+	maxImportRows  = 2000
+)
+
+// Outcomes of an imported row
+const (
+	importCreate    = "create"
+	importUpdate    = "update"
+	importUnchanged = "unchanged"
+	importInvalid   = "invalid"
+)
+
+// importColumns maps the accepted header names to the fields they fill
+var importColumns = map[string]string{
+	"name":            "name",
+	"teacher_name":    "name",
+	"email":           "email",
+	"department":      "department",
+	"departmentname":  "department",
+	"department_name": "department",
+	"photo_url":       "photo_url",
+	"profile_photo":   "photo_url",
+	"photo":           "photo_url",
+}
+
+// TeacherImportRow is one row of a teacher import file and what importing it
+// does
+type TeacherImportRow struct {
+	// Row is the line of the file, the header being line 1
+	Row        int    `json:"row"`
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Department string `json:"department,omitempty"`
+	PhotoURL   string `json:"photo_url,omitempty"`
+	Action     string `json:"action"`
+	// TeacherID is the teacher the row updates, or created once applied
+	TeacherID primitive.ObjectID `json:"teacher_id,omitempty"`
+	Errors    []string           `json:"errors,omitempty"`
+}
+
+// TeacherImportReport is the outcome of a teacher import
+type TeacherImportReport struct {
+	DryRun    bool               `json:"dry_run"`
+	Applied   bool               `json:"applied"`
+	Created   int                `json:"created"`
+	Updated   int                `json:"updated"`
+	Unchanged int                `json:"unchanged"`
+	Invalid   int                `json:"invalid"`
+	Rows      []TeacherImportRow `json:"rows"`
+}
+
+// readTeacherImport reads the rows of a CSV or XLSX file, chosen by the file
+// name's extension. The first row is a header naming the columns; name and
+// email are required, department and photo_url are optional and other
+// columns are ignored. Only the first sheet of a workbook is read. Emails
+// are normalized.
+func readTeacherImport(filename string, r io.Reader) ([]TeacherImportRow, error) {
+	var records [][]string
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		var err error
+		if records, err = reader.ReadAll(); err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+	case ".xlsx":
+		workbook, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid XLSX: %w", err)
+		}
+		defer workbook.Close()
+		sheets := workbook.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, errors.New("workbook has no sheets")
+		}
+		if records, err = workbook.GetRows(sheets[0]); err != nil {
+			return nil, fmt.Errorf("invalid XLSX: %w", err)
+		}
+	default:
+		return nil, errors.New("file must be a .csv or .xlsx file")
+	}
+	if len(records) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	if len(records)-1 > maxImportRows {
+		return nil, fmt.Errorf("file has more than %d rows", maxImportRows)
+	}
+
+	columns := make(map[string]int)
+	for i, heading := range records[0] {
+		heading = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(heading, "\ufeff")))
+		heading = strings.NewReplacer(" ", "_", "-", "_").Replace(heading)
+		if field, ok := importColumns[heading]; ok {
+			if _, dup := columns[field]; dup {
+				return nil, fmt.Errorf("more than one %s column", field)
+			}
+			columns[field] = i
+		}
+	}
+	for _, field := range []string{"name", "email"} {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("header has no %s column", field)
+		}
+	}
+
+	var rows []TeacherImportRow
+	for i, record := range records[1:] {
+		cell := func(field string) string {
+			if col, ok := columns[field]; ok && col < len(record) {
+				return strings.TrimSpace(record[col])
+			}
+			return ""
+		}
+		row := TeacherImportRow{
+			Row:        i + 2,
+			Name:       cell("name"),
+			Email:      normalizeEmail(cell("email")),
+			Department: cell("department"),
+			PhotoURL:   cell("photo_url"),
+		}
+		if row.Name == "" && row.Email == "" && row.Department == "" && row.PhotoURL == "" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("file has no teachers")
+	}
+	return rows, nil
+}
+
+// normalizeEmail is the form emails are stored, looked up and counted in:
+// trimmed and lower case
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validEmail reports whether s is a bare email address
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// validPhotoURL reports whether s is an absolute http or https URL
+func validPhotoURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// importTeachers validates every row and works out whether it creates a
+// teacher or updates the teacher with the same email. Blank department and
+// photo cells leave an existing teacher's values alone. Nothing is written
+// if any row is invalid or dryRun is set; otherwise all rows are applied in
+// one transaction, and a new or updated teacher without an account is linked
+// to the account with its email as in CreateTeacher.
+func importTeachers(ctx context.Context, rows []TeacherImportRow, dryRun bool) (TeacherImportReport, error) {
+	var report TeacherImportReport
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		report = TeacherImportReport{DryRun: dryRun, Rows: rows}
+		teachers := make([]Teacher, len(rows))
+		renamed := make([]bool, len(rows))
+		departments := make(map[string]Department)
+		seen := make(map[string]int)
+
+		for i := range report.Rows {
+			row := &report.Rows[i]
+			row.Action, row.TeacherID, row.Errors = "", primitive.NilObjectID, nil
+
+			if row.Name == "" {
+				row.Errors = append(row.Errors, "name is required")
+			}
+			switch {
+			case row.Email == "":
+				row.Errors = append(row.Errors, "email is required")
+			case !validEmail(row.Email):
+				row.Errors = append(row.Errors, "email is not a valid address")
+			case seen[row.Email] != 0:
+				row.Errors = append(row.Errors, fmt.Sprintf("email is already used on row %d", seen[row.Email]))
+			default:
+				seen[row.Email] = row.Row
+			}
+			var department Department
+			if row.Department != "" {
+				var ok bool
+				if department, ok = departments[row.Department]; !ok {
+					var err error
+					department, err = store.Departments().GetByName(ctx, row.Department)
+					if errors.Is(err, ErrNotFound) {
+						row.Errors = append(row.Errors, fmt.Sprintf("department %q does not exist", row.Department))
+					} else if err != nil {
+						return err
+					} else {
+						departments[row.Department] = department
+					}
+				}
+			}
+			if row.PhotoURL != "" && !validPhotoURL(row.PhotoURL) {
+				row.Errors = append(row.Errors, "photo_url must be an http or https URL")
+			}
+			if len(row.Errors) > 0 {
+				row.Action = importInvalid
+				report.Invalid++
+				continue
+			}
+
+			teacher, err := store.Teachers().GetByEmail(ctx, row.Email)
+			if errors.Is(err, ErrNotFound) {
+				teacher = Teacher{
+					ID:             primitive.NewObjectID(),
+					Name:           row.Name,
+					Email:          row.Email,
+					ProfilePhoto:   row.PhotoURL,
+					DepartmentID:   department.ID,
+					Departmentname: department.Name,
+				}
+				teachers[i] = teacher
+				row.Action = importCreate
+				report.Created++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			before := teacher
+			teacher.Name = row.Name
+			if row.PhotoURL != "" {
+				teacher.ProfilePhoto = row.PhotoURL
+			}
+			if !department.ID.IsZero() {
+				teacher.DepartmentID = department.ID
+				teacher.Departmentname = department.Name
+			}
+			teachers[i] = teacher
+			renamed[i] = teacher.Name != before.Name
+			row.TeacherID = teacher.ID
+			if teacher.Name == before.Name && teacher.ProfilePhoto == before.ProfilePhoto &&
+				teacher.DepartmentID == before.DepartmentID {
+				row.Action = importUnchanged
+				report.Unchanged++
+			} else {
+				row.Action = importUpdate
+				report.Updated++
+			}
+		}
+		if dryRun || report.Invalid > 0 {
+			return nil
+		}
+
+		for i := range report.Rows {
+			row := &report.Rows[i]
+			teacher := teachers[i]
+			switch row.Action {
+			case importCreate:
+				if err := store.Teachers().Create(ctx, teacher); err != nil {
+					return err
+				}
+				row.TeacherID = teacher.ID
+				if err := linkTeacherUser(ctx, &teacher); err != nil {
+					return err
+				}
+			case importUpdate:
+				if err := store.Teachers().Update(ctx, teacher); err != nil {
+					return err
+				}
+				if renamed[i] {
+					if err := renameTeacherAssignments(ctx, teacher); err != nil {
+						return err
+					}
+				}
+				if err := linkTeacherUser(ctx, &teacher); err != nil {
+					return err
+				}
+			}
+		}
+		report.Applied = true
+		return nil
+	})
+	return report, err
+}
+
+// ImportTeachers creates and updates teachers from an uploaded CSV or XLSX
+// file in the "file" form field, matched by email. Every row is checked
+// first; if any is invalid nothing is imported and the report lists the
+// errors of each row with 422. ?dry_run=true reports what would happen
+// without importing anything.
+func ImportTeachers(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the file in the file form field (at most 5 MB)"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	rows, err := readTeacherImport(header.Filename, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	report, err := importTeachers(ctx, rows, dryRun)
+	if err != nil {
+		respondTransactionError(c, err, "Failed to import teachers")
+		return
+	}
+	if report.Invalid > 0 {
+		c.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
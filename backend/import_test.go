@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestImportMatchesEmailCaseInsensitively imports an existing teacher's
+// email typed in another case: the row must update that teacher and link it
+// to the account with the email, not create a second teacher
+func TestImportMatchesEmailCaseInsensitively(t *testing.T) {
+	loadConfig()
+	store = newMemoryStore()
+	ctx := context.Background()
+
+	teacher := Teacher{ID: primitive.NewObjectID(), Name: "Ada", Email: "ada@school.test"}
+	if err := store.Teachers().Create(ctx, teacher); err != nil {
+		t.Fatal(err)
+	}
+	user := User{ID: primitive.NewObjectID(), Name: "Ada", Email: "ada@school.test", Role: userRoleTeacher}
+	if err := store.Users().Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := readTeacherImport("teachers.csv", strings.NewReader("name,email\nAda Lovelace, Ada@School.TEST \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := importTeachers(ctx, rows, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 0 || report.Updated != 1 || report.Rows[0].TeacherID != teacher.ID {
+		t.Fatalf("report = %+v, want one update of %s", report, teacher.ID.Hex())
+	}
+
+	teachers, err := store.Teachers().List(ctx, TeacherFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(teachers) != 1 {
+		t.Fatalf("got %d teachers, want 1", len(teachers))
+	}
+	if teachers[0].Name != "Ada Lovelace" || teachers[0].UserID != user.ID {
+		t.Errorf("teacher = %q linked to %s, want Ada Lovelace linked to %s", teachers[0].Name, teachers[0].UserID.Hex(), user.ID.Hex())
+	}
+	user, err = store.Users().Get(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UserID != teacher.ID {
+		t.Errorf("user is linked to %s, want %s", user.UserID.Hex(), teacher.ID.Hex())
+	}
+}
+
+func TestMigrateEmails(t *testing.T) {
+	loadConfig()
+	store = newMemoryStore()
+	ctx := context.Background()
+
+	users := []User{
+		{ID: primitive.NewObjectID(), Email: "Ada@School.test"},
+		{ID: primitive.NewObjectID(), Email: "bob@school.test"},
+		{ID: primitive.NewObjectID(), Email: "BOB@school.test"},
+	}
+	for _, user := range users {
+		if err := store.Users().Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	teacher := Teacher{ID: primitive.NewObjectID(), Name: "Ada", Email: " ADA@school.test"}
+	if err := store.Teachers().Create(ctx, teacher); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateEmails(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"ada@school.test", "bob@school.test", "BOB@school.test"} {
+		user, err := store.Users().Get(ctx, users[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != want {
+			t.Errorf("user %d has %q, want %q", i, user.Email, want)
+		}
+	}
+	teacher, err := store.Teachers().Get(ctx, teacher.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if teacher.Email != "ada@school.test" {
+		t.Errorf("teacher has %q, want ada@school.test", teacher.Email)
+	}
+}
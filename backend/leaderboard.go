@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tie-breaking rules for teachers with the same number of points
+const (
+	tieBreakShared   = "shared"   // equal points share a rank (1, 1, 3)
+	tieBreakName     = "name"     // unique ranks, alphabetical by name
+	tieBreakEarliest = "earliest" // unique ranks, whoever reached the total first
+)
+
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 500
+)
+
+// LeaderboardEntry is one teacher's standing
+type LeaderboardEntry struct {
+	Rank           int                `json:"rank"`
+	DepartmentRank int                `json:"department_rank,omitempty"`
+	TeacherID      primitive.ObjectID `json:"teacher_id"`
+	TeacherName    string             `json:"teacher_name"`
+	DepartmentID   primitive.ObjectID `json:"department_id,omitempty"`
+	DepartmentName string             `json:"department_name,omitempty"`
+	Points         int                `json:"points"`
+
+	participated bool
+	reachedAt    time.Time
+}
+
+// DepartmentStanding aggregates the points of one department's teachers
+type DepartmentStanding struct {
+	Rank              int                `json:"rank"`
+	DepartmentID      primitive.ObjectID `json:"department_id"`
+	DepartmentName    string             `json:"department_name"`
+	Teachers          int                `json:"teachers"`
+	Participants      int                `json:"participants"`
+	ParticipationRate float64            `json:"participation_rate"`
+	TotalPoints       int                `json:"total_points"`
+	AveragePoints     float64            `json:"average_points"`
+	MedianPoints      float64            `json:"median_points"`
+}
+
+func validTieBreak(rule string) bool {
+	return rule == tieBreakShared || rule == tieBreakName || rule == tieBreakEarliest
+}
+
+// leaderboardEntries returns every active teacher with their ledger balance
+// in a term, or across all terms for the zero ID. A teacher has participated
+// once they have been awarded points.
+func leaderboardEntries(ctx context.Context, termID primitive.ObjectID) ([]LeaderboardEntry, error) {
+	teachers, err := store.Teachers().List(ctx, TeacherFilter{Active: true})
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := store.Ledger().List(ctx, LedgerFilter{TermID: termID})
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[primitive.ObjectID]int, len(teachers))
+	entries := make([]LeaderboardEntry, len(teachers))
+	for i, teacher := range teachers {
+		index[teacher.ID] = i
+		entries[i] = LeaderboardEntry{
+			TeacherID:      teacher.ID,
+			TeacherName:    teacher.Name,
+			DepartmentID:   teacher.DepartmentID,
+			DepartmentName: teacher.Departmentname,
+		}
+	}
+
+	// Entries are oldest first, so reachedAt ends up as the time of the
+	// last change to the teacher's total
+	for _, line := range ledger {
+		i, ok := index[line.TeacherID]
+		if !ok {
+			continue
+		}
+		entries[i].Points += line.Points
+		if line.Points != 0 {
+			entries[i].reachedAt = line.CreatedAt
+		}
+		if line.Type == ledgerAward {
+			entries[i].participated = true
+		}
+	}
+	return entries, nil
+}
+
+// rankEntries sorts entries by points, highest first, and returns the rank
+// of each position under the tie-breaking rule
+func rankEntries(entries []LeaderboardEntry, rule string) []int {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if rule == tieBreakEarliest && !a.reachedAt.Equal(b.reachedAt) {
+			return a.reachedAt.Before(b.reachedAt)
+		}
+		return a.TeacherName < b.TeacherName
+	})
+
+	ranks := make([]int, len(entries))
+	for i := range entries {
+		ranks[i] = i + 1
+		if rule == tieBreakShared && i > 0 && entries[i].Points == entries[i-1].Points {
+			ranks[i] = ranks[i-1]
+		}
+	}
+	return ranks
+}
+
+// rankLeaderboard ranks every teacher overall and within their department
+func rankLeaderboard(entries []LeaderboardEntry, rule string) []LeaderboardEntry {
+	byDepartment := make(map[primitive.ObjectID][]LeaderboardEntry)
+	for _, entry := range entries {
+		if !entry.DepartmentID.IsZero() {
+			byDepartment[entry.DepartmentID] = append(byDepartment[entry.DepartmentID], entry)
+		}
+	}
+
+	departmentRanks := make(map[primitive.ObjectID]int, len(entries))
+	for _, members := range byDepartment {
+		ranks := rankEntries(members, rule)
+		for i, member := range members {
+			departmentRanks[member.TeacherID] = ranks[i]
+		}
+	}
+
+	ranks := rankEntries(entries, rule)
+	for i := range entries {
+		entries[i].Rank = ranks[i]
+		entries[i].DepartmentRank = departmentRanks[entries[i].TeacherID]
+	}
+	return entries
+}
+
+// leaderboardQuery reads the limit and tie_break query parameters
+func leaderboardQuery(c *gin.Context) (limit int, rule string, ok bool) {
+	limit = defaultLeaderboardLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLeaderboardLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxLeaderboardLimit)})
+			return 0, "", false
+		}
+		limit = n
+	}
+
+	rule = c.DefaultQuery("tie_break", tieBreakShared)
+	if !validTieBreak(rule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tie_break must be shared, name or earliest"})
+		return 0, "", false
+	}
+	return limit, rule, true
+}
+
+// GetTopTeachers returns the leaderboard. Optional query parameters:
+// term_id (default the current term, "all" for every term), department_id,
+// limit (default 10) and tie_break (shared, name, earliest).
+func GetTopTeachers(c *gin.Context) {
+	limit, rule, ok := leaderboardQuery(c)
+	if !ok {
+		return
+	}
+
+	var departmentID primitive.ObjectID
+	if value := c.Query("department_id"); value != "" {
+		oid, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid department ID format"})
+			return
+		}
+		departmentID = oid
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	term, ok := termScope(ctx, c)
+	if !ok {
+		return
+	}
+
+	entries, err := leaderboardEntries(ctx, term.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	entries = rankLeaderboard(entries, rule)
+
+	if !departmentID.IsZero() {
+		// Within one department the department rank is the one that matters
+		scoped := make([]LeaderboardEntry, 0)
+		for _, entry := range entries {
+			if entry.DepartmentID == departmentID {
+				scoped = append(scoped, entry)
+			}
+		}
+		sort.SliceStable(scoped, func(i, j int) bool { return scoped[i].DepartmentRank < scoped[j].DepartmentRank })
+		entries = scoped
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// median of a list of point totals
+func median(points []int) float64 {
+	if len(points) == 0 {
+		return 0
+	}
+	sorted := append([]int(nil), points...)
+	sort.Ints(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return float64(sorted[mid])
+	}
+	return float64(sorted[mid-1]+sorted[mid]) / 2
+}
+
+// departmentStandings aggregates leaderboard entries per department, ranked
+// by total points
+func departmentStandings(departments []Department, entries []LeaderboardEntry) []DepartmentStanding {
+	points := make(map[primitive.ObjectID][]int)
+	participants := make(map[primitive.ObjectID]int)
+	for _, entry := range entries {
+		if entry.DepartmentID.IsZero() {
+			continue
+		}
+		points[entry.DepartmentID] = append(points[entry.DepartmentID], entry.Points)
+		if entry.participated {
+			participants[entry.DepartmentID]++
+		}
+	}
+
+	standings := make([]DepartmentStanding, 0, len(departments))
+	for _, department := range departments {
+		standing := DepartmentStanding{
+			DepartmentID:   department.ID,
+			DepartmentName: department.Name,
+			Teachers:       len(points[department.ID]),
+			Participants:   participants[department.ID],
+			MedianPoints:   median(points[department.ID]),
+		}
+		for _, p := range points[department.ID] {
+			standing.TotalPoints += p
+		}
+		if standing.Teachers > 0 {
+			standing.AveragePoints = float64(standing.TotalPoints) / float64(standing.Teachers)
+			standing.ParticipationRate = float64(standing.Participants) / float64(standing.Teachers)
+		}
+		standings = append(standings, standing)
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		if standings[i].TotalPoints != standings[j].TotalPoints {
+			return standings[i].TotalPoints > standings[j].TotalPoints
+		}
+		return standings[i].DepartmentName < standings[j].DepartmentName
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && standings[i].TotalPoints == standings[i-1].TotalPoints {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
+
+// GetDepartmentLeaderboard ranks departments by total points with their
+// average, median and participation rate, scoped by term_id like
+// GetTopTeachers
+func GetDepartmentLeaderboard(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	term, ok := termScope(ctx, c)
+	if !ok {
+		return
+	}
+
+	departments, err := store.Departments().List(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := leaderboardEntries(ctx, term.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, departmentStandings(departments, entries))
+}
+
+// GetTeacherRank returns a teacher's overall and department rank, scoped by
+// term_id like GetTopTeachers
+func GetTeacherRank(c *gin.Context) {
+	teacherID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+		return
+	}
+
+	rule := c.DefaultQuery("tie_break", tieBreakShared)
+	if !validTieBreak(rule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tie_break must be shared, name or earliest"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	teacher, err := store.Teachers().Get(ctx, teacherID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Teacher not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if teacher.DeactivatedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Teacher has been deactivated and is not ranked"})
+		return
+	}
+
+	term, ok := termScope(ctx, c)
+	if !ok {
+		return
+	}
+
+	entries, err := leaderboardEntries(ctx, term.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	departmentSize := 0
+	var standing LeaderboardEntry
+	for _, entry := range rankLeaderboard(entries, rule) {
+		if entry.TeacherID == teacherID {
+			standing = entry
+		}
+	}
+	for _, entry := range entries {
+		if !standing.DepartmentID.IsZero() && entry.DepartmentID == standing.DepartmentID {
+			departmentSize++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"term":            scopeResponse(term),
+		"standing":        standing,
+		"teachers":        len(entries),
+		"department_size": departmentSize,
+	})
+}
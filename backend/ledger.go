@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ledger entry types
+const (
+	ledgerAward      = "award"      // points earned for an assignment
+	ledgerDeduction  = "deduction"  // points removed when an assignment is deleted or an event cancelled
+	ledgerAdjustment = "adjustment" // manual correction by an admin
+	ledgerReversal   = "reversal"   // cancels an earlier entry
+	ledgerCorrection = "correction" // applied by points reconciliation
+)
+
+// newLedgerEntry builds an entry attributed to the acting user
+func newLedgerEntry(entryType string, teacherID primitive.ObjectID, points int, actor User) LedgerEntry {
+	return LedgerEntry{
+		ID:        primitive.NewObjectID(),
+		TeacherID: teacherID,
+		Type:      entryType,
+		Points:    points,
+		ActorID:   actor.ID,
+		CreatedAt: time.Now(),
+	}
+}
+
+// recordLedgerEntries appends entries and refreshes the affected teachers'
+// derived point totals. Entries booked in a closed term are rejected.
+func recordLedgerEntries(ctx context.Context, entries ...LedgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := checkTermsOpen(ctx, entries...); err != nil {
+		return err
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	var teacherIDs []primitive.ObjectID
+	for _, entry := range entries {
+		if !seen[entry.TeacherID] {
+			seen[entry.TeacherID] = true
+			teacherIDs = append(teacherIDs, entry.TeacherID)
+		}
+	}
+
+	if err := store.Ledger().Append(ctx, entries...); err != nil {
+		return err
+	}
+	return syncTeacherPoints(ctx, teacherIDs...)
+}
+
+// syncTeacherPoints stores each teacher's ledger balance across all terms in
+// Teacher.Point
+func syncTeacherPoints(ctx context.Context, teacherIDs ...primitive.ObjectID) error {
+	for _, teacherID := range teacherIDs {
+		total, err := store.Ledger().Sum(ctx, LedgerFilter{TeacherID: teacherID})
+		if err != nil {
+			return err
+		}
+		if err := store.Teachers().SetPoints(ctx, teacherID, total); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignmentDeduction returns the entry that takes back whatever points a
+// teacher still holds for an assignment, or false if they hold none. The
+// deduction is booked in the term the points were earned in.
+func assignmentDeduction(ctx context.Context, assignment Assignment, actor User, reason string) (LedgerEntry, bool, error) {
+	entries, err := store.Ledger().List(ctx, LedgerFilter{AssignmentID: assignment.ID})
+	if err != nil {
+		return LedgerEntry{}, false, err
+	}
+	held := 0
+	var termID primitive.ObjectID
+	for _, entry := range entries {
+		held += entry.Points
+		termID = entry.TermID
+	}
+	if held == 0 {
+		return LedgerEntry{}, false, nil
+	}
+
+	entry := newLedgerEntry(ledgerDeduction, assignment.TeacherID, -held, actor)
+	entry.TermID = termID
+	entry.EventID = assignment.EventID
+	entry.EventName = assignment.EventName
+	entry.RoleID = assignment.RoleID
+	entry.RoleName = assignment.RoletName
+	entry.AssignmentID = assignment.ID
+	entry.Reason = reason
+	return entry, true, nil
+}
+
+// migrateLegacyPoints carries the old mutable Teacher.point counter into the
+// ledger for teachers that have no entries yet. Existing awarded assignments
+// are backfilled as awards and whatever the counter held beyond that becomes an
+// opening balance.
+func migrateLegacyPoints(ctx context.Context, s Store) error {
+	teachers, err := s.Teachers().List(ctx, TeacherFilter{})
+	if err != nil {
+		return err
+	}
+	balances, err := s.Ledger().Balances(ctx, LedgerFilter{})
+	if err != nil {
+		return err
+	}
+
+	for _, teacher := range teachers {
+		if _, ok := balances[teacher.ID]; ok {
+			continue
+		}
+
+		assignments, err := s.Assignments().List(ctx, AssignmentFilter{TeacherID: teacher.ID})
+		if err != nil {
+			return err
+		}
+
+		var entries []LedgerEntry
+		awarded := 0
+		for _, assignment := range assignments {
+			if assignment.PointsStatus != pointsAwarded {
+				continue
+			}
+			role, err := s.Roles().Get(ctx, assignment.RoleID)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			entry := newLedgerEntry(ledgerAward, teacher.ID, role.Point, User{})
+			entry.EventID = assignment.EventID
+			entry.EventName = assignment.EventName
+			entry.RoleID = role.ID
+			entry.RoleName = role.Name
+			entry.AssignmentID = assignment.ID
+			entry.Reason = "Backfilled from existing assignment"
+			entries = append(entries, entry)
+			awarded += role.Point
+		}
+
+		if residual := teacher.Point - awarded; residual != 0 {
+			entry := newLedgerEntry(ledgerAdjustment, teacher.ID, residual, User{})
+			entry.Reason = "Opening balance"
+			entries = append(entries, entry)
+		}
+
+		if err := s.Ledger().Append(ctx, entries...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTeacherLedger is a teacher's points statement: their ledger entries
+// with a running balance. The term_id query parameter scopes the statement
+// like GetTopTeachers.
+func GetTeacherLedger(c *gin.Context) {
+	teacherID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	teacher, err := store.Teachers().Get(ctx, teacherID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Teacher not found"})
+		return
+	}
+
+	term, ok := termScope(ctx, c)
+	if !ok {
+		return
+	}
+
+	entries, err := store.Ledger().List(ctx, LedgerFilter{TeacherID: teacherID, TermID: term.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type LedgerLine struct {
+		LedgerEntry
+		Balance int `json:"balance"`
+	}
+
+	lines := make([]LedgerLine, 0, len(entries))
+	balance := 0
+	for _, entry := range entries {
+		balance += entry.Points
+		lines = append(lines, LedgerLine{LedgerEntry: entry, Balance: balance})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"teacher_id":   teacher.ID,
+		"teacher_name": teacher.Name,
+		"term":         scopeResponse(term),
+		"balance":      balance,
+		"entries":      lines,
+	})
+}
+
+// AdjustTeacherPoints records a manual adjustment to a teacher's points, in
+// the given term or else the current one
+func AdjustTeacherPoints(c *gin.Context) {
+	type AdjustmentRequest struct {
+		Points int    `json:"points" binding:"required"`
+		Reason string `json:"reason" binding:"required"`
+		TermID string `json:"term_id"`
+	}
+
+	teacherID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+		return
+	}
+
+	var req AdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A non-zero points value and a reason are required"})
+		return
+	}
+
+	var termID primitive.ObjectID
+	if req.TermID != "" {
+		termID, err = primitive.ObjectIDFromHex(req.TermID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid term ID format"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	entry := newLedgerEntry(ledgerAdjustment, teacherID, req.Points, actor)
+	entry.Reason = req.Reason
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := store.Teachers().Get(ctx, teacherID); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return &requestError{http.StatusNotFound, "Teacher not found"}
+			}
+			return err
+		}
+
+		entry.TermID = termID
+		if termID.IsZero() {
+			var err error
+			if entry.TermID, err = defaultEntryTerm(ctx); err != nil {
+				return err
+			}
+		}
+		return recordLedgerEntries(ctx, entry)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to record adjustment")
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// ReverseLedgerEntry cancels an earlier entry with an opposite entry
+func ReverseLedgerEntry(c *gin.Context) {
+	type ReversalRequest struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+
+	entryID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ledger entry ID format"})
+		return
+	}
+
+	var req ReversalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	var entry LedgerEntry
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		original, err := store.Ledger().Get(ctx, entryID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return &requestError{http.StatusNotFound, "Ledger entry not found"}
+			}
+			return err
+		}
+		if original.Type == ledgerReversal {
+			return &requestError{http.StatusBadRequest, "A reversal cannot itself be reversed"}
+		}
+
+		entry = newLedgerEntry(ledgerReversal, original.TeacherID, -original.Points, actor)
+		entry.EventID = original.EventID
+		entry.EventName = original.EventName
+		entry.RoleID = original.RoleID
+		entry.RoleName = original.RoleName
+		entry.AssignmentID = original.AssignmentID
+		entry.ReversesID = original.ID
+		entry.TermID = original.TermID
+		entry.Reason = req.Reason
+
+		err = recordLedgerEntries(ctx, entry)
+		if errors.Is(err, ErrDuplicate) {
+			return &requestError{http.StatusConflict, "Ledger entry has already been reversed"}
+		}
+		return err
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to record reversal")
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
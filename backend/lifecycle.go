@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event statuses
+const (
+	eventDraft      = "draft"
+	eventPublished  = "published"
+	eventInProgress = "in_progress"
+	eventCompleted  = "completed"
+	eventCancelled  = "cancelled"
+)
+
+// eventTransitions lists the statuses each status may move to
+var eventTransitions = map[string][]string{
+	eventDraft:      {eventPublished, eventCancelled},
+	eventPublished:  {eventInProgress, eventCancelled},
+	eventInProgress: {eventCompleted, eventCancelled},
+}
+
+// Assignment point statuses
+const (
+	pointsPending  = "pending"  // waiting for the event to complete
+	pointsAwarded  = "awarded"  // credited to the ledger
+	pointsVoided   = "voided"   // dropped when the event was cancelled
+	pointsReversed = "reversed" // credited, then taken back on cancellation
+)
+
+// Cancellation policies. Pending points are always voided; reverse also
+// takes back points already credited for the event.
+const (
+	cancelVoid    = "void"
+	cancelReverse = "reverse"
+)
+
+// canTransition reports whether an event may move from one status to another
+func canTransition(from, to string) bool {
+	for _, next := range eventTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// eventClosed reports whether an event has reached a final status, after
+// which it, its roles and its assignments can no longer change
+func eventClosed(event Event) bool {
+	return event.Status == eventCompleted || event.Status == eventCancelled
+}
+
+// requireEventOpen rejects changes to a completed or cancelled event
+func requireEventOpen(event Event) error {
+	if eventClosed(event) {
+		return &requestError{http.StatusConflict, "Event is " + event.Status + " and can no longer be changed"}
+	}
+	return nil
+}
+
+// EventStatusRequest is the body accepted when changing an event's status
+type EventStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+	// Policy and Reason apply to cancellation
+	Policy string `json:"policy"`
+	Reason string `json:"reason"`
+}
+
+// assignmentAward is the ledger entry crediting an assignment with its
+// role's points, booked in the event's term
+func assignmentAward(event Event, assignment Assignment, role Role, actor User, reason string) LedgerEntry {
+	award := newLedgerEntry(ledgerAward, assignment.TeacherID, role.Point, actor)
+	award.TermID = event.TermID
+	award.EventID = event.ID
+	award.EventName = event.Name
+	award.RoleID = assignment.RoleID
+	award.RoleName = assignment.RoletName
+	award.AssignmentID = assignment.ID
+	award.Reason = reason
+	return award
+}
+
+// completeEvent awards the pending points of every assignment in the event
+// whose teacher attended. Assignments without confirmed attendance stay
+// pending until it is recorded.
+func completeEvent(ctx context.Context, event Event, actor User) error {
+	roles, err := store.Roles().ListByEvent(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+	rolesByID := make(map[primitive.ObjectID]Role, len(roles))
+	for _, role := range roles {
+		rolesByID[role.ID] = role
+	}
+	assignments, err := eventAssignments(ctx, event.ID, roles)
+	if err != nil {
+		return err
+	}
+
+	var awards []LedgerEntry
+	for _, assignment := range assignments {
+		if assignment.PointsStatus != pointsPending || assignment.Attendance != attendanceAttended {
+			continue
+		}
+		role, ok := rolesByID[assignment.RoleID]
+		if !ok {
+			// The role is gone, so there is nothing to award
+			assignment.PointsStatus = pointsVoided
+			if err := store.Assignments().Update(ctx, assignment); err != nil {
+				return err
+			}
+			continue
+		}
+		awards = append(awards, assignmentAward(event, assignment, role, actor, "Event completed"))
+
+		assignment.PointsStatus = pointsAwarded
+		assignment.AwardedPoints = role.Point
+		if err := store.Assignments().Update(ctx, assignment); err != nil {
+			return err
+		}
+	}
+	return recordLedgerEntries(ctx, awards...)
+}
+
+// cancelEvent voids the pending points of every assignment in the event and,
+// under the reverse policy, takes back the points already credited
+func cancelEvent(ctx context.Context, event Event, policy string, actor User) error {
+	roles, err := store.Roles().ListByEvent(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+	assignments, err := eventAssignments(ctx, event.ID, roles)
+	if err != nil {
+		return err
+	}
+
+	var deductions []LedgerEntry
+	for _, assignment := range assignments {
+		switch {
+		case assignment.PointsStatus == pointsPending:
+			assignment.PointsStatus = pointsVoided
+		case assignment.PointsStatus == pointsAwarded && policy == cancelReverse:
+			deduction, ok, err := assignmentDeduction(ctx, assignment, actor, "Event cancelled")
+			if err != nil {
+				return err
+			}
+			if ok {
+				deductions = append(deductions, deduction)
+			}
+			assignment.PointsStatus = pointsReversed
+		default:
+			continue
+		}
+		if err := store.Assignments().Update(ctx, assignment); err != nil {
+			return err
+		}
+	}
+	return recordLedgerEntries(ctx, deductions...)
+}
+
+// ChangeEventStatus moves an event through its lifecycle. Completing an event
+// credits the points of its assignments; cancelling it voids pending points
+// and, with the reverse policy, takes back points already credited. The
+// event and its history are kept either way.
+func ChangeEventStatus(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req EventStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.Status {
+	case eventDraft, eventPublished, eventInProgress, eventCompleted, eventCancelled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event status " + req.Status})
+		return
+	}
+	if req.Status == eventCancelled {
+		if req.Policy == "" {
+			req.Policy = cancelVoid
+		}
+		if req.Policy != cancelVoid && req.Policy != cancelReverse {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "policy must be void or reverse"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	var event Event
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		event, err = store.Events().Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Event not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if !canTransition(event.Status, req.Status) {
+			return &requestError{http.StatusConflict, "Event cannot move from " + event.Status + " to " + req.Status}
+		}
+
+		now := time.Now()
+		switch req.Status {
+		case eventCompleted:
+			if err := completeEvent(ctx, event, actor); err != nil {
+				return err
+			}
+			event.CompletedAt = &now
+		case eventCancelled:
+			if err := cancelEvent(ctx, event, req.Policy, actor); err != nil {
+				return err
+			}
+			event.CancelledAt = &now
+			event.CancelledBy = actor.ID
+			event.CancelPolicy = req.Policy
+			event.CancelReason = req.Reason
+		}
+		if req.Status == eventCompleted || req.Status == eventCancelled {
+			// Nobody can be promoted or approved any more
+			if err := clearWaitlist(ctx, event.ID); err != nil {
+				return err
+			}
+			if err := closeVolunteerRequests(ctx, event, req.Status, actor, now); err != nil {
+				return err
+			}
+		}
+		event.Status = req.Status
+		return store.Events().Update(ctx, event)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to change event status")
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+// migrateEventStatuses gives events saved before statuses existed a status:
+// completed if they have ended, published otherwise. Their assignments were
+// credited when they were made, so they are marked awarded.
+func migrateEventStatuses(ctx context.Context, s Store) error {
+	events, err := s.Events().List(ctx, EventFilter{}, EventPage{})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, event := range events {
+		if event.Status != "" {
+			continue
+		}
+		event.Status = eventPublished
+		if !event.EndsAt.IsZero() && !event.EndsAt.After(now) {
+			event.Status = eventCompleted
+		}
+		if err := s.Events().Update(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	assignments, err := s.Assignments().List(ctx, AssignmentFilter{})
+	if err != nil {
+		return err
+	}
+	for _, assignment := range assignments {
+		if assignment.PointsStatus != "" {
+			continue
+		}
+		assignment.PointsStatus = pointsAwarded
+		if err := s.Assignments().Update(ctx, assignment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
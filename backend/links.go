@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A user account and a teacher are linked when each names the other in its
+// user_id field. A user has at most one teacher and a teacher at most one
+// user; links are made when an account or teacher is created with the other
+// one's email, or by an admin.
+
+// Actions in a UserLinkFix
+const (
+	linkFixLink  = "link"  // make the user and teacher name each other
+	linkFixClear = "clear" // drop a link that points nowhere valid
+)
+
+// UserLinkFix is one change that brings the links between users and
+// teachers back into a consistent state
+type UserLinkFix struct {
+	Action       string             `json:"action"`
+	UserID       primitive.ObjectID `json:"user_id,omitempty"`
+	UserEmail    string             `json:"user_email,omitempty"`
+	TeacherID    primitive.ObjectID `json:"teacher_id,omitempty"`
+	TeacherEmail string             `json:"teacher_email,omitempty"`
+	Reason       string             `json:"reason"`
+}
+
+// LinkOrphan is a user without a teacher or a teacher without a user
+type LinkOrphan struct {
+	ID    primitive.ObjectID `json:"id"`
+	Name  string             `json:"name"`
+	Email string             `json:"email"`
+}
+
+// UserLinkReport is the outcome of checking the links between users and
+// teachers. Orphans are counted after the fixes.
+type UserLinkReport struct {
+	Fixes []UserLinkFix `json:"fixes"`
+	// OrphanUsers are accounts other than admins without a teacher
+	OrphanUsers []LinkOrphan `json:"orphan_users"`
+	// OrphanTeachers are active teachers without an account
+	OrphanTeachers []LinkOrphan `json:"orphan_teachers"`
+}
+
+// linkUser links a user to a teacher. Neither may already be linked to
+// someone else.
+func linkUser(ctx context.Context, user *User, teacher *Teacher) error {
+	if user.UserID == teacher.ID && teacher.UserID == user.ID {
+		return nil
+	}
+	if _, err := findTeacherForUser(ctx, *user); err == nil {
+		return &requestError{http.StatusConflict, "User is already linked to another teacher; unlink them first"}
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if !teacher.UserID.IsZero() {
+		other, err := store.Users().Get(ctx, teacher.UserID)
+		if err == nil && other.UserID == teacher.ID {
+			return &requestError{http.StatusConflict, "Teacher is already linked to " + other.Email + "; unlink them first"}
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+
+	user.UserID = teacher.ID
+	teacher.UserID = user.ID
+	if err := store.Users().Update(ctx, *user); err != nil {
+		return err
+	}
+	return store.Teachers().Update(ctx, *teacher)
+}
+
+// linkTeacherUser links a teacher without an account to the account with
+// the teacher's email, if there is one and it has no teacher yet
+func linkTeacherUser(ctx context.Context, teacher *Teacher) error {
+	if !teacher.UserID.IsZero() || teacher.Email == "" {
+		return nil
+	}
+	user, err := store.Users().GetByEmail(ctx, teacher.Email)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.UserID.IsZero() {
+		return nil
+	}
+	return linkUser(ctx, &user, teacher)
+}
+
+// userIDParam parses the id path parameter. On failure the error response
+// has been written.
+func userIDParam(c *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return id, false
+	}
+	return id, true
+}
+
+// LinkUserTeacher links the user in :id to the teacher in the body. Both
+// must be unlinked first.
+func LinkUserTeacher(c *gin.Context) {
+	type LinkRequest struct {
+		TeacherID string `json:"teacher_id" binding:"required"`
+	}
+
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	var req LinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	teacherID, err := primitive.ObjectIDFromHex(req.TeacherID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user User
+	var teacher Teacher
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = store.Users().Get(ctx, userID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "User not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if teacher, err = loadTeacher(ctx, teacherID); err != nil {
+			return err
+		}
+		return linkUser(ctx, &user, &teacher)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to link user")
+		return
+	}
+	user.Password = ""
+
+	c.JSON(http.StatusOK, gin.H{"user": user, "teacher": teacher})
+}
+
+// UnlinkUserTeacher removes the link between the user in :id and their
+// teacher
+func UnlinkUserTeacher(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user User
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = store.Users().Get(ctx, userID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "User not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if user.UserID.IsZero() {
+			return &requestError{http.StatusConflict, "User is not linked to a teacher"}
+		}
+
+		teacher, err := store.Teachers().Get(ctx, user.UserID)
+		if err == nil && teacher.UserID == user.ID {
+			teacher.UserID = primitive.NilObjectID
+			if err := store.Teachers().Update(ctx, teacher); err != nil {
+				return err
+			}
+		} else if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		user.UserID = primitive.NilObjectID
+		return store.Users().Update(ctx, user)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to unlink user")
+		return
+	}
+	user.Password = ""
+
+	c.JSON(http.StatusOK, user)
+}
+
+// GetUserLinks reports what repair-user-links would fix and the users and
+// teachers left without a link
+func GetUserLinks(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	report, err := repairUserLinks(ctx, store, false, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// repairUserLinks works out the links between users and teachers and, with
+// commit, writes them. Links both sides agree on are kept. A one-sided link
+// is completed when the other side is free, preferring the account with the
+// teacher's email when several accounts name one teacher. With byEmail, users
+// and teachers still unlinked are then paired by email. Links that point to
+// missing or taken records are cleared.
+func repairUserLinks(ctx context.Context, s Store, commit, byEmail bool) (UserLinkReport, error) {
+	report := UserLinkReport{Fixes: []UserLinkFix{}, OrphanUsers: []LinkOrphan{}, OrphanTeachers: []LinkOrphan{}}
+	users, err := s.Users().List(ctx)
+	if err != nil {
+		return report, err
+	}
+	teachers, err := s.Teachers().List(ctx, TeacherFilter{})
+	if err != nil {
+		return report, err
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID.Hex() < users[j].ID.Hex() })
+	sort.Slice(teachers, func(i, j int) bool { return teachers[i].ID.Hex() < teachers[j].ID.Hex() })
+
+	usersByID := make(map[primitive.ObjectID]User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+	teachersByID := make(map[primitive.ObjectID]Teacher, len(teachers))
+	for _, teacher := range teachers {
+		teachersByID[teacher.ID] = teacher
+	}
+
+	// userTeacher and teacherUser hold the links being worked out
+	userTeacher := make(map[primitive.ObjectID]primitive.ObjectID)
+	teacherUser := make(map[primitive.ObjectID]primitive.ObjectID)
+	link := func(user User, teacher Teacher, reason string) {
+		userTeacher[user.ID] = teacher.ID
+		teacherUser[teacher.ID] = user.ID
+		if user.UserID != teacher.ID || teacher.UserID != user.ID {
+			report.Fixes = append(report.Fixes, UserLinkFix{
+				Action:       linkFixLink,
+				UserID:       user.ID,
+				UserEmail:    user.Email,
+				TeacherID:    teacher.ID,
+				TeacherEmail: teacher.Email,
+				Reason:       reason,
+			})
+		}
+	}
+
+	for _, user := range users {
+		if teacher, ok := teachersByID[user.UserID]; ok && teacher.UserID == user.ID {
+			link(user, teacher, "")
+		}
+	}
+	for _, teacher := range teachers {
+		if _, ok := teacherUser[teacher.ID]; ok {
+			continue
+		}
+		var claims []User
+		for _, user := range users {
+			if _, ok := userTeacher[user.ID]; !ok && user.UserID == teacher.ID {
+				claims = append(claims, user)
+			}
+		}
+		if len(claims) == 0 {
+			continue
+		}
+		best := claims[0]
+		for _, user := range claims {
+			if normalizeEmail(user.Email) == normalizeEmail(teacher.Email) {
+				best = user
+				break
+			}
+		}
+		link(best, teacher, "the user names the teacher")
+	}
+	for _, teacher := range teachers {
+		if _, ok := teacherUser[teacher.ID]; ok {
+			continue
+		}
+		user, ok := usersByID[teacher.UserID]
+		if !ok {
+			continue
+		}
+		if _, taken := userTeacher[user.ID]; taken {
+			continue
+		}
+		if _, claims := teachersByID[user.UserID]; claims {
+			continue
+		}
+		link(user, teacher, "the teacher names the user")
+	}
+	for _, teacher := range teachers {
+		if _, ok := teacherUser[teacher.ID]; ok || teacher.Email == "" || !byEmail {
+			continue
+		}
+		for _, user := range users {
+			if _, taken := userTeacher[user.ID]; !taken && normalizeEmail(user.Email) == normalizeEmail(teacher.Email) {
+				link(user, teacher, "same email")
+				break
+			}
+		}
+	}
+
+	var clearUsers []User
+	for _, user := range users {
+		if _, ok := userTeacher[user.ID]; ok || user.UserID.IsZero() {
+			continue
+		}
+		reason := "the teacher does not exist"
+		if _, ok := teachersByID[user.UserID]; ok {
+			reason = "the teacher is linked to another user"
+		}
+		report.Fixes = append(report.Fixes, UserLinkFix{
+			Action:    linkFixClear,
+			UserID:    user.ID,
+			UserEmail: user.Email,
+			TeacherID: user.UserID,
+			Reason:    reason,
+		})
+		clearUsers = append(clearUsers, user)
+	}
+	var clearTeachers []Teacher
+	for _, teacher := range teachers {
+		if _, ok := teacherUser[teacher.ID]; ok || teacher.UserID.IsZero() {
+			continue
+		}
+		reason := "the user does not exist"
+		if _, ok := usersByID[teacher.UserID]; ok {
+			reason = "the user is linked to another teacher"
+		}
+		report.Fixes = append(report.Fixes, UserLinkFix{
+			Action:       linkFixClear,
+			UserID:       teacher.UserID,
+			TeacherID:    teacher.ID,
+			TeacherEmail: teacher.Email,
+			Reason:       reason,
+		})
+		clearTeachers = append(clearTeachers, teacher)
+	}
+
+	for _, user := range users {
+		if _, ok := userTeacher[user.ID]; !ok && user.Role != userRoleAdmin {
+			report.OrphanUsers = append(report.OrphanUsers, LinkOrphan{ID: user.ID, Name: user.Name, Email: user.Email})
+		}
+	}
+	for _, teacher := range teachers {
+		if _, ok := teacherUser[teacher.ID]; !ok && teacher.DeactivatedAt == nil {
+			report.OrphanTeachers = append(report.OrphanTeachers, LinkOrphan{ID: teacher.ID, Name: teacher.Name, Email: teacher.Email})
+		}
+	}
+	if !commit {
+		return report, nil
+	}
+
+	// Clear first so that no two records name the same one at any point
+	for _, user := range clearUsers {
+		user.UserID = primitive.NilObjectID
+		if err := s.Users().Update(ctx, user); err != nil {
+			return report, err
+		}
+	}
+	for _, teacher := range clearTeachers {
+		teacher.UserID = primitive.NilObjectID
+		if err := s.Teachers().Update(ctx, teacher); err != nil {
+			return report, err
+		}
+	}
+	for _, fix := range report.Fixes {
+		if fix.Action != linkFixLink {
+			continue
+		}
+		user, teacher := usersByID[fix.UserID], teachersByID[fix.TeacherID]
+		if user.UserID != teacher.ID {
+			user.UserID = teacher.ID
+			if err := s.Users().Update(ctx, user); err != nil {
+				return report, err
+			}
+		}
+		if teacher.UserID != user.ID {
+			teacher.UserID = user.ID
+			if err := s.Teachers().Update(ctx, teacher); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// migrateEmails normalizes the emails stored by older versions, which kept
+// them as typed. An account whose normalized email another account already
+// has is left alone and logged for an admin to merge by hand.
+func migrateEmails(ctx context.Context, s Store) error {
+	users, err := s.Users().List(ctx)
+	if err != nil {
+		return err
+	}
+	taken := make(map[string]bool, len(users))
+	for _, user := range users {
+		if user.Email == normalizeEmail(user.Email) {
+			taken[user.Email] = true
+		}
+	}
+	for _, user := range users {
+		email := normalizeEmail(user.Email)
+		if user.Email == email {
+			continue
+		}
+		if taken[email] {
+			log.Printf("email: user %s is %q, which another account already has once normalized; left as it is", user.ID.Hex(), user.Email)
+			continue
+		}
+		taken[email] = true
+		user.Email = email
+		if err := s.Users().Update(ctx, user); err != nil {
+			return err
+		}
+	}
+
+	teachers, err := s.Teachers().List(ctx, TeacherFilter{})
+	if err != nil {
+		return err
+	}
+	for _, teacher := range teachers {
+		if email := normalizeEmail(teacher.Email); teacher.Email != email {
+			teacher.Email = email
+			if err := s.Teachers().Update(ctx, teacher); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateUserLinks repairs the links left by older versions, which pointed
+// teachers at themselves. It runs on every start, so it does not pair by
+// email: that would undo an admin's unlink. repair-user-links does.
+func migrateUserLinks(ctx context.Context, s Store) error {
+	report, err := repairUserLinks(ctx, s, true, false)
+	if err != nil {
+		return err
+	}
+	for _, fix := range report.Fixes {
+		log.Printf("user link: %s user %s / teacher %s: %s", fix.Action, fix.UserID.Hex(), fix.TeacherID.Hex(), fix.Reason)
+	}
+	return nil
+}
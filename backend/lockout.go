@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultLoginFailureLimit = 100
+	maxLoginFailureLimit     = 1000
+)
+
+// checkedLoginReasons are the failures where a password was actually tried.
+// Attempts turned away by a lockout do not extend it.
+var checkedLoginReasons = []string{loginUnknownEmail, loginBadPassword, loginBadCode}
+
+// lockoutFor is how long an account stays locked after a number of
+// failures: LoginLockout at LoginMaxFailures, doubling after that
+func lockoutFor(failures int) time.Duration {
+	if config.LoginMaxFailures <= 0 || failures < config.LoginMaxFailures {
+		return 0
+	}
+	lockout := config.LoginLockout
+	for i := config.LoginMaxFailures; i < failures && lockout < config.LoginMaxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, config.LoginMaxLockout)
+}
+
+// loginBlockedUntil returns when logins for an email from an address may be
+// tried again, and the reason they are blocked. A zero time means they are
+// not blocked.
+func loginBlockedUntil(ctx context.Context, email, ip string) (time.Time, string, error) {
+	now := time.Now()
+
+	if config.LoginIPLimit > 0 {
+		failures, err := store.LoginFailures().List(ctx, LoginFailureFilter{
+			IP:      ip,
+			Reasons: checkedLoginReasons,
+			Since:   now.Add(-config.LoginIPWindow),
+		})
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		if n := len(failures); n >= config.LoginIPLimit {
+			return failures[n-config.LoginIPLimit].CreatedAt.Add(config.LoginIPWindow), loginThrottled, nil
+		}
+	}
+
+	failures, err := store.LoginFailures().List(ctx, LoginFailureFilter{
+		Email:     email,
+		Reasons:   checkedLoginReasons,
+		Since:     now.Add(-config.LoginFailureWindow),
+		Uncleared: true,
+	})
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if lockout := lockoutFor(len(failures)); lockout > 0 {
+		if until := failures[len(failures)-1].CreatedAt.Add(lockout); until.After(now) {
+			return until, loginLocked, nil
+		}
+	}
+	return time.Time{}, "", nil
+}
+
+// recordLoginFailure stores a failed login. Errors are only logged, since
+// the caller is already refusing the login.
+func recordLoginFailure(ctx context.Context, email, ip, reason string, userID primitive.ObjectID) {
+	err := store.LoginFailures().Create(ctx, LoginFailure{
+		ID:        primitive.NewObjectID(),
+		Email:     email,
+		UserID:    userID,
+		IP:        ip,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("failed to record login failure for %s: %v", email, err)
+	}
+}
+
+// checkLoginAllowed turns away a login for an email from the caller's address
+// while it is locked out or throttled, responding with 429 and Retry-After
+func checkLoginAllowed(ctx context.Context, c *gin.Context, email string, userID primitive.ObjectID) bool {
+	until, reason, err := loginBlockedUntil(ctx, email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if until.IsZero() {
+		return true
+	}
+
+	recordLoginFailure(ctx, email, c.ClientIP(), reason, userID)
+	retryAfter := int(time.Until(until).Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts; try again later",
+		"retry_after": retryAfter,
+	})
+	return false
+}
+
+// UnlockUser clears the failed logins counting towards an account's lockout
+func UnlockUser(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := store.Users().Get(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := store.LoginFailures().Clear(ctx, normalizeEmail(user.Email)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked", "user_id": user.ID})
+}
+
+// ListLoginFailures returns failed logins, newest first. Optional query
+// parameters: email, ip, since (default a day ago) and limit (default 100).
+func ListLoginFailures(c *gin.Context) {
+	filter := LoginFailureFilter{
+		Email: normalizeEmail(c.Query("email")),
+		IP:    c.Query("ip"),
+		Since: time.Now().Add(-24 * time.Hour),
+	}
+	if value := c.Query("since"); value != "" {
+		since, err := parseEventBound("since", value, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.Since = since
+	}
+	limit := defaultLoginFailureLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLoginFailureLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxLoginFailureLimit)})
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	failures, err := store.LoginFailures().List(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	newest := make([]LoginFailure, 0, min(limit, len(failures)))
+	for i := len(failures) - 1; i >= 0 && len(newest) < limit; i-- {
+		newest = append(newest, failures[i])
+	}
+	c.JSON(http.StatusOK, newest)
+}
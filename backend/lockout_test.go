@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const lockoutTestEmail = "teacher@school.test"
+
+// newLockoutTestRouter returns a test router with a teacher and an admin
+// account, LoginMaxFailures of 3 and lockouts from a minute up to four. The
+// address throttle is off so that only the email lockout applies.
+func newLockoutTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	r := newTestRouter(t, map[string]string{
+		lockoutTestEmail:    userRoleTeacher,
+		"admin@school.test": userRoleAdmin,
+	})
+	config.LoginMaxFailures = 3
+	config.LoginLockout = time.Minute
+	config.LoginMaxLockout = 4 * time.Minute
+	config.LoginFailureWindow = time.Hour
+	config.LoginIPLimit = 0
+	return r
+}
+
+// addLoginFailures records n bad passwords for lockoutTestEmail made at the
+// given time
+func addLoginFailures(t *testing.T, n int, at time.Time) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		err := store.LoginFailures().Create(context.Background(), LoginFailure{
+			ID:        primitive.NewObjectID(),
+			Email:     lockoutTestEmail,
+			IP:        "192.0.2.1",
+			Reason:    loginBadPassword,
+			CreatedAt: at,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// loginWith posts a login for lockoutTestEmail
+func loginWith(r *gin.Engine, password string) *httptest.ResponseRecorder {
+	return serve(r, http.MethodPost, "/login", "", gin.H{"email": lockoutTestEmail, "password": password})
+}
+
+// retryAfter checks that w is a lockout response and returns its Retry-After
+// in seconds, which the header and the body must agree on
+func retryAfter(t *testing.T, w *httptest.ResponseRecorder) int {
+	t.Helper()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d %s, want 429", w.Code, w.Body)
+	}
+	header, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil {
+		t.Fatalf("Retry-After header %q: %v", w.Header().Get("Retry-After"), err)
+	}
+	var body struct {
+		RetryAfter int `json:"retry_after"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RetryAfter != header {
+		t.Errorf("retry_after is %d but Retry-After is %d", body.RetryAfter, header)
+	}
+	return header
+}
+
+// checkRetryAfter reports whether got is want seconds, allowing for the
+// time the test takes
+func checkRetryAfter(t *testing.T, got int, want time.Duration) {
+	t.Helper()
+	if w := int(want.Seconds()); got < w-2 || got > w+1 {
+		t.Errorf("Retry-After is %d, want about %d", got, w)
+	}
+}
+
+func TestLoginLockoutAfterMaxFailures(t *testing.T) {
+	r := newLockoutTestRouter(t)
+
+	for i := 1; i <= config.LoginMaxFailures; i++ {
+		if w := loginWith(r, "wrong password"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("bad password %d: %d %s, want 401", i, w.Code, w.Body)
+		}
+	}
+	// The right password is refused too once the account is locked
+	checkRetryAfter(t, retryAfter(t, loginWith(r, testPassword)), config.LoginLockout)
+}
+
+func TestLoginLockoutDoubles(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{8, 4 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.failures), func(t *testing.T) {
+			r := newLockoutTestRouter(t)
+			addLoginFailures(t, tt.failures, time.Now())
+
+			w := loginWith(r, testPassword)
+			if tt.want == 0 {
+				if w.Code != http.StatusOK {
+					t.Fatalf("got %d %s, want 200", w.Code, w.Body)
+				}
+				return
+			}
+			checkRetryAfter(t, retryAfter(t, w), tt.want)
+		})
+	}
+}
+
+func TestLoginAttemptsDuringLockoutDoNotExtendIt(t *testing.T) {
+	r := newLockoutTestRouter(t)
+	addLoginFailures(t, config.LoginMaxFailures, time.Now().Add(-30*time.Second))
+
+	for _, password := range []string{"wrong password", testPassword, "wrong again", testPassword} {
+		checkRetryAfter(t, retryAfter(t, loginWith(r, password)), 30*time.Second)
+	}
+
+	// Once the first lockout has run out the account is open again
+	r = newLockoutTestRouter(t)
+	addLoginFailures(t, config.LoginMaxFailures, time.Now().Add(-2*time.Minute))
+	if w := loginWith(r, testPassword); w.Code != http.StatusOK {
+		t.Errorf("after the lockout: %d %s, want 200", w.Code, w.Body)
+	}
+}
+
+func TestUnlockUserClearsLockout(t *testing.T) {
+	r := newLockoutTestRouter(t)
+	token := login(t, r, "admin@school.test")
+	addLoginFailures(t, 5, time.Now())
+	retryAfter(t, loginWith(r, testPassword))
+
+	user, err := store.Users().GetByEmail(context.Background(), lockoutTestEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, http.MethodPost, "/users/"+user.ID.Hex()+"/unlock", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("unlock: %d %s", w.Code, w.Body)
+	}
+	if w := loginWith(r, testPassword); w.Code != http.StatusOK {
+		t.Errorf("login after unlock: %d %s, want 200", w.Code, w.Body)
+	}
+}
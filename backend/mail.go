@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Mail is a plain-text email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers mail. The sender is chosen with MAIL_SENDER.
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// mailer is the Mailer used by the handlers
+var mailer Mailer = logMailer{}
+
+// newMailer returns the Mailer configured by MAIL_SENDER
+func newMailer(cfg Config) (Mailer, error) {
+	switch cfg.MailSender {
+	case "log":
+		return logMailer{}, nil
+	case "file":
+		return fileMailer{dir: cfg.MailDir, from: cfg.MailFrom}, nil
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("MAIL_SENDER=smtp needs SMTP_HOST")
+		}
+		return smtpMailer{
+			host:     cfg.SMTPHost,
+			port:     cfg.SMTPPort,
+			username: cfg.SMTPUsername,
+			password: cfg.SMTPPassword,
+			from:     cfg.MailFrom,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_SENDER %q", cfg.MailSender)
+	}
+}
+
+// formatMail renders a message with its headers
+func formatMail(from string, mail Mail) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mail.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// checkMailHeaders rejects addresses and subjects that would add headers
+func checkMailHeaders(mail Mail) error {
+	if strings.ContainsAny(mail.To+mail.Subject, "\r\n") {
+		return errors.New("mail headers cannot contain line breaks")
+	}
+	return nil
+}
+
+// mailTokenPattern matches the tokens in links sent by mail
+var mailTokenPattern = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+// logMailer writes mail to the server log, for local development. Tokens in
+// links are redacted, since logs are kept and read more widely than
+// mailboxes; use MAIL_SENDER=file to follow the links.
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, mail Mail) error {
+	body := mailTokenPattern.ReplaceAllString(mail.Body, "${1}REDACTED")
+	log.Printf("mail to %s: %s\n%s", mail.To, mail.Subject, body)
+	return nil
+}
+
+// fileMailer writes each message to its own .eml file in a directory, for
+// local testing
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (m fileMailer) Send(ctx context.Context, mail Mail) error {
+	if err := checkMailHeaders(mail); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(mail.To))
+	return os.WriteFile(filepath.Join(m.dir, name), formatMail(m.from, mail), 0o644)
+}
+
+// smtpMailer delivers mail through an SMTP server, using STARTTLS when the
+// server offers it
+type smtpMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func (m smtpMailer) Send(ctx context.Context, mail Mail) error {
+	if err := checkMailHeaders(mail); err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, m.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(mail.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(formatMail(m.from, mail)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// sendMail delivers mail in the background so that slow mail servers do not
+// hold up requests, or reveal whether an address has an account. Failures
+// are logged.
+func sendMail(mail Mail) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mailer.Send(ctx, mail); err != nil {
+			log.Printf("failed to send %q to %s: %v", mail.Subject, mail.To, err)
+		}
+	}()
+}
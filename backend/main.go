@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"log"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -10,59 +13,174 @@ import (
 // Routes
 
 func main() {
-	// Initialize MongoDB
-	if err := initMongoDB(); err != nil {
-		panic(err)
+	loadConfig()
+	var err error
+	if mailer, err = newMailer(config); err != nil {
+		log.Fatal(err)
 	}
 
+	// Open the configured storage backend (STORAGE=mongo, postgres or memory)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	store, err = openStore(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := store.Migrate(ctx); err != nil {
+		log.Fatal(err)
+	}
+	cancel()
+
+	// Subcommands such as "create-admin" or "reconcile-points" run instead
+	// of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	newRouter().Run(":8080")
+}
+
+// newRouter wires up the middleware and routes against the current store
+func newRouter() *gin.Engine {
 	r := gin.Default()
+	// Client addresses are used to throttle logins, so forwarded addresses
+	// are only believed from known proxies
+	if err := r.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		ExposeHeaders:    []string{"Content-Length", "X-Total-Count", "X-Next-Cursor"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 	// User routes
 	r.POST("/signup", Signup)
 	r.POST("/login", Login)
-	r.POST("/teachers", CreateTeacher)
-	r.POST("/events", CreateEvent)
-	r.POST("/roles/:eventid", CreateRole)
+	r.POST("/login/2fa", LoginTwoFactor)
+	r.POST("/refresh", Refresh)
+	r.POST("/verify-email", VerifyEmail)
+	r.POST("/verify-email/resend", ResendVerification)
+	r.POST("/forgot-password", ForgotPassword)
+	r.POST("/reset-password", ResetPassword)
+
+	// Everything below requires a valid access token
+	api := r.Group("/", AuthRequired())
+	api.POST("/logout", Logout)
+	api.GET("/me", Me)
+	api.PUT("/me/password", ChangePassword)
+	api.POST("/me/2fa/enroll", EnrollTwoFactor)
+	api.POST("/me/2fa/confirm", ConfirmTwoFactor)
+	api.POST("/me/2fa/recovery-codes", RegenerateRecoveryCodes)
+	api.POST("/me/2fa/disable", DisableTwoFactor)
+
+	api.GET("/users", RequirePermission(PermUsersManage), ListUsers)
+	api.PUT("/users/:id/role", RequirePermission(PermUsersManage), UpdateUserRole)
+	api.GET("/users/links", RequirePermission(PermUsersManage), GetUserLinks)
+	api.PUT("/users/:id/teacher", RequirePermission(PermUsersManage), LinkUserTeacher)
+	api.DELETE("/users/:id/teacher", RequirePermission(PermUsersManage), UnlinkUserTeacher)
+	api.POST("/users/:id/unlock", RequirePermission(PermUsersManage), UnlockUser)
+	api.DELETE("/users/:id/2fa", RequirePermission(PermUsersManage), ResetUserTwoFactor)
+	api.GET("/users/login-failures", RequirePermission(PermUsersManage), ListLoginFailures)
+
+	api.POST("/teachers", RequirePermission(PermTeachersWrite), CreateTeacher)
+	api.POST("/teachers/import", RequirePermission(PermTeachersWrite), ImportTeachers)
+	api.PUT("/teachers/:id", RequirePermission(PermTeachersWrite), UpdateTeacher)
+	api.POST("/teachers/:id/deactivate", RequirePermission(PermTeachersWrite), DeactivateTeacher)
+	api.POST("/teachers/:id/reactivate", RequirePermission(PermTeachersWrite), ReactivateTeacher)
+	api.DELETE("/teachers/:id", RequirePermission(PermTeachersWrite), DeleteTeacher)
+	api.POST("/events", RequirePermission(PermEventsWrite), CreateEvent)
+	api.POST("/roles/:eventid", RequirePermission(PermEventsWrite), CreateRole)
+	api.PUT("/roles/:id", RequirePermission(PermEventsWrite), UpdateRole)
+	api.DELETE("/roles/:id", RequirePermission(PermEventsWrite), DeleteRole)
 	// Event routes
-	r.GET("/events", ListEvents)
-	// r.GET("/events/:id", GetEventByID)
-	r.GET("/teachers", ListTeachers)
+	api.GET("/events", RequirePermission(PermEventsRead), ListEvents)
+	// api.GET("/events/:id", GetEventByID)
+	api.GET("/teachers", RequirePermission(PermTeachersRead), ListTeachers)
 
-	r.PUT("/events/:id", UpdateEvent)
+	api.PUT("/events/:id", RequirePermission(PermEventsWrite), UpdateEvent)
+	api.POST("/events/:id/status", RequirePermission(PermEventsWrite), ChangeEventStatus)
 
 	// Role routes
 
-	r.GET("/events/:id/roles", GetRolesByEventID)
+	api.GET("/events/:id/roles", RequirePermission(PermEventsRead), GetRolesByEventID)
 
 	// Teacher routes
 
-	r.GET("/teachers/top", GetTopTeachers)
+	api.GET("/teachers/top", RequirePermission(PermTeachersRead), GetTopTeachers)
+	api.GET("/teachers/:id/rank", RequirePermission(PermTeachersRead), GetTeacherRank)
+	api.GET("/leaderboard/departments", RequirePermission(PermTeachersRead), GetDepartmentLeaderboard)
+
+	// Department routes
+	api.GET("/departments", RequirePermission(PermTeachersRead), ListDepartments)
+	api.POST("/departments", RequirePermission(PermDepartmentsWrite), CreateDepartment)
+	api.GET("/departments/:id", RequirePermission(PermTeachersRead), GetDepartment)
+	api.PUT("/departments/:id", RequirePermission(PermDepartmentsWrite), UpdateDepartment)
+	api.DELETE("/departments/:id", RequirePermission(PermDepartmentsWrite), DeleteDepartment)
+	api.GET("/departments/:id/teachers", RequirePermission(PermTeachersRead), ListDepartmentTeachers)
+	api.GET("/departments/:id/points", RequirePermission(PermTeachersRead), GetDepartmentPoints)
+
+	// Term routes
+	api.GET("/terms", RequirePermission(PermEventsRead), ListTerms)
+	api.POST("/terms", RequirePermission(PermTermsWrite), CreateTerm)
+	api.GET("/terms/current", RequirePermission(PermEventsRead), GetCurrentTerm)
+	api.GET("/terms/:id", RequirePermission(PermEventsRead), GetTerm)
+	api.PUT("/terms/:id", RequirePermission(PermTermsWrite), UpdateTerm)
+	api.POST("/terms/:id/close", RequirePermission(PermTermsWrite), CloseTerm)
+	api.GET("/terms/:id/standings", RequirePermission(PermTeachersRead), GetTermStandings)
+
+	// Points ledger routes
+	api.GET("/teachers/:id/points/ledger", RequireSelfOrPermission(PermPointsRead, "id"), GetTeacherLedger)
+	api.POST("/teachers/:id/points/adjustments", RequirePermission(PermPointsWrite), AdjustTeacherPoints)
+	api.POST("/points/ledger/:id/reverse", RequirePermission(PermPointsWrite), ReverseLedgerEntry)
+	api.POST("/points/reconcile", RequirePermission(PermPointsWrite), ReconcilePoints)
 
 	// Assignment routes
-	r.POST("/assignments", AssignTeacherToRole)
+	api.POST("/assignments", RequirePermission(PermAssignmentsWrite), AssignTeacherToRole)
 
-	r.DELETE("/delete-role-assignment", DeleteRoleAssignment)
+	api.DELETE("/delete-role-assignment", RequirePermission(PermAssignmentsWrite), DeleteRoleAssignment)
 
 	// GET: Get all assignments for a specific teacher
-	r.GET("/teacher-assignments/:id", GetTeacherAssignments)
+	api.GET("/teacher-assignments/:id", RequireSelfOrPermission(PermAssignmentsRead, "id"), GetTeacherAssignments)
 
 	// GET: Get all assignments for a specific role
-	r.GET("/role-assignments/:id", GetRoleAssignments)
+	api.GET("/role-assignments/:id", RequirePermission(PermAssignmentsRead), GetRoleAssignments)
+
+	// Attendance routes
+	api.PUT("/assignments/:id/attendance", RequirePermission(PermAttendanceWrite), MarkAttendance)
+	api.PUT("/events/:id/attendance", RequirePermission(PermAttendanceWrite), MarkEventAttendance)
+	api.GET("/attendance/unconfirmed", RequirePermission(PermAssignmentsRead), ListUnconfirmedAttendance)
+
+	// Check-in routes
+	api.GET("/events/:id/checkin-code", RequirePermission(PermAttendanceWrite), GetCheckInCode)
+	api.GET("/events/:id/checkin-code/qr", RequirePermission(PermAttendanceWrite), GetCheckInQR)
+	api.POST("/checkin", CheckIn)
+
+	// Self sign-up routes
+	api.GET("/signups/open", RequirePermission(PermSignup), ListOpenRoles)
+	api.POST("/signups", RequirePermission(PermSignup), SignUpForRole)
+	api.DELETE("/signups/:id", RequirePermission(PermSignup), WithdrawFromRole)
+	api.GET("/signups/requests", RequirePermission(PermSignup), ListMyVolunteerRequests)
+	api.DELETE("/signups/requests/:id", RequirePermission(PermSignup), WithdrawVolunteerRequest)
+
+	// Volunteer approval routes
+	api.GET("/events/:id/volunteer-requests", RequirePermission(PermAssignmentsRead), ListEventVolunteerRequests)
+	api.POST("/volunteer-requests/decisions", RequirePermission(PermAssignmentsWrite), DecideVolunteerRequests)
+	api.GET("/volunteer-requests/history", RequirePermission(PermAssignmentsRead), GetVolunteerRequestHistory)
+
+	// Waitlist routes
+	api.GET("/roles/:id/waitlist", RequirePermission(PermAssignmentsRead), GetRoleWaitlist)
+	api.PUT("/roles/:id/waitlist", RequirePermission(PermAssignmentsWrite), ReorderRoleWaitlist)
+	api.DELETE("/waitlist/:id", RemoveWaitlistEntry)
 
-	r.DELETE("/event", DeleteEvent)
+	api.DELETE("/event", RequirePermission(PermEventsWrite), DeleteEvent)
 
-	r.GET("/event/:id/roles", GetRolesByEventID)
+	api.GET("/event/:id/roles", RequirePermission(PermEventsRead), GetRolesByEventID)
 
-	r.GET("/teacher/:teacherid/event/:eventid/roles", GetTeacherRolesInEvent)
+	api.GET("/teacher/:teacherid/event/:eventid/roles", RequireSelfOrPermission(PermAssignmentsRead, "teacherid"), GetTeacherRolesInEvent)
 
-	r.GET("/events/assigned-teachers/:eventid", GetAssignedTeachersForEvent)
+	api.GET("/events/assigned-teachers/:eventid", RequirePermission(PermAssignmentsRead), GetAssignedTeachersForEvent)
 
-	r.Run(":8080")
+	return r
 }
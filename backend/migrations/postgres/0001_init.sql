@@ -0,0 +1,94 @@
+-- Initial schema. IDs are the 24 character hex form of the ObjectIDs the
+-- MongoDB backend uses, so records keep their IDs when moving between stores.
+
+CREATE TABLE departments (
+    id              CHAR(24) PRIMARY KEY,
+    department_name VARCHAR(255) NOT NULL UNIQUE
+);
+
+CREATE TABLE users (
+    id       CHAR(24) PRIMARY KEY,
+    name     VARCHAR(255) NOT NULL DEFAULT '',
+    email    VARCHAR(255) NOT NULL UNIQUE,
+    password VARCHAR(255) NOT NULL,
+    role     VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'teacher')),
+    user_id  CHAR(24)
+);
+
+CREATE TABLE teachers (
+    id             CHAR(24) PRIMARY KEY,
+    name           VARCHAR(255) NOT NULL,
+    email          VARCHAR(255) NOT NULL DEFAULT '',
+    departmentname VARCHAR(255) NOT NULL DEFAULT '',
+    profile_photo  TEXT NOT NULL DEFAULT '',
+    point          INT NOT NULL DEFAULT 0,
+    user_id        CHAR(24)
+);
+
+CREATE INDEX teachers_email_idx ON teachers (email);
+
+CREATE TABLE events (
+    id          CHAR(24) PRIMARY KEY,
+    name        VARCHAR(255) NOT NULL,
+    start_date  VARCHAR(50) NOT NULL DEFAULT '',
+    start_time  VARCHAR(50) NOT NULL DEFAULT '',
+    end_date    VARCHAR(50) NOT NULL DEFAULT '',
+    end_time    VARCHAR(50) NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE roles (
+    id         CHAR(24) PRIMARY KEY,
+    event_id   CHAR(24) NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+    event_name VARCHAR(255) NOT NULL DEFAULT '',
+    name       VARCHAR(255) NOT NULL,
+    point      INT NOT NULL DEFAULT 0,
+    head_count INT NOT NULL DEFAULT 0 CHECK (head_count >= 0)
+);
+
+CREATE INDEX roles_event_id_idx ON roles (event_id);
+
+CREATE TABLE teacher_assignments (
+    id         CHAR(24) PRIMARY KEY,
+    event_id   CHAR(24) NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+    event_name VARCHAR(255) NOT NULL DEFAULT '',
+    teacher_id CHAR(24) NOT NULL REFERENCES teachers (id) ON DELETE CASCADE,
+    role_id    CHAR(24) NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    role_name  VARCHAR(255) NOT NULL DEFAULT '',
+    UNIQUE (role_id, teacher_id)
+);
+
+CREATE INDEX teacher_assignments_event_id_idx ON teacher_assignments (event_id);
+CREATE INDEX teacher_assignments_teacher_id_idx ON teacher_assignments (teacher_id);
+
+-- The ledger keeps event, role and assignment IDs after those records are
+-- deleted, so only the teacher and reversed entry are foreign keys
+CREATE TABLE points_ledger (
+    id            CHAR(24) PRIMARY KEY,
+    teacher_id    CHAR(24) NOT NULL REFERENCES teachers (id),
+    type          VARCHAR(20) NOT NULL,
+    points        INT NOT NULL,
+    event_id      CHAR(24),
+    event_name    VARCHAR(255) NOT NULL DEFAULT '',
+    role_id       CHAR(24),
+    role_name     VARCHAR(255) NOT NULL DEFAULT '',
+    assignment_id CHAR(24),
+    reverses_id   CHAR(24) UNIQUE REFERENCES points_ledger (id),
+    actor_id      CHAR(24),
+    reason        TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX points_ledger_teacher_id_idx ON points_ledger (teacher_id, created_at);
+CREATE INDEX points_ledger_assignment_id_idx ON points_ledger (assignment_id);
+
+CREATE TABLE sessions (
+    id         CHAR(24) PRIMARY KEY,
+    user_id    CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    refresh_id VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
@@ -0,0 +1,6 @@
+-- Teachers reference a department instead of only naming one.
+-- departmentname is kept as a copy of the department's name.
+
+ALTER TABLE teachers ADD COLUMN department_id CHAR(24) REFERENCES departments (id);
+
+CREATE INDEX teachers_department_id_idx ON teachers (department_id);
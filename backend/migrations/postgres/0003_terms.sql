@@ -0,0 +1,47 @@
+-- Academic terms. Events and ledger entries belong to a term so points and
+-- leaderboards can be reported per term; closing a term archives its final
+-- standings in term_standings.
+
+CREATE TABLE terms (
+    id         CHAR(24) PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    start_date DATE NOT NULL,
+    end_date   DATE NOT NULL,
+    status     VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed')),
+    closed_at  TIMESTAMPTZ,
+    closed_by  CHAR(24),
+    CHECK (end_date >= start_date)
+);
+
+ALTER TABLE events ADD COLUMN term_id CHAR(24) REFERENCES terms (id);
+CREATE INDEX events_term_id_idx ON events (term_id);
+
+ALTER TABLE points_ledger ADD COLUMN term_id CHAR(24) REFERENCES terms (id);
+CREATE INDEX points_ledger_term_id_idx ON points_ledger (term_id, teacher_id);
+
+-- Teachers are not foreign keys so that standings outlive the teacher
+-- records they were taken from
+CREATE TABLE term_standings (
+    id              CHAR(24) PRIMARY KEY,
+    term_id         CHAR(24) NOT NULL REFERENCES terms (id),
+    rank            INT NOT NULL,
+    department_rank INT NOT NULL DEFAULT 0,
+    teacher_id      CHAR(24) NOT NULL,
+    teacher_name    VARCHAR(255) NOT NULL DEFAULT '',
+    department_id   CHAR(24),
+    department_name VARCHAR(255) NOT NULL DEFAULT '',
+    points          INT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    UNIQUE (term_id, teacher_id)
+);
+
+-- Archived standings are immutable
+CREATE FUNCTION term_standings_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'archived term standings cannot be changed';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER term_standings_immutable
+    BEFORE UPDATE OR DELETE ON term_standings
+    FOR EACH ROW EXECUTE FUNCTION term_standings_immutable();
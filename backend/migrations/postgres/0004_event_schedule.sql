@@ -0,0 +1,11 @@
+-- Events are scheduled by instants. The date and time strings remain as the
+-- same instants in the school timezone; rows created before this migration
+-- get starts_at and ends_at from them on startup.
+
+ALTER TABLE events
+    ADD COLUMN starts_at TIMESTAMPTZ,
+    ADD COLUMN ends_at   TIMESTAMPTZ,
+    ADD COLUMN all_day   BOOLEAN NOT NULL DEFAULT false,
+    ADD CONSTRAINT events_schedule_check CHECK (ends_at > starts_at);
+
+CREATE INDEX events_starts_at_idx ON events (starts_at);
@@ -0,0 +1,25 @@
+-- Events may name the department organising them, and the event listing
+-- filters and pages on these columns.
+
+ALTER TABLE events ADD COLUMN department_id CHAR(24) REFERENCES departments (id);
+
+DROP INDEX events_term_id_idx;
+CREATE INDEX events_term_id_idx ON events (term_id, starts_at);
+CREATE INDEX events_department_id_idx ON events (department_id, starts_at);
+
+DROP INDEX events_starts_at_idx;
+CREATE INDEX events_starts_at_idx ON events (starts_at, id);
+CREATE INDEX events_ends_at_idx ON events (ends_at);
+CREATE INDEX events_name_idx ON events (name, id);
+
+-- Substring search uses trigram indexes when pg_trgm can be installed;
+-- without it searches scan the table.
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS pg_trgm;
+    CREATE INDEX events_name_trgm_idx ON events USING gin (name gin_trgm_ops);
+    CREATE INDEX events_description_trgm_idx ON events USING gin (description gin_trgm_ops);
+EXCEPTION WHEN insufficient_privilege OR undefined_file THEN
+    RAISE NOTICE 'pg_trgm is not available; event search is not indexed';
+END
+$$;
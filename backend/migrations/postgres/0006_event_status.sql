@@ -0,0 +1,23 @@
+-- Events move through draft, published, in_progress, completed and
+-- cancelled. Existing events were already live: they become completed if
+-- they have ended and published otherwise. Their assignments were credited
+-- when they were made, so their points are marked awarded.
+
+ALTER TABLE events
+    ADD COLUMN status        VARCHAR(20) NOT NULL DEFAULT 'draft'
+        CHECK (status IN ('draft', 'published', 'in_progress', 'completed', 'cancelled')),
+    ADD COLUMN completed_at  TIMESTAMPTZ,
+    ADD COLUMN cancelled_at  TIMESTAMPTZ,
+    ADD COLUMN cancelled_by  CHAR(24),
+    ADD COLUMN cancel_policy VARCHAR(20) NOT NULL DEFAULT '',
+    ADD COLUMN cancel_reason TEXT NOT NULL DEFAULT '';
+
+UPDATE events SET status = CASE WHEN ends_at <= now() THEN 'completed' ELSE 'published' END;
+
+CREATE INDEX events_status_idx ON events (status, starts_at);
+
+ALTER TABLE teacher_assignments
+    ADD COLUMN points_status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (points_status IN ('pending', 'awarded', 'voided', 'reversed'));
+
+UPDATE teacher_assignments SET points_status = 'awarded';
@@ -0,0 +1,15 @@
+-- Attendance on assignments, and the coordinator user role that records it.
+-- Assignments whose points were already credited count as attended.
+
+ALTER TABLE users DROP CONSTRAINT users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('admin', 'coordinator', 'teacher'));
+
+ALTER TABLE teacher_assignments
+    ADD COLUMN attendance    VARCHAR(20) NOT NULL DEFAULT 'assigned'
+        CHECK (attendance IN ('assigned', 'attended', 'absent', 'excused')),
+    ADD COLUMN attendance_at TIMESTAMPTZ,
+    ADD COLUMN attendance_by CHAR(24);
+
+UPDATE teacher_assignments SET attendance = 'attended' WHERE points_status IN ('awarded', 'reversed');
+
+CREATE INDEX teacher_assignments_attendance_idx ON teacher_assignments (attendance, event_id);
@@ -0,0 +1,3 @@
+-- When a teacher checked in to an assignment with an event QR code.
+
+ALTER TABLE teacher_assignments ADD COLUMN checked_in_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+-- Assignments teachers volunteered for themselves.
+
+ALTER TABLE teacher_assignments ADD COLUMN self_signup BOOLEAN NOT NULL DEFAULT FALSE;
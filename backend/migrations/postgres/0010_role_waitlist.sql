@@ -0,0 +1,16 @@
+-- Teachers waiting for a place on a full role, promoted in position order.
+
+CREATE TABLE role_waitlist (
+    id           CHAR(24) PRIMARY KEY,
+    role_id      CHAR(24) NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    event_id     CHAR(24) NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+    teacher_id   CHAR(24) NOT NULL REFERENCES teachers (id) ON DELETE CASCADE,
+    teacher_name VARCHAR(255) NOT NULL DEFAULT '',
+    position     INTEGER NOT NULL,
+    self_signup  BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at   TIMESTAMPTZ NOT NULL,
+    UNIQUE (role_id, teacher_id)
+);
+
+CREATE INDEX role_waitlist_role_position_idx ON role_waitlist (role_id, position);
+CREATE INDEX role_waitlist_teacher_id_idx ON role_waitlist (teacher_id);
@@ -0,0 +1,24 @@
+-- Sign-ups for high-point roles that wait for an admin's decision. Decisions
+-- are kept as history after the event or role is gone, so only the teacher
+-- is a foreign key.
+
+CREATE TABLE volunteer_requests (
+    id            CHAR(24) PRIMARY KEY,
+    event_id      CHAR(24) NOT NULL,
+    event_name    VARCHAR(255) NOT NULL DEFAULT '',
+    role_id       CHAR(24) NOT NULL,
+    role_name     VARCHAR(255) NOT NULL DEFAULT '',
+    points        INT NOT NULL DEFAULT 0,
+    teacher_id    CHAR(24) NOT NULL REFERENCES teachers (id) ON DELETE CASCADE,
+    teacher_name  VARCHAR(255) NOT NULL DEFAULT '',
+    status        VARCHAR(20) NOT NULL
+        CHECK (status IN ('pending', 'approved', 'rejected', 'withdrawn')),
+    created_at    TIMESTAMPTZ NOT NULL,
+    decided_at    TIMESTAMPTZ,
+    decided_by    CHAR(24),
+    reason        TEXT NOT NULL DEFAULT '',
+    assignment_id CHAR(24)
+);
+
+CREATE INDEX volunteer_requests_event_status_idx ON volunteer_requests (event_id, status);
+CREATE INDEX volunteer_requests_teacher_role_idx ON volunteer_requests (teacher_id, role_id);
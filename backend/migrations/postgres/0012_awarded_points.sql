@@ -0,0 +1,9 @@
+-- The points an assignment was credited with, kept apart from the role's
+-- current point value so re-pricing a role can leave past awards alone.
+
+ALTER TABLE teacher_assignments ADD COLUMN awarded_points INTEGER NOT NULL DEFAULT 0;
+
+UPDATE teacher_assignments a
+   SET awarded_points = r.point
+  FROM roles r
+ WHERE r.id = a.role_id AND a.points_status = 'awarded';
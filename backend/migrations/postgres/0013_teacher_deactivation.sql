@@ -0,0 +1,4 @@
+-- Staff who have left are deactivated rather than deleted, keeping their
+-- assignments and points.
+
+ALTER TABLE teachers ADD COLUMN deactivated_at TIMESTAMPTZ;
@@ -0,0 +1,43 @@
+-- users.user_id names the teacher an account is linked to and
+-- teachers.user_id the account a teacher is linked to. Older versions stored
+-- the teachers' own IDs there. Pointers to missing records are cleared, and
+-- where several records name the same one all but one are cleared, so that
+-- links can be unique. The server completes one-sided links on startup.
+
+UPDATE users SET user_id = NULL
+WHERE user_id IS NOT NULL AND user_id NOT IN (SELECT id FROM teachers);
+
+UPDATE teachers SET user_id = NULL
+WHERE user_id IS NOT NULL AND user_id NOT IN (SELECT id FROM users);
+
+-- Prefer the record the other side names back, then the one with the same
+-- email
+UPDATE users SET user_id = NULL
+WHERE id IN (
+    SELECT id FROM (
+        SELECT u.id, row_number() OVER (
+            PARTITION BY u.user_id
+            ORDER BY t.user_id IS NOT DISTINCT FROM u.id DESC, u.email = t.email DESC, u.id
+        ) AS n
+        FROM users u JOIN teachers t ON t.id = u.user_id
+    ) ranked
+    WHERE n > 1
+);
+
+UPDATE teachers SET user_id = NULL
+WHERE id IN (
+    SELECT id FROM (
+        SELECT t.id, row_number() OVER (
+            PARTITION BY t.user_id
+            ORDER BY u.user_id IS NOT DISTINCT FROM t.id DESC, t.email = u.email DESC, t.id
+        ) AS n
+        FROM teachers t JOIN users u ON u.id = t.user_id
+    ) ranked
+    WHERE n > 1
+);
+
+CREATE UNIQUE INDEX users_user_id_key ON users (user_id);
+CREATE UNIQUE INDEX teachers_user_id_key ON teachers (user_id);
+
+ALTER TABLE users ADD FOREIGN KEY (user_id) REFERENCES teachers (id) ON DELETE SET NULL;
+ALTER TABLE teachers ADD FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL;
@@ -0,0 +1,18 @@
+-- Email verification and password resets. Accounts created before email
+-- verification count as verified. Only hashes of the mailed tokens are
+-- stored.
+
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+UPDATE users SET email_verified_at = now();
+
+CREATE TABLE user_tokens (
+    id         CHAR(24) PRIMARY KEY,
+    user_id    CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose    VARCHAR(20) NOT NULL CHECK (purpose IN ('verify_email', 'reset_password')),
+    hash       CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX user_tokens_user_purpose_idx ON user_tokens (user_id, purpose);
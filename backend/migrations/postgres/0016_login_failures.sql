@@ -0,0 +1,16 @@
+-- Failed logins, counted per email and per client address for lockouts and
+-- throttling. Emails are kept as entered, so failures for unknown accounts
+-- are recorded too.
+
+CREATE TABLE login_failures (
+    id         CHAR(24) PRIMARY KEY,
+    email      TEXT NOT NULL,
+    user_id    CHAR(24) REFERENCES users (id) ON DELETE SET NULL,
+    ip         TEXT NOT NULL,
+    reason     VARCHAR(20) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    cleared_at TIMESTAMPTZ
+);
+
+CREATE INDEX login_failures_email_idx ON login_failures (email, created_at);
+CREATE INDEX login_failures_ip_idx ON login_failures (ip, created_at);
@@ -0,0 +1,8 @@
+-- TOTP two-factor authentication. recovery_codes holds hashes of the unused
+-- recovery codes.
+
+ALTER TABLE users
+    ADD COLUMN totp_secret     TEXT NOT NULL DEFAULT '',
+    ADD COLUMN totp_enabled_at TIMESTAMPTZ,
+    ADD COLUMN totp_last_step  BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN recovery_codes  TEXT[];
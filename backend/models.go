@@ -1,16 +1,11 @@
 package main
 
 import (
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// MongoDB client and database
-var client *mongo.Client
-var db *mongo.Database
-
-// Initialize MongoDB connection
-
 // Collection names
 const (
 	userCollection              = "users"
@@ -19,6 +14,14 @@ const (
 	roleCollection              = "roles"
 	teacherAssignmentCollection = "teacherAssignments"
 	departmentCollection        = "departments"
+	sessionCollection           = "sessions"
+	pointsLedgerCollection      = "pointsLedger"
+	termCollection              = "terms"
+	termStandingCollection      = "termStandings"
+	waitlistCollection          = "roleWaitlist"
+	volunteerRequestCollection  = "volunteerRequests"
+	userTokenCollection         = "userTokens"
+	loginFailureCollection      = "loginFailures"
 )
 
 // User struct
@@ -28,10 +31,26 @@ type User struct {
 	Email    string             `json:"email" bson:"email"`
 	Password string             `json:"password,omitempty" bson:"password"`
 	Role     string             `json:"role" bson:"role"`
-	UserID   primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	// UserID is the teacher the account is linked to, if any
+	UserID primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	// EmailVerifiedAt is when the user proved they own the email address.
+	// It is stored even when nil so that accounts from before verification
+	// can be told apart.
+	EmailVerifiedAt *time.Time `json:"email_verified_at" bson:"email_verified_at"`
+	// TOTPSecret is the two-factor secret. It is set during enrollment, and
+	// only required at login once TOTPEnabledAt is set.
+	TOTPSecret    string     `json:"-" bson:"totp_secret,omitempty"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at,omitempty" bson:"totp_enabled_at,omitempty"`
+	// TOTPLastStep is the time step of the last code accepted, which cannot
+	// be used again
+	TOTPLastStep int64 `json:"-" bson:"totp_last_step,omitempty"`
+	// RecoveryCodes holds hashes of the unused two-factor recovery codes
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
 }
 
-// Event struct
+// Event struct. StartsAt and EndsAt are the schedule; the four date and time
+// strings are the same instants in the school timezone, kept for older
+// clients. All-day events end at midnight after their last day.
 type Event struct {
 	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	EventID     primitive.ObjectID `json:"event_id,omitempty" bson:"event_id,omitempty"`
@@ -40,10 +59,22 @@ type Event struct {
 	StartTime   string             `json:"start_time" bson:"start_time"`
 	EndDate     string             `json:"end_date" bson:"end_date"`
 	EndTime     string             `json:"end_time" bson:"end_time"`
+	StartsAt    time.Time          `json:"starts_at" bson:"starts_at,omitempty"`
+	EndsAt      time.Time          `json:"ends_at" bson:"ends_at,omitempty"`
+	AllDay      bool               `json:"all_day" bson:"all_day,omitempty"`
 	Description string             `json:"description" bson:"description"`
+	TermID      primitive.ObjectID `json:"term_id,omitempty" bson:"term_id,omitempty"`
+	// DepartmentID is the department organising the event, if any
+	DepartmentID primitive.ObjectID `json:"department_id,omitempty" bson:"department_id,omitempty"`
+	Status       string             `json:"status" bson:"status"`
+	CompletedAt  *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	CancelledAt  *time.Time         `json:"cancelled_at,omitempty" bson:"cancelled_at,omitempty"`
+	CancelledBy  primitive.ObjectID `json:"cancelled_by,omitempty" bson:"cancelled_by,omitempty"`
+	CancelPolicy string             `json:"cancel_policy,omitempty" bson:"cancel_policy,omitempty"`
+	CancelReason string             `json:"cancel_reason,omitempty" bson:"cancel_reason,omitempty"`
 	// Roles       []primitive.ObjectID `json:"roles,omitempty" bson:"roles,omitempty"`
-	Roles            []RoleRef `json:"roles,omitempty" bson:"roles,omitempty"`
-	Assginedteachers []RoleRef `json:"assginedteachers,omitempty" bson:"assginedteachers,omitempty"`
+	Roles            []RoleRef  `json:"roles,omitempty" bson:"roles,omitempty"`
+	Assginedteachers []RoleRef1 `json:"assginedteachers,omitempty" bson:"assginedteachers,omitempty"`
 }
 
 // Teacher struct
@@ -51,12 +82,16 @@ type Teacher struct {
 	ID             primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Name           string             `json:"name" bson:"name"`
 	Email          string             `json:"email" bson:"email"`
+	DepartmentID   primitive.ObjectID `json:"department_id,omitempty" bson:"department_id,omitempty"`
 	Departmentname string             `json:"departmentname" bson:"departmentname"`
 	ProfilePhoto   string             `json:"profile_photo" bson:"profile_photo"`
 	Point          int                `json:"point,omitempty" bson:"point,omitempty"`
 	// UserID       primitive.ObjectID `json:"user_id" bson:"user_id"`
+	// UserID is the user account linked to the teacher, if any
 	UserID           primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
 	Assginedteachers []RoleRef          `json:"assginedteachers,omitempty" bson:"assginedteachers,omitempty"`
+	// DeactivatedAt is set for staff who have left; their history is kept
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" bson:"deactivated_at,omitempty"`
 }
 type RoleRef struct {
 	ID   primitive.ObjectID `json:"id" bson:"id"`
@@ -82,7 +117,12 @@ type Role struct {
 	EventName string `json:"eventname,omitempty" bson:"eventname,omitempty"`
 }
 
-// Assignment struct
+// Assignment struct. Attendance records whether the teacher turned up;
+// CheckedInAt is set when the teacher checked in themselves with a QR code.
+// SelfSignup marks assignments the teacher volunteered for.
+// PointsStatus tracks the role's points: pending until the event completes
+// and the teacher's attendance is confirmed, then awarded, or voided or
+// reversed if the teacher was absent or the event cancelled.
 type Assignment struct {
 	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	AssignmentID primitive.ObjectID `json:"assignmentid,omitempty" bson:"assignmentid,omitempty"`
@@ -91,6 +131,146 @@ type Assignment struct {
 	TeacherID    primitive.ObjectID `json:"teacher_id" bson:"teacher_id"`
 	RoleID       primitive.ObjectID `json:"role_id" bson:"role_id"`
 	RoletName    string             `json:"roletname" bson:"roletname"`
+	PointsStatus string             `json:"points_status" bson:"points_status"`
+	Attendance   string             `json:"attendance" bson:"attendance"`
+	AttendanceAt *time.Time         `json:"attendance_at,omitempty" bson:"attendance_at,omitempty"`
+	AttendanceBy primitive.ObjectID `json:"attendance_by,omitempty" bson:"attendance_by,omitempty"`
+	CheckedInAt  *time.Time         `json:"checked_in_at,omitempty" bson:"checked_in_at,omitempty"`
+	SelfSignup   bool               `json:"self_signup,omitempty" bson:"self_signup,omitempty"`
+	// AwardedPoints is what the assignment was credited with, which stays
+	// put when the role is re-priced under the keep policy
+	AwardedPoints int `json:"awarded_points,omitempty" bson:"awarded_points"`
 }
 
 // Department struct
+type Department struct {
+	ID   primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name string             `json:"department_name" bson:"department_name"`
+}
+
+// WaitlistEntry is a teacher waiting for a place on a full role. Entries are
+// promoted in Position order.
+type WaitlistEntry struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	RoleID      primitive.ObjectID `json:"role_id" bson:"role_id"`
+	EventID     primitive.ObjectID `json:"event_id" bson:"event_id"`
+	TeacherID   primitive.ObjectID `json:"teacher_id" bson:"teacher_id"`
+	TeacherName string             `json:"teacher_name" bson:"teacher_name"`
+	Position    int                `json:"position" bson:"position"`
+	SelfSignup  bool               `json:"self_signup,omitempty" bson:"self_signup,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// VolunteerRequest is a teacher's request to sign up for a role that needs
+// an admin's approval. An approved request becomes an assignment, or a
+// waitlist entry if the role has filled up in the meantime.
+type VolunteerRequest struct {
+	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	EventID      primitive.ObjectID `json:"event_id" bson:"event_id"`
+	EventName    string             `json:"eventname" bson:"eventname"`
+	RoleID       primitive.ObjectID `json:"role_id" bson:"role_id"`
+	RoleName     string             `json:"rolename" bson:"rolename"`
+	Points       int                `json:"points" bson:"points"`
+	TeacherID    primitive.ObjectID `json:"teacher_id" bson:"teacher_id"`
+	TeacherName  string             `json:"teacher_name" bson:"teacher_name"`
+	Status       string             `json:"status" bson:"status"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	DecidedAt    *time.Time         `json:"decided_at,omitempty" bson:"decided_at,omitempty"`
+	DecidedBy    primitive.ObjectID `json:"decided_by,omitempty" bson:"decided_by,omitempty"`
+	Reason       string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	AssignmentID primitive.ObjectID `json:"assignment_id,omitempty" bson:"assignment_id,omitempty"`
+}
+
+// Session struct
+type Session struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	RefreshID string             `json:"-" bson:"refresh_id"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+	RevokedAt *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// Purposes of a UserToken
+const (
+	tokenVerifyEmail   = "verify_email"
+	tokenResetPassword = "reset_password"
+)
+
+// UserToken is a single-use token mailed to a user to verify their email
+// address or reset their password. Only a hash of the token is stored.
+type UserToken struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Purpose   string             `json:"purpose" bson:"purpose"`
+	Hash      string             `json:"-" bson:"hash"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time         `json:"used_at,omitempty" bson:"used_at,omitempty"`
+}
+
+// Reasons a login failed
+const (
+	loginUnknownEmail = "unknown_email"
+	loginBadPassword  = "bad_password"
+	loginBadCode      = "bad_2fa_code"
+	loginLocked       = "locked"       // the account was locked out
+	loginThrottled    = "ip_throttled" // the address had too many failures
+)
+
+// LoginFailure records a failed login. Failures count towards lockouts until
+// the account logs in, resets its password or is unlocked, which sets
+// ClearedAt.
+type LoginFailure struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Email     string             `json:"email" bson:"email"` // as entered, lower-cased
+	UserID    primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	Reason    string             `json:"reason" bson:"reason"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ClearedAt *time.Time         `json:"cleared_at,omitempty" bson:"cleared_at,omitempty"`
+}
+
+// LedgerEntry struct. Entries are append-only; Points is signed.
+type LedgerEntry struct {
+	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	TeacherID    primitive.ObjectID `json:"teacher_id" bson:"teacher_id"`
+	Type         string             `json:"type" bson:"type"`
+	Points       int                `json:"points" bson:"points"`
+	EventID      primitive.ObjectID `json:"event_id,omitempty" bson:"event_id,omitempty"`
+	EventName    string             `json:"eventname,omitempty" bson:"eventname,omitempty"`
+	RoleID       primitive.ObjectID `json:"role_id,omitempty" bson:"role_id,omitempty"`
+	RoleName     string             `json:"rolename,omitempty" bson:"rolename,omitempty"`
+	AssignmentID primitive.ObjectID `json:"assignment_id,omitempty" bson:"assignment_id,omitempty"`
+	ReversesID   primitive.ObjectID `json:"reverses_id,omitempty" bson:"reverses_id,omitempty"`
+	TermID       primitive.ObjectID `json:"term_id,omitempty" bson:"term_id,omitempty"`
+	ActorID      primitive.ObjectID `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Reason       string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Term struct. StartDate and EndDate are inclusive calendar days (YYYY-MM-DD).
+type Term struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	StartDate string             `json:"start_date" bson:"start_date"`
+	EndDate   string             `json:"end_date" bson:"end_date"`
+	Status    string             `json:"status" bson:"status"`
+	ClosedAt  *time.Time         `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+	ClosedBy  primitive.ObjectID `json:"closed_by,omitempty" bson:"closed_by,omitempty"`
+}
+
+// TermStanding is a teacher's final result in a closed term. Standings are
+// archived once, when the term closes, and never change afterwards.
+type TermStanding struct {
+	ID             primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	TermID         primitive.ObjectID `json:"term_id" bson:"term_id"`
+	Rank           int                `json:"rank" bson:"rank"`
+	DepartmentRank int                `json:"department_rank,omitempty" bson:"department_rank,omitempty"`
+	TeacherID      primitive.ObjectID `json:"teacher_id" bson:"teacher_id"`
+	TeacherName    string             `json:"teacher_name" bson:"teacher_name"`
+	DepartmentID   primitive.ObjectID `json:"department_id,omitempty" bson:"department_id,omitempty"`
+	DepartmentName string             `json:"department_name,omitempty" bson:"department_name,omitempty"`
+	Points         int                `json:"points" bson:"points"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcryptMaxBytes is the longest password bcrypt hashes in full; it ignores
+// anything after that
+const bcryptMaxBytes = 72
+
+// checkPassword applies the password rules to a new password for the account
+// with the given email. The error is meant for the user.
+func checkPassword(password, email string) error {
+	if len(password) > bcryptMaxBytes {
+		return fmt.Errorf("Password must be at most %d bytes long", bcryptMaxBytes)
+	}
+	if utf8.RuneCountInString(password) < config.PasswordMinLength {
+		return fmt.Errorf("Password must be at least %d characters long", config.PasswordMinLength)
+	}
+	if passwordClasses(password) < config.PasswordMinClasses {
+		return fmt.Errorf("Password must use at least %d of lower case letters, upper case letters, digits and symbols",
+			config.PasswordMinClasses)
+	}
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if len(local) >= 3 && strings.Contains(strings.ToLower(password), local) {
+		return errors.New("Password must not contain your email address")
+	}
+	return nil
+}
+
+// passwordClasses counts the kinds of character a password uses
+func passwordClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	count := 0
+	for _, used := range []bool{lower, upper, digit, other} {
+		if used {
+			count++
+		}
+	}
+	return count
+}
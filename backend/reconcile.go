@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReconcileLine compares what a teacher holds for one assignment with what
+// the assignment was awarded
+type ReconcileLine struct {
+	AssignmentID primitive.ObjectID `json:"assignment_id"`
+	EventName    string             `json:"eventname,omitempty"`
+	RoleName     string             `json:"rolename,omitempty"`
+	Expected     int                `json:"expected"`
+	Held         int                `json:"held"`
+	Diff         int                `json:"diff"`
+	Deleted      bool               `json:"deleted"`
+
+	termID primitive.ObjectID
+}
+
+// ReconcileReport is the reconciliation result for one teacher
+type ReconcileReport struct {
+	TeacherID     primitive.ObjectID `json:"teacher_id"`
+	TeacherName   string             `json:"teacher_name"`
+	StoredPoints  int                `json:"stored_points"`
+	LedgerBalance int                `json:"ledger_balance"`
+	Expected      int                `json:"expected"`
+	Diff          int                `json:"diff"`
+	Lines         []ReconcileLine    `json:"lines,omitempty"`
+}
+
+// InSync reports whether the stored total, the ledger and the source data agree
+func (r ReconcileReport) InSync() bool {
+	return r.Diff == 0 && r.StoredPoints == r.LedgerBalance
+}
+
+// reconcilePoints recomputes every teacher's total from their assignments and
+// the points each was awarded at. Only awarded assignments are worth points;
+// points held for pending, voided, reversed or deleted assignments are
+// expected to be zero; ledger entries not tied to an assignment (manual
+// adjustments, opening balances) are kept as they are. With apply set, a
+// correction entry is appended for every assignment that is off and
+// Teacher.Point is refreshed. Corrections are booked in the assignment's
+// term, or in the current term if that term has been closed.
+func reconcilePoints(ctx context.Context, apply bool, actor User) ([]ReconcileReport, error) {
+	if !apply {
+		result, _, err := reconcileReports(ctx, actor)
+		return result, err
+	}
+
+	// The ledger is read again inside the transaction so that corrections
+	// are worked out against what it holds when they are written
+	var result []ReconcileReport
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var corrections []LedgerEntry
+		var err error
+		result, corrections, err = reconcileReports(ctx, actor)
+		if err != nil {
+			return err
+		}
+		if err := store.Ledger().Append(ctx, corrections...); err != nil {
+			return err
+		}
+
+		// Refresh the derived totals, including teachers whose only problem
+		// was a stale Teacher.Point
+		for _, report := range result {
+			if !report.InSync() {
+				if err := syncTeacherPoints(ctx, report.TeacherID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// reconcileReports compares every teacher's ledger with their assignments
+// and returns the reports along with the correction entries that would
+// bring them in line
+func reconcileReports(ctx context.Context, actor User) ([]ReconcileReport, []LedgerEntry, error) {
+	teachers, err := store.Teachers().List(ctx, TeacherFilter{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roles, err := store.Roles().List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	rolesByID := make(map[primitive.ObjectID]Role, len(roles))
+	for _, role := range roles {
+		rolesByID[role.ID] = role
+	}
+
+	assignments, err := store.Assignments().List(ctx, AssignmentFilter{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := store.Ledger().List(ctx, LedgerFilter{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events, err := store.Events().List(ctx, EventFilter{}, EventPage{})
+	if err != nil {
+		return nil, nil, err
+	}
+	eventTerms := make(map[primitive.ObjectID]primitive.ObjectID, len(events))
+	for _, event := range events {
+		eventTerms[event.ID] = event.TermID
+	}
+
+	terms, err := store.Terms().List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	openTerms := make(map[primitive.ObjectID]bool, len(terms))
+	for _, term := range terms {
+		openTerms[term.ID] = term.Status == termOpen
+	}
+	fallbackTerm, err := defaultEntryTerm(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Ledger balances per teacher and assignment. Entries without an
+	// assignment are grouped under the zero ObjectID.
+	type balanceKey struct {
+		TeacherID    primitive.ObjectID
+		AssignmentID primitive.ObjectID
+	}
+	type ledgerBalance struct {
+		Key       balanceKey
+		Held      int
+		EventName string
+		RoleName  string
+		TermID    primitive.ObjectID
+	}
+	var balances []*ledgerBalance
+	balanceIndex := make(map[balanceKey]*ledgerBalance)
+	for _, entry := range entries {
+		key := balanceKey{entry.TeacherID, entry.AssignmentID}
+		balance, ok := balanceIndex[key]
+		if !ok {
+			balance = &ledgerBalance{Key: key}
+			balanceIndex[key] = balance
+			balances = append(balances, balance)
+		}
+		balance.Held += entry.Points
+		balance.EventName = entry.EventName
+		balance.RoleName = entry.RoleName
+		balance.TermID = entry.TermID
+	}
+
+	reports := make(map[primitive.ObjectID]*ReconcileReport, len(teachers))
+	for _, teacher := range teachers {
+		reports[teacher.ID] = &ReconcileReport{
+			TeacherID:    teacher.ID,
+			TeacherName:  teacher.Name,
+			StoredPoints: teacher.Point,
+		}
+	}
+
+	assignmentsByID := make(map[primitive.ObjectID]Assignment, len(assignments))
+	lines := make(map[primitive.ObjectID]*ReconcileLine)
+	lineTeacher := make(map[primitive.ObjectID]primitive.ObjectID)
+	for _, assignment := range assignments {
+		assignmentsByID[assignment.ID] = assignment
+		line := &ReconcileLine{
+			AssignmentID: assignment.ID,
+			EventName:    assignment.EventName,
+			RoleName:     assignment.RoletName,
+			termID:       eventTerms[assignment.EventID],
+		}
+		if role, ok := rolesByID[assignment.RoleID]; ok {
+			if assignment.PointsStatus == pointsAwarded {
+				line.Expected = assignment.AwardedPoints
+			}
+			line.RoleName = role.Name
+		}
+		lines[assignment.ID] = line
+		lineTeacher[assignment.ID] = assignment.TeacherID
+	}
+
+	for _, balance := range balances {
+		report, ok := reports[balance.Key.TeacherID]
+		if !ok {
+			continue
+		}
+		report.LedgerBalance += balance.Held
+
+		if balance.Key.AssignmentID.IsZero() {
+			// Manual adjustments are part of the source data
+			report.Expected += balance.Held
+			continue
+		}
+
+		line, ok := lines[balance.Key.AssignmentID]
+		if !ok {
+			line = &ReconcileLine{
+				AssignmentID: balance.Key.AssignmentID,
+				EventName:    balance.EventName,
+				RoleName:     balance.RoleName,
+				Deleted:      true,
+				termID:       balance.TermID,
+			}
+			lines[balance.Key.AssignmentID] = line
+			lineTeacher[balance.Key.AssignmentID] = balance.Key.TeacherID
+		}
+		line.Held += balance.Held
+	}
+
+	var corrections []LedgerEntry
+	for assignmentID, line := range lines {
+		report, ok := reports[lineTeacher[assignmentID]]
+		if !ok {
+			continue
+		}
+		line.Diff = line.Expected - line.Held
+		report.Expected += line.Expected
+		if line.Diff == 0 {
+			continue
+		}
+		report.Lines = append(report.Lines, *line)
+
+		entry := newLedgerEntry(ledgerCorrection, report.TeacherID, line.Diff, actor)
+		entry.EventName = line.EventName
+		entry.RoleName = line.RoleName
+		entry.AssignmentID = assignmentID
+		entry.Reason = "Points reconciliation"
+		entry.TermID = line.termID
+		if !entry.TermID.IsZero() && !openTerms[entry.TermID] {
+			entry.TermID = fallbackTerm
+		}
+		if assignment, ok := assignmentsByID[assignmentID]; ok {
+			entry.EventID = assignment.EventID
+			entry.RoleID = assignment.RoleID
+		}
+		corrections = append(corrections, entry)
+	}
+
+	result := make([]ReconcileReport, 0, len(reports))
+	for _, report := range reports {
+		report.Diff = report.Expected - report.LedgerBalance
+		sort.Slice(report.Lines, func(i, j int) bool {
+			return report.Lines[i].AssignmentID.Hex() < report.Lines[j].AssignmentID.Hex()
+		})
+		result = append(result, *report)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].TeacherName < result[j].TeacherName })
+	return result, corrections, nil
+}
+
+// ReconcilePoints reports per-teacher point differences and, when "commit"
+// is true, applies corrections. It is a dry run by default.
+func ReconcilePoints(c *gin.Context) {
+	type ReconcileRequest struct {
+		Commit bool `json:"commit"`
+		All    bool `json:"all"` // include teachers that are already in sync
+	}
+
+	var req ReconcileRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	reports, err := reconcilePoints(ctx, req.Commit, actor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	outOfSync := 0
+	filtered := make([]ReconcileReport, 0, len(reports))
+	for _, report := range reports {
+		if !report.InSync() {
+			outOfSync++
+		}
+		if req.All || !report.InSync() {
+			filtered = append(filtered, report)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"committed":   req.Commit,
+		"teachers":    len(reports),
+		"out_of_sync": outOfSync,
+		"reports":     filtered,
+	})
+}
+
+// reconcileCommand is the CLI form of ReconcilePoints
+func reconcileCommand(args []string) int {
+	fs := flag.NewFlagSet("reconcile-points", flag.ExitOnError)
+	commit := fs.Bool("commit", false, "apply corrections instead of only reporting them")
+	all := fs.Bool("all", false, "also list teachers that are already in sync")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	reports, err := reconcilePoints(ctx, *commit, User{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reconciliation failed:", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TEACHER\tSTORED\tLEDGER\tEXPECTED\tDIFF")
+	outOfSync := 0
+	for _, report := range reports {
+		if !report.InSync() {
+			outOfSync++
+		} else if !*all {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%+d\n", report.TeacherName, report.StoredPoints, report.LedgerBalance, report.Expected, report.Diff)
+		for _, line := range report.Lines {
+			note := ""
+			if line.Deleted {
+				note = " (deleted)"
+			}
+			fmt.Fprintf(w, "  %s / %s%s\t\t%d\t%d\t%+d\n", line.EventName, line.RoleName, note, line.Held, line.Expected, line.Diff)
+		}
+	}
+	w.Flush()
+
+	if *commit {
+		fmt.Printf("%d of %d teachers corrected\n", outOfSync, len(reports))
+	} else {
+		fmt.Printf("%d of %d teachers out of sync (dry run, use -commit to apply)\n", outOfSync, len(reports))
+	}
+	return 0
+}
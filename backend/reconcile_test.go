@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// reconcileFixture is a memory store holding one teacher whose ledger is
+// off in the ways reconciliation has to repair
+type reconcileFixture struct {
+	teacher    Teacher
+	openTerm   Term
+	closedTerm Term
+	underpaid  Assignment // awarded 10, credited 6, in the open term
+	lateAward  Assignment // awarded 8, never credited, in the closed term
+	deletedID  primitive.ObjectID
+}
+
+func newReconcileFixture(t *testing.T) reconcileFixture {
+	t.Helper()
+	loadConfig()
+	store = newMemoryStore()
+	ctx := context.Background()
+	today := time.Now().In(config.SchoolLocation)
+
+	f := reconcileFixture{
+		teacher: Teacher{ID: primitive.NewObjectID(), Name: "Ada", Point: 11},
+		openTerm: Term{
+			ID:        primitive.NewObjectID(),
+			Name:      "Current",
+			StartDate: today.AddDate(0, -1, 0).Format(termDateLayout),
+			EndDate:   today.AddDate(0, 1, 0).Format(termDateLayout),
+			Status:    termOpen,
+		},
+		closedTerm: Term{
+			ID:        primitive.NewObjectID(),
+			Name:      "Last",
+			StartDate: today.AddDate(0, -6, 0).Format(termDateLayout),
+			EndDate:   today.AddDate(0, -2, 0).Format(termDateLayout),
+			Status:    termClosed,
+		},
+		deletedID: primitive.NewObjectID(),
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(store.Teachers().Create(ctx, f.teacher))
+	must(store.Terms().Create(ctx, f.openTerm))
+	must(store.Terms().Create(ctx, f.closedTerm))
+
+	addAssignment := func(term Term, points int) Assignment {
+		event := Event{ID: primitive.NewObjectID(), Name: "Event in " + term.Name, Status: eventCompleted, TermID: term.ID}
+		role := Role{ID: primitive.NewObjectID(), EventID: event.ID, Name: "Helper", Point: points, HeadCount: 1}
+		assignment := Assignment{
+			ID:            primitive.NewObjectID(),
+			EventID:       event.ID,
+			EventName:     event.Name,
+			TeacherID:     f.teacher.ID,
+			RoleID:        role.ID,
+			RoletName:     role.Name,
+			PointsStatus:  pointsAwarded,
+			AwardedPoints: points,
+		}
+		must(store.Events().Create(ctx, event))
+		must(store.Roles().Create(ctx, role))
+		must(store.Assignments().Create(ctx, assignment))
+		return assignment
+	}
+	f.underpaid = addAssignment(f.openTerm, 10)
+	f.lateAward = addAssignment(f.closedTerm, 8)
+
+	underpaid := newLedgerEntry(ledgerAward, f.teacher.ID, 6, User{})
+	underpaid.AssignmentID = f.underpaid.ID
+	underpaid.TermID = f.openTerm.ID
+	deleted := newLedgerEntry(ledgerAward, f.teacher.ID, 5, User{})
+	deleted.AssignmentID = f.deletedID
+	deleted.TermID = f.openTerm.ID
+	must(store.Ledger().Append(ctx, underpaid, deleted))
+	return f
+}
+
+// ledgerCorrections returns the correction entries in the ledger by assignment
+func ledgerCorrections(t *testing.T) map[primitive.ObjectID]LedgerEntry {
+	t.Helper()
+	entries, err := store.Ledger().List(context.Background(), LedgerFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrections := make(map[primitive.ObjectID]LedgerEntry)
+	for _, entry := range entries {
+		if entry.Type != ledgerCorrection {
+			continue
+		}
+		if _, ok := corrections[entry.AssignmentID]; ok {
+			t.Errorf("more than one correction for assignment %s", entry.AssignmentID.Hex())
+		}
+		corrections[entry.AssignmentID] = entry
+	}
+	return corrections
+}
+
+func TestReconcileDryRunWritesNothing(t *testing.T) {
+	f := newReconcileFixture(t)
+
+	reports, err := reconcilePoints(context.Background(), false, User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Diff != 18-11 {
+		t.Fatalf("reports = %+v, want one teacher off by 7", reports)
+	}
+	if corrections := ledgerCorrections(t); len(corrections) != 0 {
+		t.Errorf("dry run booked %d corrections", len(corrections))
+	}
+	teacher, _ := store.Teachers().Get(context.Background(), f.teacher.ID)
+	if teacher.Point != f.teacher.Point {
+		t.Errorf("dry run changed the stored total to %d", teacher.Point)
+	}
+}
+
+func TestReconcileCommit(t *testing.T) {
+	f := newReconcileFixture(t)
+	ctx := context.Background()
+
+	if _, err := reconcilePoints(ctx, true, User{}); err != nil {
+		t.Fatal(err)
+	}
+	corrections := ledgerCorrections(t)
+	if len(corrections) != 3 {
+		t.Fatalf("got %d corrections, want 3", len(corrections))
+	}
+
+	t.Run("under-credited assignment", func(t *testing.T) {
+		entry := corrections[f.underpaid.ID]
+		if entry.Points != 4 || entry.TermID != f.openTerm.ID {
+			t.Errorf("correction = %+d in term %s, want +4 in the open term", entry.Points, entry.TermID.Hex())
+		}
+	})
+	t.Run("deleted assignment", func(t *testing.T) {
+		entry := corrections[f.deletedID]
+		if entry.Points != -5 || entry.TermID != f.openTerm.ID {
+			t.Errorf("correction = %+d in term %s, want -5 in the open term", entry.Points, entry.TermID.Hex())
+		}
+	})
+	t.Run("closed term falls back to the current term", func(t *testing.T) {
+		want, err := defaultEntryTerm(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := corrections[f.lateAward.ID]
+		if entry.Points != 8 || entry.TermID != want {
+			t.Errorf("correction = %+d in term %s, want +8 in term %s", entry.Points, entry.TermID.Hex(), want.Hex())
+		}
+	})
+	t.Run("stored total", func(t *testing.T) {
+		teacher, err := store.Teachers().Get(ctx, f.teacher.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if teacher.Point != 18 {
+			t.Errorf("teacher has %d points, want 18", teacher.Point)
+		}
+	})
+
+	t.Run("second run", func(t *testing.T) {
+		before, err := store.Ledger().List(ctx, LedgerFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reports, err := reconcilePoints(ctx, true, User{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, report := range reports {
+			if !report.InSync() {
+				t.Errorf("teacher %s still out of sync: %+v", report.TeacherName, report)
+			}
+		}
+		after, err := store.Ledger().List(ctx, LedgerFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(after) != len(before) {
+			t.Errorf("second run added %d entries", len(after)-len(before))
+		}
+	})
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Policies for points already awarded when a role's point value changes
+const (
+	repriceKeep   = "keep"   // awards stay at the value they were made at
+	repriceAdjust = "adjust" // awards are corrected to the new value
+)
+
+// RoleUpdateRequest is the body accepted when updating a role. Point and
+// HeadCount are left unchanged when omitted. Lowering HeadCount below the
+// number of assigned teachers needs DropAssignmentIDs to name the
+// assignments to remove.
+type RoleUpdateRequest struct {
+	Name              string               `json:"name" binding:"required"`
+	Point             *int                 `json:"point"`
+	HeadCount         *int                 `json:"head_count"`
+	DropAssignmentIDs []primitive.ObjectID `json:"drop_assignment_ids"`
+	// Reprice overrides REPRICE_POLICY for this update
+	Reprice string `json:"reprice"`
+}
+
+// correctionTerm is the term re-pricing corrections for an event are booked
+// in: the event's term while it is open, otherwise the current term
+func correctionTerm(ctx context.Context, event Event) (primitive.ObjectID, error) {
+	if event.TermID.IsZero() {
+		return event.TermID, nil
+	}
+	term, err := store.Terms().Get(ctx, event.TermID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return primitive.NilObjectID, err
+	}
+	if err == nil && term.Status == termOpen {
+		return term.ID, nil
+	}
+	return defaultEntryTerm(ctx)
+}
+
+// dropAssignment removes an assignment from a role that is being shrunk or
+// deleted, taking back any points the teacher holds for it
+func dropAssignment(ctx context.Context, event *Event, assignment Assignment, actor User, reason string) error {
+	deduction, ok, err := assignmentDeduction(ctx, assignment, actor, reason)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := recordLedgerEntries(ctx, deduction); err != nil {
+			return err
+		}
+	}
+	removeAssignmentRef(event, assignment.ID)
+	return store.Assignments().Delete(ctx, assignment.ID)
+}
+
+// roleIDParam parses the id path parameter. On failure the error response
+// has been written.
+func roleIDParam(c *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID format"})
+		return id, false
+	}
+	return id, true
+}
+
+// UpdateRole renames a role or changes its points or head count, keeping the
+// event and assignments in step. The head count cannot drop below the
+// assigned teachers unless drop_assignment_ids names who to remove; raising
+// it promotes teachers from the waitlist. When the point value changes,
+// points already awarded are kept or corrected to the new value according
+// to REPRICE_POLICY or the request's reprice field. Completed events accept
+// name and point changes only; cancelled events accept none.
+func UpdateRole(c *gin.Context) {
+	id, ok := roleIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req RoleUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Point != nil && *req.Point < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "point cannot be negative"})
+		return
+	}
+	if req.HeadCount != nil && *req.HeadCount < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "head_count must be at least 1"})
+		return
+	}
+	policy := req.Reprice
+	if policy == "" {
+		policy = config.RepricePolicy
+	}
+	if policy != repriceKeep && policy != repriceAdjust {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reprice must be keep or adjust"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	var role Role
+	var dropped, repriced int
+	var promoted []Assignment
+
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		dropped, repriced, promoted = 0, 0, nil
+		var err error
+		role, err = store.Roles().GetForUpdate(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Role not found"}
+		}
+		if err != nil {
+			return err
+		}
+		event, err := store.Events().Get(ctx, role.EventID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Event not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if event.Status == eventCancelled {
+			return &requestError{http.StatusConflict, "Event is cancelled and its roles can no longer be changed"}
+		}
+		headCount := role.HeadCount
+		if req.HeadCount != nil {
+			headCount = *req.HeadCount
+		}
+		if event.Status == eventCompleted && headCount != role.HeadCount {
+			return &requestError{http.StatusConflict, "The head count of a completed event's role can no longer be changed"}
+		}
+
+		assignments, err := store.Assignments().List(ctx, AssignmentFilter{RoleID: role.ID})
+		if err != nil {
+			return err
+		}
+
+		// Check the whole drop list before removing anyone
+		excess := len(assignments) - headCount
+		if excess <= 0 && len(req.DropAssignmentIDs) > 0 {
+			return &requestError{http.StatusBadRequest, "drop_assignment_ids is only accepted when the head count is lowered below the assigned teachers"}
+		}
+		drop := make(map[primitive.ObjectID]bool, len(req.DropAssignmentIDs))
+		for _, assignmentID := range req.DropAssignmentIDs {
+			drop[assignmentID] = true
+		}
+		if excess > 0 && len(drop) != excess {
+			return &requestError{http.StatusConflict, fmt.Sprintf(
+				"%d teachers are assigned; name %d of their assignments in drop_assignment_ids", len(assignments), excess)}
+		}
+		var kept, dropping []Assignment
+		for _, assignment := range assignments {
+			if drop[assignment.ID] {
+				dropping = append(dropping, assignment)
+			} else {
+				kept = append(kept, assignment)
+			}
+		}
+		if len(dropping) != len(drop) {
+			return &requestError{http.StatusBadRequest, "drop_assignment_ids must name assignments of this role"}
+		}
+
+		for _, assignment := range dropping {
+			if err := dropAssignment(ctx, &event, assignment, actor, "Dropped when the role's head count was lowered"); err != nil {
+				return err
+			}
+		}
+		dropped = len(dropping)
+
+		oldPoint, oldHeadCount := role.Point, role.HeadCount
+		role.Name = req.Name
+		if req.Point != nil {
+			role.Point = *req.Point
+		}
+		role.HeadCount = headCount
+		if err := store.Roles().Update(ctx, role); err != nil {
+			return err
+		}
+
+		// Only a change of point value re-prices earlier awards
+		adjust := policy == repriceAdjust && role.Point != oldPoint
+		termID := event.TermID
+		if adjust {
+			if termID, err = correctionTerm(ctx, event); err != nil {
+				return err
+			}
+		}
+		var corrections []LedgerEntry
+		names := make(map[primitive.ObjectID]bool, len(kept))
+		for _, assignment := range kept {
+			names[assignment.ID] = true
+			assignment.RoletName = role.Name
+			if adjust && assignment.PointsStatus == pointsAwarded && assignment.AwardedPoints != role.Point {
+				entry := newLedgerEntry(ledgerCorrection, assignment.TeacherID, role.Point-assignment.AwardedPoints, actor)
+				entry.TermID = termID
+				entry.EventID = event.ID
+				entry.EventName = event.Name
+				entry.RoleID = role.ID
+				entry.RoleName = role.Name
+				entry.AssignmentID = assignment.ID
+				entry.Reason = fmt.Sprintf("Role re-priced from %d to %d points", assignment.AwardedPoints, role.Point)
+				corrections = append(corrections, entry)
+				assignment.AwardedPoints = role.Point
+			}
+			if err := store.Assignments().Update(ctx, assignment); err != nil {
+				return err
+			}
+		}
+		if err := recordLedgerEntries(ctx, corrections...); err != nil {
+			return err
+		}
+		repriced = len(corrections)
+
+		for i := range event.Roles {
+			if event.Roles[i].ID == role.ID {
+				event.Roles[i].Name = role.Name
+			}
+		}
+		for i := range event.Assginedteachers {
+			if names[event.Assginedteachers[i].Assignment_ID] {
+				event.Assginedteachers[i].RoleName = role.Name
+			}
+		}
+		if err := store.Events().Update(ctx, event); err != nil {
+			return err
+		}
+
+		if role.HeadCount > oldHeadCount {
+			promoted, err = promoteWaitlist(ctx, role.ID)
+		}
+		return err
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to update role")
+		return
+	}
+	if promoted == nil {
+		promoted = []Assignment{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"role":     role,
+		"dropped":  dropped,
+		"repriced": repriced,
+		"promoted": promoted,
+	})
+}
+
+// DeleteRole removes a role from an event that is not completed or
+// cancelled. A role with assigned teachers is only deleted with
+// ?drop_assignments=true, which removes the assignments and takes back any
+// points held for them. The role's waitlist goes with it and its pending
+// volunteer requests are rejected.
+func DeleteRole(c *gin.Context) {
+	id, ok := roleIDParam(c)
+	if !ok {
+		return
+	}
+	dropAssignments := c.Query("drop_assignments") == "true"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	var role Role
+	var dropped int
+
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		dropped = 0
+		var err error
+		role, err = store.Roles().GetForUpdate(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Role not found"}
+		}
+		if err != nil {
+			return err
+		}
+		event, err := store.Events().Get(ctx, role.EventID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Event not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if err := requireEventOpen(event); err != nil {
+			return err
+		}
+
+		assignments, err := store.Assignments().List(ctx, AssignmentFilter{RoleID: role.ID})
+		if err != nil {
+			return err
+		}
+		if len(assignments) > 0 && !dropAssignments {
+			return &requestError{http.StatusConflict, fmt.Sprintf(
+				"Role has %d assigned teachers; pass drop_assignments=true to remove them with it", len(assignments))}
+		}
+		for _, assignment := range assignments {
+			if err := dropAssignment(ctx, &event, assignment, actor, "Role deleted"); err != nil {
+				return err
+			}
+			dropped++
+		}
+
+		entries, err := store.Waitlist().List(ctx, WaitlistFilter{RoleID: role.ID})
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := store.Waitlist().Delete(ctx, entry.ID); err != nil {
+				return err
+			}
+		}
+
+		requests, err := store.VolunteerRequests().List(ctx, VolunteerRequestFilter{RoleID: role.ID, Status: requestPending})
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, request := range requests {
+			request.Status = requestRejected
+			request.DecidedAt = &now
+			request.DecidedBy = actor.ID
+			request.Reason = "Role deleted"
+			if err := store.VolunteerRequests().Update(ctx, request); err != nil {
+				return err
+			}
+		}
+
+		var refs []RoleRef
+		for _, ref := range event.Roles {
+			if ref.ID != role.ID {
+				refs = append(refs, ref)
+			}
+		}
+		event.Roles = refs
+		if err := store.Events().Update(ctx, event); err != nil {
+			return err
+		}
+		return store.Roles().Delete(ctx, role.ID)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to delete role")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role deleted successfully",
+		"role":    role,
+		"dropped": dropped,
+	})
+}
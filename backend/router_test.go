@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "Correct horse 1"
+
+// newTestRouter returns the router over a fresh memory store holding a
+// verified account for each of the given emails and roles
+func newTestRouter(t *testing.T, accounts map[string]string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	loadConfig()
+	store = newMemoryStore()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for email, role := range accounts {
+		err := store.Users().Create(context.Background(), User{
+			ID:              primitive.NewObjectID(),
+			Name:            email,
+			Email:           email,
+			Password:        string(hash),
+			Role:            role,
+			EmailVerifiedAt: &now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newRouter()
+}
+
+// serve sends a JSON request through the router
+func serve(r *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// login returns an access token for an account made by newTestRouter
+func login(t *testing.T, r *gin.Engine, email string) string {
+	t.Helper()
+	w := serve(r, http.MethodPost, "/login", "", gin.H{"email": email, "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login as %s: %d %s", email, w.Code, w.Body)
+	}
+	var resp LoginResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.AccessToken == "" {
+		t.Fatalf("login as %s returned no token: %s", email, w.Body)
+	}
+	return resp.AccessToken
+}
+
+func TestLoginThenAuthenticatedRequest(t *testing.T) {
+	r := newTestRouter(t, map[string]string{"admin@school.test": userRoleAdmin})
+	token := login(t, r, "admin@school.test")
+
+	w := serve(r, http.MethodGet, "/me", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /me: %d %s", w.Code, w.Body)
+	}
+	var me User
+	if err := json.Unmarshal(w.Body.Bytes(), &me); err != nil {
+		t.Fatal(err)
+	}
+	if me.Email != "admin@school.test" {
+		t.Errorf("GET /me returned %q, want admin@school.test", me.Email)
+	}
+
+	if w := serve(r, http.MethodGet, "/users", token, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /users as admin: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRequestWithoutTokenIsUnauthorized(t *testing.T) {
+	r := newTestRouter(t, nil)
+
+	for _, token := range []string{"", "not-a-token"} {
+		if w := serve(r, http.MethodGet, "/events", token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET /events with token %q: %d, want 401", token, w.Code)
+		}
+	}
+}
+
+func TestWrongRoleIsForbidden(t *testing.T) {
+	r := newTestRouter(t, map[string]string{"teacher@school.test": userRoleTeacher})
+	token := login(t, r, "teacher@school.test")
+
+	if w := serve(r, http.MethodGet, "/events", token, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /events as teacher: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodGet, "/users", token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("GET /users as teacher: %d, want 403", w.Code)
+	}
+	if w := serve(r, http.MethodPost, "/events", token, gin.H{"name": "Open day"}); w.Code != http.StatusForbidden {
+		t.Errorf("POST /events as teacher: %d, want 403", w.Code)
+	}
+}
+
+func TestUpdateTeacherEmail(t *testing.T) {
+	r := newTestRouter(t, map[string]string{"admin@school.test": userRoleAdmin})
+	token := login(t, r, "admin@school.test")
+	teacher := Teacher{ID: primitive.NewObjectID(), Name: "Ada", Email: "ada@school.test"}
+	if err := store.Teachers().Create(context.Background(), teacher); err != nil {
+		t.Fatal(err)
+	}
+	path := "/teachers/" + teacher.ID.Hex()
+
+	for _, email := range []string{"", "  ", "not an email", "Ada <ada@school.test>"} {
+		if w := serve(r, http.MethodPut, path, token, gin.H{"name": "Ada", "email": email}); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT with email %q: %d, want 400", email, w.Code)
+		}
+	}
+
+	if w := serve(r, http.MethodPut, path, token, gin.H{"name": "Ada", "email": " Ada.L@School.test"}); w.Code != http.StatusOK {
+		t.Fatalf("PUT with a valid email: %d %s", w.Code, w.Body)
+	}
+	teacher, err := store.Teachers().Get(context.Background(), teacher.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if teacher.Email != "ada.l@school.test" {
+		t.Errorf("stored email is %q, want ada.l@school.test", teacher.Email)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Formats of the date and time strings on an Event
+const (
+	eventDateLayout = "2006-01-02"
+	eventTimeLayout = "15:04"
+)
+
+// maxEventDuration is the longest an event may run
+const maxEventDuration = 31 * 24 * time.Hour
+
+// parseEventDate parses a YYYY-MM-DD date as midnight in the school timezone
+func parseEventDate(value string) (time.Time, bool) {
+	t, err := time.ParseInLocation(eventDateLayout, value, config.SchoolLocation)
+	return t, err == nil
+}
+
+// parseEventClock parses an HH:MM or HH:MM:SS time of day
+func parseEventClock(value string) (time.Time, bool) {
+	for _, layout := range []string{eventTimeLayout, "15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// atClock returns the instant a time of day falls on a date. Building it from
+// the wall clock fields lets time.Date resolve daylight saving transitions.
+func atClock(day, clock time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, day.Location())
+}
+
+// scheduleFromStrings computes an event's instants from its date and time
+// strings. Without times the event lasts whole days; an empty end date means
+// the event ends on the day it starts.
+func scheduleFromStrings(event *Event) error {
+	start, ok := parseEventDate(event.StartDate)
+	if !ok {
+		return &requestError{http.StatusBadRequest, "start_date must be a date in YYYY-MM-DD format"}
+	}
+	end := start
+	if event.EndDate != "" {
+		if end, ok = parseEventDate(event.EndDate); !ok {
+			return &requestError{http.StatusBadRequest, "end_date must be a date in YYYY-MM-DD format"}
+		}
+	}
+
+	if event.StartTime == "" && event.EndTime == "" {
+		event.AllDay = true
+		event.StartsAt = start
+		event.EndsAt = time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, end.Location())
+		return nil
+	}
+	if event.StartTime == "" || event.EndTime == "" {
+		return &requestError{http.StatusBadRequest, "start_time and end_time must both be set, or both be empty for an all-day event"}
+	}
+
+	startClock, ok := parseEventClock(event.StartTime)
+	if !ok {
+		return &requestError{http.StatusBadRequest, "start_time must be a time in HH:MM format"}
+	}
+	endClock, ok := parseEventClock(event.EndTime)
+	if !ok {
+		return &requestError{http.StatusBadRequest, "end_time must be a time in HH:MM format"}
+	}
+	event.AllDay = false
+	event.StartsAt = atClock(start, startClock)
+	event.EndsAt = atClock(end, endClock)
+	return nil
+}
+
+// formatEventStrings sets an event's date and time strings from its instants
+func formatEventStrings(event *Event) {
+	start := event.StartsAt.In(config.SchoolLocation)
+	end := event.EndsAt.In(config.SchoolLocation)
+	event.StartDate = start.Format(eventDateLayout)
+	if event.AllDay {
+		// The end instant is midnight after the last day
+		event.EndDate = end.AddDate(0, 0, -1).Format(eventDateLayout)
+		event.StartTime = ""
+		event.EndTime = ""
+		return
+	}
+	event.EndDate = end.Format(eventDateLayout)
+	event.StartTime = start.Format(eventTimeLayout)
+	event.EndTime = end.Format(eventTimeLayout)
+}
+
+// normalizeEventSchedule validates the schedule in a create or update
+// request. Clients may send starts_at and ends_at, or the four date and time
+// strings as before; either way both forms are filled in.
+func normalizeEventSchedule(event *Event) error {
+	if event.StartsAt.IsZero() != event.EndsAt.IsZero() {
+		return &requestError{http.StatusBadRequest, "starts_at and ends_at must be sent together"}
+	}
+	if event.StartsAt.IsZero() {
+		if err := scheduleFromStrings(event); err != nil {
+			return err
+		}
+	} else {
+		event.AllDay = false
+	}
+
+	if !event.EndsAt.After(event.StartsAt) {
+		return &requestError{http.StatusBadRequest, "An event must end after it starts"}
+	}
+	if event.EndsAt.Sub(event.StartsAt) > maxEventDuration {
+		return &requestError{http.StatusBadRequest, "An event cannot last longer than 31 days"}
+	}
+
+	event.StartsAt = event.StartsAt.UTC()
+	event.EndsAt = event.EndsAt.UTC()
+	formatEventStrings(event)
+	return nil
+}
+
+// eventDay is the calendar day an event starts on in the school timezone
+func eventDay(event Event) string {
+	return event.StartsAt.In(config.SchoolLocation).Format(eventDateLayout)
+}
+
+// migrateEventSchedules fills in StartsAt and EndsAt for events saved with
+// only the date and time strings. The duration limit is not applied to
+// existing events; events whose strings cannot be parsed or that end before
+// they start are logged and left unscheduled.
+func migrateEventSchedules(ctx context.Context, s Store) error {
+	events, err := s.Events().List(ctx, EventFilter{}, EventPage{})
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if !event.StartsAt.IsZero() {
+			continue
+		}
+		err := scheduleFromStrings(&event)
+		if err == nil && !event.EndsAt.After(event.StartsAt) {
+			err = errors.New("it ends before it starts")
+		}
+		if err != nil {
+			log.Printf("event %s (%q) has no valid schedule: %v", event.ID.Hex(), event.Name, err)
+			continue
+		}
+
+		event.StartsAt = event.StartsAt.UTC()
+		event.EndsAt = event.EndsAt.UTC()
+		if err := s.Events().Update(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
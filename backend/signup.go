@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OpenRole is a role with places left, as shown to teachers looking for
+// roles to sign up for
+type OpenRole struct {
+	Role
+	Assigned         int  `json:"assigned"`
+	Open             int  `json:"open"`
+	SignedUp         bool `json:"signed_up"`
+	RequiresApproval bool `json:"requires_approval"`
+	// Requested is set while the caller's request for the role awaits approval
+	Requested bool `json:"requested"`
+}
+
+// OpenEvent is an upcoming published event and its roles with places left
+type OpenEvent struct {
+	Event
+	OpenRoles []OpenRole `json:"open_roles"`
+}
+
+// callerTeacher returns the teacher record of the calling user. On failure
+// the error response has been written.
+func callerTeacher(ctx context.Context, c *gin.Context) (Teacher, bool) {
+	user, _ := currentUser(c)
+	teacher, err := findTeacherForUser(ctx, user)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account is not linked to a teacher"})
+		return Teacher{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return Teacher{}, false
+	}
+	return teacher, true
+}
+
+// ListOpenRoles lists upcoming published events, soonest first, with the
+// roles that still have places. Roles the caller already holds are included
+// and flagged so they can withdraw. term_id and department_id narrow the
+// events as in ListEvents.
+func ListOpenRoles(c *gin.Context) {
+	termID, err := queryID(c, "term_id")
+	if err != nil {
+		respondTransactionError(c, err, "Invalid term ID")
+		return
+	}
+	departmentID, err := queryID(c, "department_id")
+	if err != nil {
+		respondTransactionError(c, err, "Invalid department ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	teacher, ok := callerTeacher(ctx, c)
+	if !ok {
+		return
+	}
+
+	events, err := store.Events().List(ctx, EventFilter{
+		TermID:       termID,
+		DepartmentID: departmentID,
+		Status:       eventPublished,
+		StartsFrom:   time.Now(),
+	}, EventPage{Sort: eventSortStart})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	pending, err := store.VolunteerRequests().List(ctx, VolunteerRequestFilter{TeacherID: teacher.ID, Status: requestPending})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	requested := make(map[primitive.ObjectID]bool, len(pending))
+	for _, request := range pending {
+		requested[request.RoleID] = true
+	}
+
+	result := []OpenEvent{}
+	for _, event := range events {
+		roles, err := store.Roles().ListByEvent(ctx, event.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		assignments, err := store.Assignments().List(ctx, AssignmentFilter{EventID: event.ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		assigned := make(map[primitive.ObjectID]int, len(roles))
+		signedUp := make(map[primitive.ObjectID]bool)
+		for _, assignment := range assignments {
+			assigned[assignment.RoleID]++
+			if assignment.TeacherID == teacher.ID {
+				signedUp[assignment.RoleID] = true
+			}
+		}
+
+		open := OpenEvent{Event: event, OpenRoles: []OpenRole{}}
+		for _, role := range roles {
+			places := role.HeadCount - assigned[role.ID]
+			if places <= 0 && !signedUp[role.ID] {
+				continue
+			}
+			open.OpenRoles = append(open.OpenRoles, OpenRole{
+				Role:             role,
+				Assigned:         assigned[role.ID],
+				Open:             max(places, 0),
+				SignedUp:         signedUp[role.ID],
+				RequiresApproval: needsApproval(role),
+				Requested:        requested[role.ID],
+			})
+		}
+		if len(open.OpenRoles) > 0 {
+			result = append(result, open)
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// SignUpForRole puts the calling teacher on a role of a published event that
+// has not started, with the same checks as AssignTeacherToRole. If the role
+// is full the teacher joins its waitlist. Sign-ups for roles that need
+// approval are recorded as pending volunteer requests instead.
+func SignUpForRole(c *gin.Context) {
+	type SignupRequest struct {
+		RoleID string `json:"role_id" binding:"required"`
+	}
+
+	var req SignupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	roleID, err := primitive.ObjectIDFromHex(req.RoleID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	teacher, ok := callerTeacher(ctx, c)
+	if !ok {
+		return
+	}
+
+	var assignment Assignment
+	var entry WaitlistEntry
+	var request VolunteerRequest
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		entry = WaitlistEntry{}
+		request = VolunteerRequest{}
+		role, err := store.Roles().Get(ctx, roleID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Role not found"}
+		}
+		if err != nil {
+			return err
+		}
+		event, err := store.Events().Get(ctx, role.EventID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Event not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if !event.StartsAt.IsZero() && !time.Now().Before(event.StartsAt) {
+			return &requestError{http.StatusConflict, "Sign-up closes when the event starts"}
+		}
+		if needsApproval(role) {
+			request, err = requestVolunteer(ctx, event, role, teacher)
+			return err
+		}
+
+		assignment, err = assignTeacher(ctx, teacher.ID, role.ID, event.ID, true)
+		if errors.Is(err, errRoleFull) {
+			entry, err = joinWaitlist(ctx, role.ID, event.ID, teacher.ID, true)
+		}
+		return err
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to sign up")
+		return
+	}
+	if !request.ID.IsZero() {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "Your sign-up is waiting for approval",
+			"request": request,
+		})
+		return
+	}
+	if !entry.ID.IsZero() {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":        "Role is full; you have been added to the waitlist",
+			"waitlist_entry": entry,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Signed up for role successfully",
+		"assignment": assignment,
+	})
+}
+
+// WithdrawFromRole removes an assignment the calling teacher signed up for.
+// Teachers can withdraw until WITHDRAW_CUTOFF before the event starts; after
+// that, or for assignments made by an admin, an admin must remove them. The
+// freed place goes to the next teacher on the role's waitlist.
+func WithdrawFromRole(c *gin.Context) {
+	assignmentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assignment ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	teacher, ok := callerTeacher(ctx, c)
+	if !ok {
+		return
+	}
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		assignment, err := store.Assignments().Get(ctx, assignmentID)
+		if errors.Is(err, ErrNotFound) || (err == nil && assignment.TeacherID != teacher.ID) {
+			return &requestError{http.StatusNotFound, "Assignment not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if !assignment.SelfSignup {
+			return &requestError{http.StatusForbidden, "Only roles you signed up for yourself can be withdrawn; ask an admin"}
+		}
+
+		event, err := store.Events().Get(ctx, assignment.EventID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Event not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if event.Status != eventPublished {
+			return &requestError{http.StatusConflict, "Event is " + event.Status + "; withdrawals are closed"}
+		}
+		if !event.StartsAt.IsZero() && time.Now().After(event.StartsAt.Add(-config.WithdrawCutoff)) {
+			return &requestError{http.StatusConflict, "Withdrawals close " + config.WithdrawCutoff.String() + " before the event starts"}
+		}
+
+		removeAssignmentRef(&event, assignment.ID)
+		if err := store.Events().Update(ctx, event); err != nil {
+			return err
+		}
+		if err := store.Assignments().Delete(ctx, assignment.ID); err != nil {
+			return err
+		}
+		_, err = promoteWaitlist(ctx, assignment.RoleID)
+		return err
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to withdraw")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Withdrawn from role successfully"})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Errors returned by every Store implementation
+var (
+	ErrNotFound  = errors.New("record not found")
+	ErrDuplicate = errors.New("duplicate record")
+)
+
+// Store gives handlers access to persistent data without tying them to a
+// particular database
+type Store interface {
+	Users() UserRepository
+	Teachers() TeacherRepository
+	Events() EventRepository
+	Roles() RoleRepository
+	Assignments() AssignmentRepository
+	Departments() DepartmentRepository
+	Ledger() LedgerRepository
+	Terms() TermRepository
+	Sessions() SessionRepository
+	Waitlist() WaitlistRepository
+	VolunteerRequests() VolunteerRequestRepository
+	UserTokens() UserTokenRepository
+	LoginFailures() LoginFailureRepository
+
+	// RunInTransaction runs fn atomically. Repository calls made with the
+	// context passed to fn take part in the transaction. fn may be retried,
+	// so it must be safe to run more than once.
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// Migrate prepares the database (indexes, schema, legacy data)
+	Migrate(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// store is the Store used by the handlers
+var store Store
+
+// UserRepository stores login accounts. GetByEmail normalizes the email it
+// is given; stored emails are always normalized.
+type UserRepository interface {
+	Create(ctx context.Context, user User) error
+	Get(ctx context.Context, id primitive.ObjectID) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	List(ctx context.Context) ([]User, error)
+	CountByRole(ctx context.Context, role string) (int, error)
+	Update(ctx context.Context, user User) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// TeacherFilter selects teachers. Zero fields match everything.
+type TeacherFilter struct {
+	DepartmentID primitive.ObjectID
+	Active       bool // only teachers that have not been deactivated
+}
+
+// TeacherRepository stores teachers. GetByEmail normalizes the email it is
+// given, as UserRepository does.
+type TeacherRepository interface {
+	Create(ctx context.Context, teacher Teacher) error
+	Get(ctx context.Context, id primitive.ObjectID) (Teacher, error)
+	GetByEmail(ctx context.Context, email string) (Teacher, error)
+	List(ctx context.Context, filter TeacherFilter) ([]Teacher, error)
+	Update(ctx context.Context, teacher Teacher) error
+	SetPoints(ctx context.Context, id primitive.ObjectID, points int) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// EventFilter selects events. Zero fields match everything. Events without
+// a schedule never match a time bound.
+type EventFilter struct {
+	TermID       primitive.ObjectID
+	DepartmentID primitive.ObjectID
+	Status       string
+	// Search matches a case-insensitive substring of the name or description
+	Search       string
+	StartsFrom   time.Time // starts_at >= StartsFrom
+	StartsBefore time.Time // starts_at < StartsBefore
+	EndsAfter    time.Time // ends_at > EndsAfter
+	EndsBy       time.Time // ends_at <= EndsBy
+	// Scheduled leaves out events without a schedule
+	Scheduled bool
+}
+
+// Event sort keys
+const (
+	eventSortCreated = "created"
+	eventSortStart   = "starts_at"
+	eventSortName    = "name"
+)
+
+// EventPage orders and limits an event listing. Ties on the sort key are
+// broken by ID. After continues from the last event of a previous page.
+type EventPage struct {
+	Sort       string
+	Descending bool
+	After      *Event
+	Limit      int // zero means no limit
+}
+
+// EventRepository stores events
+type EventRepository interface {
+	Create(ctx context.Context, event Event) error
+	Get(ctx context.Context, id primitive.ObjectID) (Event, error)
+	List(ctx context.Context, filter EventFilter, page EventPage) ([]Event, error)
+	Count(ctx context.Context, filter EventFilter) (int, error)
+	Update(ctx context.Context, event Event) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// RoleRepository stores the roles offered by events
+type RoleRepository interface {
+	Create(ctx context.Context, role Role) error
+	Get(ctx context.Context, id primitive.ObjectID) (Role, error)
+	// GetForUpdate loads a role and locks it until the surrounding
+	// transaction ends, serializing assignments to the role
+	GetForUpdate(ctx context.Context, id primitive.ObjectID) (Role, error)
+	List(ctx context.Context) ([]Role, error)
+	ListByEvent(ctx context.Context, eventID primitive.ObjectID) ([]Role, error)
+	Update(ctx context.Context, role Role) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// AssignmentFilter selects assignments. Zero fields match everything.
+type AssignmentFilter struct {
+	TeacherID  primitive.ObjectID
+	RoleID     primitive.ObjectID
+	EventID    primitive.ObjectID
+	Attendance string
+}
+
+// AssignmentRepository stores teacher role assignments
+type AssignmentRepository interface {
+	Create(ctx context.Context, assignment Assignment) error
+	Get(ctx context.Context, id primitive.ObjectID) (Assignment, error)
+	List(ctx context.Context, filter AssignmentFilter) ([]Assignment, error)
+	Count(ctx context.Context, filter AssignmentFilter) (int, error)
+	Update(ctx context.Context, assignment Assignment) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// DepartmentRepository stores departments. Names are unique; Create and
+// Update return ErrDuplicate for a name that is already taken.
+type DepartmentRepository interface {
+	Create(ctx context.Context, department Department) error
+	Get(ctx context.Context, id primitive.ObjectID) (Department, error)
+	GetByName(ctx context.Context, name string) (Department, error)
+	List(ctx context.Context) ([]Department, error)
+	Update(ctx context.Context, department Department) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// LedgerFilter selects ledger entries. Zero fields match everything.
+type LedgerFilter struct {
+	TeacherID    primitive.ObjectID
+	AssignmentID primitive.ObjectID
+	TermID       primitive.ObjectID
+}
+
+// LedgerRepository is the append-only points ledger
+type LedgerRepository interface {
+	// Append returns ErrDuplicate if an entry reverses one that was
+	// already reversed
+	Append(ctx context.Context, entries ...LedgerEntry) error
+	Get(ctx context.Context, id primitive.ObjectID) (LedgerEntry, error)
+	// List returns entries oldest first
+	List(ctx context.Context, filter LedgerFilter) ([]LedgerEntry, error)
+	Sum(ctx context.Context, filter LedgerFilter) (int, error)
+	// Balances returns the balance of every teacher with matching entries
+	Balances(ctx context.Context, filter LedgerFilter) (map[primitive.ObjectID]int, error)
+}
+
+// TermRepository stores academic terms and the standings archived when a
+// term is closed
+type TermRepository interface {
+	Create(ctx context.Context, term Term) error
+	Get(ctx context.Context, id primitive.ObjectID) (Term, error)
+	// List returns terms ordered by start date
+	List(ctx context.Context) ([]Term, error)
+	Update(ctx context.Context, term Term) error
+	// ArchiveStandings stores a term's final standings. Archived standings
+	// cannot be replaced; a second call for the same term returns
+	// ErrDuplicate.
+	ArchiveStandings(ctx context.Context, termID primitive.ObjectID, standings []TermStanding) error
+	// Standings returns a term's archived standings by rank
+	Standings(ctx context.Context, termID primitive.ObjectID) ([]TermStanding, error)
+}
+
+// SessionRepository stores login sessions
+type SessionRepository interface {
+	Create(ctx context.Context, session Session) error
+	// GetActive returns a session that is neither revoked nor expired
+	GetActive(ctx context.Context, id primitive.ObjectID) (Session, error)
+	// RotateRefresh swaps the current refresh token ID, returning false if
+	// it no longer matches oldRefreshID
+	RotateRefresh(ctx context.Context, id primitive.ObjectID, oldRefreshID, newRefreshID string) (bool, error)
+	Revoke(ctx context.Context, id primitive.ObjectID) error
+	RevokeAllForUser(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// UserTokenRepository stores the tokens mailed for email verification and
+// password resets
+type UserTokenRepository interface {
+	Create(ctx context.Context, token UserToken) error
+	// GetActive returns the unused, unexpired token with a purpose and hash
+	GetActive(ctx context.Context, purpose, hash string) (UserToken, error)
+	// Use marks a token used, returning false if it already was
+	Use(ctx context.Context, id primitive.ObjectID) (bool, error)
+	// UseAllForUser marks every unused token a user has for a purpose used
+	UseAllForUser(ctx context.Context, userID primitive.ObjectID, purpose string) error
+}
+
+// LoginFailureFilter selects login failures. Zero fields match everything.
+type LoginFailureFilter struct {
+	Email     string
+	IP        string
+	Reasons   []string
+	Since     time.Time // created_at >= Since
+	Uncleared bool
+}
+
+// LoginFailureRepository records failed logins
+type LoginFailureRepository interface {
+	Create(ctx context.Context, failure LoginFailure) error
+	// List returns failures oldest first
+	List(ctx context.Context, filter LoginFailureFilter) ([]LoginFailure, error)
+	// Clear marks an email's uncleared failures cleared
+	Clear(ctx context.Context, email string) error
+}
+
+// WaitlistFilter selects waitlist entries. Zero fields match everything.
+type WaitlistFilter struct {
+	RoleID    primitive.ObjectID
+	EventID   primitive.ObjectID
+	TeacherID primitive.ObjectID
+}
+
+// WaitlistRepository stores the teachers waiting for places on full roles.
+// A teacher can wait only once per role; Create returns ErrDuplicate
+// otherwise.
+type WaitlistRepository interface {
+	Create(ctx context.Context, entry WaitlistEntry) error
+	Get(ctx context.Context, id primitive.ObjectID) (WaitlistEntry, error)
+	// List returns entries by position
+	List(ctx context.Context, filter WaitlistFilter) ([]WaitlistEntry, error)
+	Update(ctx context.Context, entry WaitlistEntry) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// VolunteerRequestFilter selects volunteer requests. Zero fields match
+// everything; Decided matches approved and rejected requests.
+type VolunteerRequestFilter struct {
+	EventID   primitive.ObjectID
+	RoleID    primitive.ObjectID
+	TeacherID primitive.ObjectID
+	Status    string
+	Decided   bool
+}
+
+// VolunteerRequestRepository stores sign-ups waiting for or given an
+// admin's decision
+type VolunteerRequestRepository interface {
+	Create(ctx context.Context, request VolunteerRequest) error
+	Get(ctx context.Context, id primitive.ObjectID) (VolunteerRequest, error)
+	// List returns requests oldest first
+	List(ctx context.Context, filter VolunteerRequestFilter) ([]VolunteerRequest, error)
+	Update(ctx context.Context, request VolunteerRequest) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// openStore connects to the storage backend named in the config
+func openStore(ctx context.Context) (Store, error) {
+	switch config.Storage {
+	case "mongo":
+		return newMongoStore(ctx, config.MongoURI, config.MongoDatabase)
+	case "postgres":
+		return newPostgresStore(ctx, config.PostgresURL)
+	case "memory":
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE %q", config.Storage)
+	}
+}
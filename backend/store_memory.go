@@ -0,0 +1,814 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps everything in process memory. It is meant for tests and
+// local development; transactions hold a store-wide lock and roll back by
+// restoring a snapshot.
+type memoryStore struct {
+	mu   sync.Mutex
+	data *memoryData
+}
+
+type memoryData struct {
+	users       map[primitive.ObjectID]User
+	teachers    map[primitive.ObjectID]Teacher
+	events      map[primitive.ObjectID]Event
+	roles       map[primitive.ObjectID]Role
+	assignments map[primitive.ObjectID]Assignment
+	departments map[primitive.ObjectID]Department
+	sessions    map[primitive.ObjectID]Session
+	terms       map[primitive.ObjectID]Term
+	waitlist    map[primitive.ObjectID]WaitlistEntry
+	volunteers  map[primitive.ObjectID]VolunteerRequest
+	tokens      map[primitive.ObjectID]UserToken
+	logins      map[primitive.ObjectID]LoginFailure
+	ledger      []LedgerEntry
+	standings   []TermStanding
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{data: &memoryData{
+		users:       map[primitive.ObjectID]User{},
+		teachers:    map[primitive.ObjectID]Teacher{},
+		events:      map[primitive.ObjectID]Event{},
+		roles:       map[primitive.ObjectID]Role{},
+		assignments: map[primitive.ObjectID]Assignment{},
+		departments: map[primitive.ObjectID]Department{},
+		sessions:    map[primitive.ObjectID]Session{},
+		terms:       map[primitive.ObjectID]Term{},
+		waitlist:    map[primitive.ObjectID]WaitlistEntry{},
+		volunteers:  map[primitive.ObjectID]VolunteerRequest{},
+		tokens:      map[primitive.ObjectID]UserToken{},
+		logins:      map[primitive.ObjectID]LoginFailure{},
+	}}
+}
+
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		users:       cloneMap(d.users),
+		teachers:    cloneMap(d.teachers),
+		events:      cloneMap(d.events),
+		roles:       cloneMap(d.roles),
+		assignments: cloneMap(d.assignments),
+		departments: cloneMap(d.departments),
+		sessions:    cloneMap(d.sessions),
+		terms:       cloneMap(d.terms),
+		waitlist:    cloneMap(d.waitlist),
+		volunteers:  cloneMap(d.volunteers),
+		tokens:      cloneMap(d.tokens),
+		logins:      cloneMap(d.logins),
+		ledger:      append([]LedgerEntry(nil), d.ledger...),
+		standings:   append([]TermStanding(nil), d.standings...),
+	}
+}
+
+func cloneMap[V any](m map[primitive.ObjectID]V) map[primitive.ObjectID]V {
+	out := make(map[primitive.ObjectID]V, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// sortedValues returns the values accepted by keep ordered by ID, which
+// matches the creation order of ObjectIDs
+func sortedValues[V any](m map[primitive.ObjectID]V, keep func(V) bool) []V {
+	ids := make([]primitive.ObjectID, 0, len(m))
+	for id, v := range m {
+		if keep == nil || keep(v) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+
+	out := make([]V, len(ids))
+	for i, id := range ids {
+		out[i] = m[id]
+	}
+	return out
+}
+
+// getByID returns the record with the given ID or ErrNotFound
+func getByID[V any](m map[primitive.ObjectID]V, id primitive.ObjectID) (V, error) {
+	v, ok := m[id]
+	if !ok {
+		return v, ErrNotFound
+	}
+	return v, nil
+}
+
+// insertByID adds a record, refusing to overwrite an existing ID
+func insertByID[V any](m map[primitive.ObjectID]V, id primitive.ObjectID, v V) error {
+	if _, ok := m[id]; ok {
+		return ErrDuplicate
+	}
+	m[id] = v
+	return nil
+}
+
+// replaceByID overwrites an existing record
+func replaceByID[V any](m map[primitive.ObjectID]V, id primitive.ObjectID, v V) error {
+	if _, ok := m[id]; !ok {
+		return ErrNotFound
+	}
+	m[id] = v
+	return nil
+}
+
+// deleteByID removes an existing record
+func deleteByID[V any](m map[primitive.ObjectID]V, id primitive.ObjectID) error {
+	if _, ok := m[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m, id)
+	return nil
+}
+
+type memoryTxKey struct{}
+
+// lock takes the store lock unless ctx belongs to a transaction on this
+// store, which already holds it
+func (s *memoryStore) lock(ctx context.Context) func() {
+	if ctx.Value(memoryTxKey{}) == s {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *memoryStore) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) == s {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.data.clone()
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, s)); err != nil {
+		s.data = snapshot
+		return err
+	}
+	return nil
+}
+
+func (s *memoryStore) Migrate(ctx context.Context) error { return nil }
+
+func (s *memoryStore) Close(ctx context.Context) error { return nil }
+
+func (s *memoryStore) Users() UserRepository             { return memoryUsers{s} }
+func (s *memoryStore) Teachers() TeacherRepository       { return memoryTeachers{s} }
+func (s *memoryStore) Events() EventRepository           { return memoryEvents{s} }
+func (s *memoryStore) Roles() RoleRepository             { return memoryRoles{s} }
+func (s *memoryStore) Assignments() AssignmentRepository { return memoryAssignments{s} }
+func (s *memoryStore) Departments() DepartmentRepository { return memoryDepartments{s} }
+func (s *memoryStore) Ledger() LedgerRepository          { return memoryLedger{s} }
+func (s *memoryStore) Terms() TermRepository             { return memoryTerms{s} }
+func (s *memoryStore) Sessions() SessionRepository       { return memorySessions{s} }
+func (s *memoryStore) Waitlist() WaitlistRepository      { return memoryWaitlist{s} }
+func (s *memoryStore) VolunteerRequests() VolunteerRequestRepository {
+	return memoryVolunteerRequests{s}
+}
+func (s *memoryStore) UserTokens() UserTokenRepository { return memoryUserTokens{s} }
+func (s *memoryStore) LoginFailures() LoginFailureRepository {
+	return memoryLoginFailures{s}
+}
+
+type memoryUsers struct{ s *memoryStore }
+
+func (r memoryUsers) Create(ctx context.Context, user User) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.users, user.ID, user)
+}
+
+func (r memoryUsers) Get(ctx context.Context, id primitive.ObjectID) (User, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.users, id)
+}
+
+func (r memoryUsers) GetByEmail(ctx context.Context, email string) (User, error) {
+	defer r.s.lock(ctx)()
+	email = normalizeEmail(email)
+	users := sortedValues(r.s.data.users, func(u User) bool { return u.Email == email })
+	if len(users) == 0 {
+		return User{}, ErrNotFound
+	}
+	return users[0], nil
+}
+
+func (r memoryUsers) List(ctx context.Context) ([]User, error) {
+	defer r.s.lock(ctx)()
+	return sortedValues(r.s.data.users, nil), nil
+}
+
+func (r memoryUsers) CountByRole(ctx context.Context, role string) (int, error) {
+	defer r.s.lock(ctx)()
+	return len(sortedValues(r.s.data.users, func(u User) bool { return u.Role == role })), nil
+}
+
+func (r memoryUsers) Update(ctx context.Context, user User) error {
+	defer r.s.lock(ctx)()
+	return replaceByID(r.s.data.users, user.ID, user)
+}
+
+func (r memoryUsers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	return deleteByID(r.s.data.users, id)
+}
+
+type memoryTeachers struct{ s *memoryStore }
+
+func (r memoryTeachers) Create(ctx context.Context, teacher Teacher) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.teachers, teacher.ID, teacher)
+}
+
+func (r memoryTeachers) Get(ctx context.Context, id primitive.ObjectID) (Teacher, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.teachers, id)
+}
+
+func (r memoryTeachers) GetByEmail(ctx context.Context, email string) (Teacher, error) {
+	defer r.s.lock(ctx)()
+	email = normalizeEmail(email)
+	teachers := sortedValues(r.s.data.teachers, func(t Teacher) bool { return t.Email == email })
+	if len(teachers) == 0 {
+		return Teacher{}, ErrNotFound
+	}
+	return teachers[0], nil
+}
+
+func (f TeacherFilter) matches(t Teacher) bool {
+	return (f.DepartmentID.IsZero() || t.DepartmentID == f.DepartmentID) &&
+		(!f.Active || t.DeactivatedAt == nil)
+}
+
+func (r memoryTeachers) List(ctx context.Context, filter TeacherFilter) ([]Teacher, error) {
+	defer r.s.lock(ctx)()
+	return sortedValues(r.s.data.teachers, filter.matches), nil
+}
+
+func (r memoryTeachers) Update(ctx context.Context, teacher Teacher) error {
+	defer r.s.lock(ctx)()
+	return replaceByID(r.s.data.teachers, teacher.ID, teacher)
+}
+
+func (r memoryTeachers) SetPoints(ctx context.Context, id primitive.ObjectID, points int) error {
+	defer r.s.lock(ctx)()
+	teacher, ok := r.s.data.teachers[id]
+	if !ok {
+		return nil
+	}
+	teacher.Point = points
+	r.s.data.teachers[id] = teacher
+	return nil
+}
+
+func (r memoryTeachers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	return deleteByID(r.s.data.teachers, id)
+}
+
+type memoryEvents struct{ s *memoryStore }
+
+func (r memoryEvents) Create(ctx context.Context, event Event) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.events, event.ID, event)
+}
+
+func (r memoryEvents) Get(ctx context.Context, id primitive.ObjectID) (Event, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.events, id)
+}
+
+func (f EventFilter) matches(e Event) bool {
+	if !f.TermID.IsZero() && e.TermID != f.TermID ||
+		!f.DepartmentID.IsZero() && e.DepartmentID != f.DepartmentID ||
+		f.Status != "" && e.Status != f.Status {
+		return false
+	}
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(e.Name), search) &&
+			!strings.Contains(strings.ToLower(e.Description), search) {
+			return false
+		}
+	}
+
+	timed := !f.StartsFrom.IsZero() || !f.StartsBefore.IsZero() || !f.EndsAfter.IsZero() || !f.EndsBy.IsZero()
+	if e.StartsAt.IsZero() {
+		return !f.Scheduled && !timed
+	}
+	return (f.StartsFrom.IsZero() || !e.StartsAt.Before(f.StartsFrom)) &&
+		(f.StartsBefore.IsZero() || e.StartsAt.Before(f.StartsBefore)) &&
+		(f.EndsAfter.IsZero() || e.EndsAt.After(f.EndsAfter)) &&
+		(f.EndsBy.IsZero() || !e.EndsAt.After(f.EndsBy))
+}
+
+// before reports whether a comes before b in the page's order
+func (p EventPage) before(a, b Event) bool {
+	var cmp int
+	switch p.Sort {
+	case eventSortStart:
+		cmp = a.StartsAt.Compare(b.StartsAt)
+	case eventSortName:
+		cmp = strings.Compare(a.Name, b.Name)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.ID.Hex(), b.ID.Hex())
+	}
+	if p.Descending {
+		cmp = -cmp
+	}
+	return cmp < 0
+}
+
+func (r memoryEvents) List(ctx context.Context, filter EventFilter, page EventPage) ([]Event, error) {
+	defer r.s.lock(ctx)()
+	events := sortedValues(r.s.data.events, func(e Event) bool {
+		return filter.matches(e) && (page.After == nil || page.before(*page.After, e))
+	})
+	sort.SliceStable(events, func(i, j int) bool { return page.before(events[i], events[j]) })
+	if page.Limit > 0 && len(events) > page.Limit {
+		events = events[:page.Limit]
+	}
+	return events, nil
+}
+
+func (r memoryEvents) Count(ctx context.Context, filter EventFilter) (int, error) {
+	defer r.s.lock(ctx)()
+	return len(sortedValues(r.s.data.events, filter.matches)), nil
+}
+
+func (r memoryEvents) Update(ctx context.Context, event Event) error {
+	defer r.s.lock(ctx)()
+	return replaceByID(r.s.data.events, event.ID, event)
+}
+
+func (r memoryEvents) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	return deleteByID(r.s.data.events, id)
+}
+
+type memoryRoles struct{ s *memoryStore }
+
+func (r memoryRoles) Create(ctx context.Context, role Role) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.roles, role.ID, role)
+}
+
+func (r memoryRoles) Get(ctx context.Context, id primitive.ObjectID) (Role, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.roles, id)
+}
+
+// GetForUpdate needs no extra locking: transactions already hold the store lock
+func (r memoryRoles) GetForUpdate(ctx context.Context, id primitive.ObjectID) (Role, error) {
+	return r.Get(ctx, id)
+}
+
+func (r memoryRoles) List(ctx context.Context) ([]Role, error) {
+	defer r.s.lock(ctx)()
+	return sortedValues(r.s.data.roles, nil), nil
+}
+
+func (r memoryRoles) ListByEvent(ctx context.Context, eventID primitive.ObjectID) ([]Role, error) {
+	defer r.s.lock(ctx)()
+	return sortedValues(r.s.data.roles, func(role Role) bool { return role.EventID == eventID }), nil
+}
+
+func (r memoryRoles) Update(ctx context.Context, role Role) error {
+	defer r.s.lock(ctx)()
+	return replaceByID(r.s.data.roles, role.ID, role)
+}
+
+func (r memoryRoles) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	return deleteByID(r.s.data.roles, id)
+}
+
+type memoryAssignments struct{ s *memoryStore }
+
+func (f AssignmentFilter) matches(a Assignment) bool {
+	return (f.TeacherID.IsZero() || a.TeacherID == f.TeacherID) &&
+		(f.RoleID.IsZero() || a.RoleID == f.RoleID) &&
+		(f.EventID.IsZero() || a.EventID == f.EventID) &&
+		(f.Attendance == "" || a.Attendance == f.Attendance)
+}
+
+func (r memoryAssignments) Create(ctx context.Context, assignment Assignment) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.assignments, assignment.ID, assignment)
+}
+
+func (r memoryAssignments) Get(ctx context.Context, id primitive.ObjectID) (Assignment, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.assignments, id)
+}
+
+func (r memoryAssignments) List(ctx context.Context, filter AssignmentFilter) ([]Assignment, error) {
+	defer r.s.lock(ctx)()
+	return sortedValues(r.s.data.assignments, filter.matches), nil
+}
+
+func (r memoryAssignments) Count(ctx context.Context, filter AssignmentFilter) (int, error) {
+	assignments, err := r.List(ctx, filter)
+	return len(assignments), err
+}
+
+func (r memoryAssignments) Update(ctx context.Context, assignment Assignment) error {
+	defer r.s.lock(ctx)()
+	return replaceByID(r.s.data.assignments, assignment.ID, assignment)
+}
+
+func (r memoryAssignments) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	return deleteByID(r.s.data.assignments, id)
+}
+
+type memoryDepartments struct{ s *memoryStore }
+
+// nameTaken reports whether another department already uses the name; the
+// caller holds the lock
+func (r memoryDepartments) nameTaken(department Department) bool {
+	for id, d := range r.s.data.departments {
+		if id != department.ID && d.Name == department.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memoryDepartments) Create(ctx context.Context, department Department) error {
+	defer r.s.lock(ctx)()
+	if r.nameTaken(department) {
+		return ErrDuplicate
+	}
+	return insertByID(r.s.data.departments, department.ID, department)
+}
+
+func (r memoryDepartments) Get(ctx context.Context, id primitive.ObjectID) (Department, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.departments, id)
+}
+
+func (r memoryDepartments) GetByName(ctx context.Context, name string) (Department, error) {
+	defer r.s.lock(ctx)()
+	departments := sortedValues(r.s.data.departments, func(d Department) bool { return d.Name == name })
+	if len(departments) == 0 {
+		return Department{}, ErrNotFound
+	}
+	return departments[0], nil
+}
+
+func (r memoryDepartments) List(ctx context.Context) ([]Department, error) {
+	defer r.s.lock(ctx)()
+	departments := sortedValues(r.s.data.departments, nil)
+	sort.SliceStable(departments, func(i, j int) bool { return departments[i].Name < departments[j].Name })
+	return departments, nil
+}
+
+func (r memoryDepartments) Update(ctx context.Context, department Department) error {
+	defer r.s.lock(ctx)()
+	if r.nameTaken(department) {
+		return ErrDuplicate
+	}
+	return replaceByID(r.s.data.departments, department.ID, department)
+}
+
+func (r memoryDepartments) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	return deleteByID(r.s.data.departments, id)
+}
+
+type memoryLedger struct{ s *memoryStore }
+
+func (f LedgerFilter) matches(e LedgerEntry) bool {
+	return (f.TeacherID.IsZero() || e.TeacherID == f.TeacherID) &&
+		(f.AssignmentID.IsZero() || e.AssignmentID == f.AssignmentID) &&
+		(f.TermID.IsZero() || e.TermID == f.TermID)
+}
+
+func (r memoryLedger) Append(ctx context.Context, entries ...LedgerEntry) error {
+	defer r.s.lock(ctx)()
+	ids := make(map[primitive.ObjectID]bool)
+	reversed := make(map[primitive.ObjectID]bool)
+	for _, entry := range r.s.data.ledger {
+		ids[entry.ID] = true
+		reversed[entry.ReversesID] = true
+	}
+	for _, entry := range entries {
+		if ids[entry.ID] || (!entry.ReversesID.IsZero() && reversed[entry.ReversesID]) {
+			return ErrDuplicate
+		}
+		ids[entry.ID] = true
+		reversed[entry.ReversesID] = true
+	}
+	r.s.data.ledger = append(r.s.data.ledger, entries...)
+	return nil
+}
+
+func (r memoryLedger) Get(ctx context.Context, id primitive.ObjectID) (LedgerEntry, error) {
+	defer r.s.lock(ctx)()
+	for _, entry := range r.s.data.ledger {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return LedgerEntry{}, ErrNotFound
+}
+
+func (r memoryLedger) List(ctx context.Context, filter LedgerFilter) ([]LedgerEntry, error) {
+	defer r.s.lock(ctx)()
+	var entries []LedgerEntry
+	for _, entry := range r.s.data.ledger {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+func (r memoryLedger) Sum(ctx context.Context, filter LedgerFilter) (int, error) {
+	entries, err := r.List(ctx, filter)
+	total := 0
+	for _, entry := range entries {
+		total += entry.Points
+	}
+	return total, err
+}
+
+func (r memoryLedger) Balances(ctx context.Context, filter LedgerFilter) (map[primitive.ObjectID]int, error) {
+	defer r.s.lock(ctx)()
+	balances := make(map[primitive.ObjectID]int)
+	for _, entry := range r.s.data.ledger {
+		if filter.matches(entry) {
+			balances[entry.TeacherID] += entry.Points
+		}
+	}
+	return balances, nil
+}
+
+type memoryTerms struct{ s *memoryStore }
+
+func (r memoryTerms) Create(ctx context.Context, term Term) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.terms, term.ID, term)
+}
+
+func (r memoryTerms) Get(ctx context.Context, id primitive.ObjectID) (Term, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.terms, id)
+}
+
+func (r memoryTerms) List(ctx context.Context) ([]Term, error) {
+	defer r.s.lock(ctx)()
+	terms := sortedValues(r.s.data.terms, nil)
+	sort.SliceStable(terms, func(i, j int) bool { return terms[i].StartDate < terms[j].StartDate })
+	return terms, nil
+}
+
+func (r memoryTerms) Update(ctx context.Context, term Term) error {
+	defer r.s.lock(ctx)()
+	return replaceByID(r.s.data.terms, term.ID, term)
+}
+
+func (r memoryTerms) ArchiveStandings(ctx context.Context, termID primitive.ObjectID, standings []TermStanding) error {
+	defer r.s.lock(ctx)()
+	for _, standing := range r.s.data.standings {
+		if standing.TermID == termID {
+			return ErrDuplicate
+		}
+	}
+	r.s.data.standings = append(r.s.data.standings, standings...)
+	return nil
+}
+
+func (r memoryTerms) Standings(ctx context.Context, termID primitive.ObjectID) ([]TermStanding, error) {
+	defer r.s.lock(ctx)()
+	var standings []TermStanding
+	for _, standing := range r.s.data.standings {
+		if standing.TermID == termID {
+			standings = append(standings, standing)
+		}
+	}
+	sort.SliceStable(standings, func(i, j int) bool { return standings[i].Rank < standings[j].Rank })
+	return standings, nil
+}
+
+type memorySessions struct{ s *memoryStore }
+
+func (r memorySessions) Create(ctx context.Context, session Session) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.sessions, session.ID, session)
+}
+
+func (r memorySessions) GetActive(ctx context.Context, id primitive.ObjectID) (Session, error) {
+	defer r.s.lock(ctx)()
+	session, ok := r.s.data.sessions[id]
+	if !ok || session.RevokedAt != nil || !session.ExpiresAt.After(time.Now()) {
+		return Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r memorySessions) RotateRefresh(ctx context.Context, id primitive.ObjectID, oldRefreshID, newRefreshID string) (bool, error) {
+	defer r.s.lock(ctx)()
+	session, ok := r.s.data.sessions[id]
+	if !ok || session.RefreshID != oldRefreshID {
+		return false, nil
+	}
+	session.RefreshID = newRefreshID
+	r.s.data.sessions[id] = session
+	return true, nil
+}
+
+func (r memorySessions) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	r.s.revokeSessions(func(s Session) bool { return s.ID == id })
+	return nil
+}
+
+func (r memorySessions) RevokeAllForUser(ctx context.Context, userID primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	r.s.revokeSessions(func(s Session) bool { return s.UserID == userID })
+	return nil
+}
+
+// revokeSessions marks matching sessions as revoked; the caller holds the lock
+func (s *memoryStore) revokeSessions(match func(Session) bool) {
+	now := time.Now()
+	for id, session := range s.data.sessions {
+		if session.RevokedAt == nil && match(session) {
+			session.RevokedAt = &now
+			s.data.sessions[id] = session
+		}
+	}
+}
+
+type memoryWaitlist struct{ s *memoryStore }
+
+func (f WaitlistFilter) matches(e WaitlistEntry) bool {
+	return (f.RoleID.IsZero() || e.RoleID == f.RoleID) &&
+		(f.EventID.IsZero() || e.EventID == f.EventID) &&
+		(f.TeacherID.IsZero() || e.TeacherID == f.TeacherID)
+}
+
+func (r memoryWaitlist) Create(ctx context.Context, entry WaitlistEntry) error {
+	defer r.s.lock(ctx)()
+	for _, existing := range r.s.data.waitlist {
+		if existing.RoleID == entry.RoleID && existing.TeacherID == entry.TeacherID {
+			return ErrDuplicate
+		}
+	}
+	return insertByID(r.s.data.waitlist, entry.ID, entry)
+}
+
+func (r memoryWaitlist) Get(ctx context.Context, id primitive.ObjectID) (WaitlistEntry, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.waitlist, id)
+}
+
+func (r memoryWaitlist) List(ctx context.Context, filter WaitlistFilter) ([]WaitlistEntry, error) {
+	defer r.s.lock(ctx)()
+	entries := sortedValues(r.s.data.waitlist, filter.matches)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Position < entries[j].Position })
+	return entries, nil
+}
+
+func (r memoryWaitlist) Update(ctx context.Context, entry WaitlistEntry) error {
+	defer r.s.lock(ctx)()
+	return replaceByID(r.s.data.waitlist, entry.ID, entry)
+}
+
+func (r memoryWaitlist) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	return deleteByID(r.s.data.waitlist, id)
+}
+
+type memoryVolunteerRequests struct{ s *memoryStore }
+
+func (f VolunteerRequestFilter) matches(r VolunteerRequest) bool {
+	return (f.EventID.IsZero() || r.EventID == f.EventID) &&
+		(f.RoleID.IsZero() || r.RoleID == f.RoleID) &&
+		(f.TeacherID.IsZero() || r.TeacherID == f.TeacherID) &&
+		(f.Status == "" || r.Status == f.Status) &&
+		(!f.Decided || r.Status == requestApproved || r.Status == requestRejected)
+}
+
+func (r memoryVolunteerRequests) Create(ctx context.Context, request VolunteerRequest) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.volunteers, request.ID, request)
+}
+
+func (r memoryVolunteerRequests) Get(ctx context.Context, id primitive.ObjectID) (VolunteerRequest, error) {
+	defer r.s.lock(ctx)()
+	return getByID(r.s.data.volunteers, id)
+}
+
+func (r memoryVolunteerRequests) List(ctx context.Context, filter VolunteerRequestFilter) ([]VolunteerRequest, error) {
+	defer r.s.lock(ctx)()
+	return sortedValues(r.s.data.volunteers, filter.matches), nil
+}
+
+func (r memoryVolunteerRequests) Update(ctx context.Context, request VolunteerRequest) error {
+	defer r.s.lock(ctx)()
+	return replaceByID(r.s.data.volunteers, request.ID, request)
+}
+
+func (r memoryVolunteerRequests) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+	return deleteByID(r.s.data.volunteers, id)
+}
+
+type memoryUserTokens struct{ s *memoryStore }
+
+func (r memoryUserTokens) Create(ctx context.Context, token UserToken) error {
+	defer r.s.lock(ctx)()
+	for _, existing := range r.s.data.tokens {
+		if existing.Hash == token.Hash {
+			return ErrDuplicate
+		}
+	}
+	return insertByID(r.s.data.tokens, token.ID, token)
+}
+
+func (r memoryUserTokens) GetActive(ctx context.Context, purpose, hash string) (UserToken, error) {
+	defer r.s.lock(ctx)()
+	now := time.Now()
+	for _, token := range r.s.data.tokens {
+		if token.Purpose == purpose && token.Hash == hash && token.UsedAt == nil && token.ExpiresAt.After(now) {
+			return token, nil
+		}
+	}
+	return UserToken{}, ErrNotFound
+}
+
+func (r memoryUserTokens) Use(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	defer r.s.lock(ctx)()
+	token, ok := r.s.data.tokens[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	r.s.data.tokens[id] = token
+	return true, nil
+}
+
+func (r memoryUserTokens) UseAllForUser(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	defer r.s.lock(ctx)()
+	now := time.Now()
+	for id, token := range r.s.data.tokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			token.UsedAt = &now
+			r.s.data.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+type memoryLoginFailures struct{ s *memoryStore }
+
+func (f LoginFailureFilter) matches(l LoginFailure) bool {
+	return (f.Email == "" || l.Email == f.Email) &&
+		(f.IP == "" || l.IP == f.IP) &&
+		(len(f.Reasons) == 0 || slices.Contains(f.Reasons, l.Reason)) &&
+		!l.CreatedAt.Before(f.Since) &&
+		(!f.Uncleared || l.ClearedAt == nil)
+}
+
+func (r memoryLoginFailures) Create(ctx context.Context, failure LoginFailure) error {
+	defer r.s.lock(ctx)()
+	return insertByID(r.s.data.logins, failure.ID, failure)
+}
+
+func (r memoryLoginFailures) List(ctx context.Context, filter LoginFailureFilter) ([]LoginFailure, error) {
+	defer r.s.lock(ctx)()
+	return sortedValues(r.s.data.logins, filter.matches), nil
+}
+
+func (r memoryLoginFailures) Clear(ctx context.Context, email string) error {
+	defer r.s.lock(ctx)()
+	now := time.Now()
+	for id, failure := range r.s.data.logins {
+		if failure.Email == email && failure.ClearedAt == nil {
+			failure.ClearedAt = &now
+			r.s.data.logins[id] = failure
+		}
+	}
+	return nil
+}
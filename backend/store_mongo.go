@@ -0,0 +1,1046 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore keeps everything in MongoDB. Transactions need a replica set.
+type mongoStore struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+func newMongoStore(ctx context.Context, uri, database string) (*mongoStore, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	// Ping the database to verify connection
+	if err := client.Ping(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	return &mongoStore{client: client, db: client.Database(database)}, nil
+}
+
+func (s *mongoStore) Users() UserRepository {
+	return mongoUsers{s.db.Collection(userCollection)}
+}
+
+func (s *mongoStore) Teachers() TeacherRepository {
+	return mongoTeachers{s.db.Collection(teacherCollection)}
+}
+
+func (s *mongoStore) Events() EventRepository {
+	return mongoEvents{s.db.Collection(eventCollection)}
+}
+
+func (s *mongoStore) Roles() RoleRepository {
+	return mongoRoles{s.db.Collection(roleCollection)}
+}
+
+func (s *mongoStore) Assignments() AssignmentRepository {
+	return mongoAssignments{s.db.Collection(teacherAssignmentCollection)}
+}
+
+func (s *mongoStore) Departments() DepartmentRepository {
+	return mongoDepartments{s.db.Collection(departmentCollection)}
+}
+
+func (s *mongoStore) Ledger() LedgerRepository {
+	return mongoLedger{s.db.Collection(pointsLedgerCollection)}
+}
+
+func (s *mongoStore) Terms() TermRepository {
+	return mongoTerms{s.db.Collection(termCollection), s.db.Collection(termStandingCollection)}
+}
+
+func (s *mongoStore) Sessions() SessionRepository {
+	return mongoSessions{s.db.Collection(sessionCollection)}
+}
+
+func (s *mongoStore) Waitlist() WaitlistRepository {
+	return mongoWaitlist{s.db.Collection(waitlistCollection)}
+}
+
+func (s *mongoStore) VolunteerRequests() VolunteerRequestRepository {
+	return mongoVolunteerRequests{s.db.Collection(volunteerRequestCollection)}
+}
+
+func (s *mongoStore) UserTokens() UserTokenRepository {
+	return mongoUserTokens{s.db.Collection(userTokenCollection)}
+}
+
+func (s *mongoStore) LoginFailures() LoginFailureRepository {
+	return mongoLoginFailures{s.db.Collection(loginFailureCollection)}
+}
+
+// RunInTransaction runs fn in a multi-document transaction. The driver
+// retries fn on transient errors such as write conflicts. Calls made while
+// already inside a transaction join it.
+func (s *mongoStore) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func (s *mongoStore) Migrate(ctx context.Context) error {
+	// Assignments and deletions run in multi-document transactions, which
+	// MongoDB only supports on replica sets (a single-node set is enough)
+	var hello bson.M
+	if err := s.client.Database("admin").RunCommand(ctx, bson.M{"hello": 1}).Decode(&hello); err != nil {
+		return fmt.Errorf("checking MongoDB topology: %w", err)
+	}
+	if hello["setName"] == nil && hello["msg"] != "isdbgrid" {
+		return errors.New("MongoDB is not running as a replica set; start mongod with --replSet and run rs.initiate() (see README)")
+	}
+
+	// The unique reverses_id index stops an entry from being reversed twice
+	_, err := s.db.Collection(pointsLedgerCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "teacher_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "assignment_id", Value: 1}}},
+		{Keys: bson.D{{Key: "term_id", Value: 1}, {Key: "teacher_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "reverses_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Collection(departmentCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "department_name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Collection(teacherCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "department_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Collection(termStandingCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "term_id", Value: 1}, {Key: "teacher_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Collection(eventCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "starts_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "ends_at", Value: 1}}},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "term_id", Value: 1}, {Key: "starts_at", Value: 1}}},
+		{Keys: bson.D{{Key: "department_id", Value: 1}, {Key: "starts_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "starts_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Collection(teacherAssignmentCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "attendance", Value: 1}, {Key: "event_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A teacher waits at most once per role
+	_, err = s.db.Collection(waitlistCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "role_id", Value: 1}, {Key: "teacher_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "role_id", Value: 1}, {Key: "position", Value: 1}}},
+		{Keys: bson.D{{Key: "teacher_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Collection(volunteerRequestCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "event_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "teacher_id", Value: 1}, {Key: "role_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Tokens are looked up by hash, and expired ones are removed
+	_, err = s.db.Collection(userTokenCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Failures are counted per email and per address over recent times
+	_, err = s.db.Collection(loginFailureCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.migrateEmailVerification(ctx); err != nil {
+		return err
+	}
+	if err := s.migrateAwardedPoints(ctx); err != nil {
+		return err
+	}
+	if err := migrateEventSchedules(ctx, s); err != nil {
+		return err
+	}
+	if err := migrateEventStatuses(ctx, s); err != nil {
+		return err
+	}
+	if err := migrateAttendance(ctx, s); err != nil {
+		return err
+	}
+	if err := migrateLegacyPoints(ctx, s); err != nil {
+		return err
+	}
+	if err := migrateDepartments(ctx, s); err != nil {
+		return err
+	}
+	if err := migrateEmails(ctx, s); err != nil {
+		return err
+	}
+	if err := migrateUserLinks(ctx, s); err != nil {
+		return err
+	}
+
+	// Links are one-to-one; the indexes can only be built once they are
+	// repaired
+	linked := options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"user_id": bson.M{"$exists": true}})
+	if _, err := s.db.Collection(userCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}}, Options: linked,
+	}); err != nil {
+		return err
+	}
+	_, err = s.db.Collection(teacherCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}}, Options: linked,
+	})
+	return err
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// migrateAwardedPoints records what assignments credited before awards were
+// kept per assignment were worth: their role's point value at the time.
+// Assignments without a points status predate statuses and count as
+// credited. New documents always carry awarded_points, so this only touches
+// old ones; it must run before other migrations rewrite them.
+func (s *mongoStore) migrateAwardedPoints(ctx context.Context) error {
+	var assignments []Assignment
+	err := mongoFindAll(ctx, s.db.Collection(teacherAssignmentCollection), bson.M{
+		"points_status":  bson.M{"$in": bson.A{pointsAwarded, "", nil}},
+		"awarded_points": bson.M{"$exists": false},
+	}, &assignments)
+	if err != nil {
+		return err
+	}
+	for _, assignment := range assignments {
+		role, err := s.Roles().Get(ctx, assignment.RoleID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		assignment.AwardedPoints = role.Point
+		if err := s.Assignments().Update(ctx, assignment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mongoError maps driver errors onto the Store errors
+func mongoError(err error) error {
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return ErrDuplicate
+	default:
+		return err
+	}
+}
+
+// mongoFindOne decodes the first document matching filter into out
+func mongoFindOne(ctx context.Context, c *mongo.Collection, filter bson.M, out interface{}) error {
+	return mongoError(c.FindOne(ctx, filter).Decode(out))
+}
+
+// mongoFindAll decodes every document matching filter into out
+func mongoFindAll(ctx context.Context, c *mongo.Collection, filter bson.M, out interface{}, opts ...*options.FindOptions) error {
+	cursor, err := c.Find(ctx, filter, opts...)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, out)
+}
+
+// mongoReplace replaces the document with the given _id
+func mongoReplace(ctx context.Context, c *mongo.Collection, id primitive.ObjectID, doc interface{}) error {
+	result, err := c.ReplaceOne(ctx, bson.M{"_id": id}, doc)
+	if err != nil {
+		return mongoError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// mongoDelete deletes the document with the given _id
+func mongoDelete(ctx context.Context, c *mongo.Collection, id primitive.ObjectID) error {
+	result, err := c.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+var mongoByID = options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+type mongoUsers struct{ c *mongo.Collection }
+
+func (r mongoUsers) Create(ctx context.Context, user User) error {
+	_, err := r.c.InsertOne(ctx, user)
+	return mongoError(err)
+}
+
+func (r mongoUsers) Get(ctx context.Context, id primitive.ObjectID) (User, error) {
+	var user User
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &user)
+	return user, err
+}
+
+func (r mongoUsers) GetByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := mongoFindOne(ctx, r.c, bson.M{"email": normalizeEmail(email)}, &user)
+	return user, err
+}
+
+func (r mongoUsers) List(ctx context.Context) ([]User, error) {
+	var users []User
+	err := mongoFindAll(ctx, r.c, bson.M{}, &users, mongoByID)
+	return users, err
+}
+
+func (r mongoUsers) CountByRole(ctx context.Context, role string) (int, error) {
+	count, err := r.c.CountDocuments(ctx, bson.M{"role": role})
+	return int(count), err
+}
+
+func (r mongoUsers) Update(ctx context.Context, user User) error {
+	return mongoReplace(ctx, r.c, user.ID, user)
+}
+
+func (r mongoUsers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return mongoDelete(ctx, r.c, id)
+}
+
+type mongoTeachers struct{ c *mongo.Collection }
+
+func (r mongoTeachers) Create(ctx context.Context, teacher Teacher) error {
+	_, err := r.c.InsertOne(ctx, teacher)
+	return mongoError(err)
+}
+
+func (r mongoTeachers) Get(ctx context.Context, id primitive.ObjectID) (Teacher, error) {
+	var teacher Teacher
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &teacher)
+	return teacher, err
+}
+
+func (r mongoTeachers) GetByEmail(ctx context.Context, email string) (Teacher, error) {
+	var teacher Teacher
+	err := mongoFindOne(ctx, r.c, bson.M{"email": normalizeEmail(email)}, &teacher)
+	return teacher, err
+}
+
+func (f TeacherFilter) bson() bson.M {
+	filter := bson.M{}
+	if !f.DepartmentID.IsZero() {
+		filter["department_id"] = f.DepartmentID
+	}
+	if f.Active {
+		filter["deactivated_at"] = bson.M{"$exists": false}
+	}
+	return filter
+}
+
+func (r mongoTeachers) List(ctx context.Context, filter TeacherFilter) ([]Teacher, error) {
+	var teachers []Teacher
+	err := mongoFindAll(ctx, r.c, filter.bson(), &teachers, mongoByID)
+	return teachers, err
+}
+
+func (r mongoTeachers) Update(ctx context.Context, teacher Teacher) error {
+	return mongoReplace(ctx, r.c, teacher.ID, teacher)
+}
+
+func (r mongoTeachers) SetPoints(ctx context.Context, id primitive.ObjectID, points int) error {
+	_, err := r.c.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"point": points}})
+	return err
+}
+
+func (r mongoTeachers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return mongoDelete(ctx, r.c, id)
+}
+
+type mongoEvents struct{ c *mongo.Collection }
+
+func (r mongoEvents) Create(ctx context.Context, event Event) error {
+	_, err := r.c.InsertOne(ctx, event)
+	return mongoError(err)
+}
+
+func (r mongoEvents) Get(ctx context.Context, id primitive.ObjectID) (Event, error) {
+	var event Event
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &event)
+	return event, err
+}
+
+func (f EventFilter) bson() bson.M {
+	filter := bson.M{}
+	if !f.TermID.IsZero() {
+		filter["term_id"] = f.TermID
+	}
+	if !f.DepartmentID.IsZero() {
+		filter["department_id"] = f.DepartmentID
+	}
+	if f.Status != "" {
+		filter["status"] = f.Status
+	}
+	if f.Search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(f.Search), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"description": pattern}}
+	}
+
+	startsAt := bson.M{}
+	if f.Scheduled {
+		startsAt["$exists"] = true
+	}
+	if !f.StartsFrom.IsZero() {
+		startsAt["$gte"] = f.StartsFrom
+	}
+	if !f.StartsBefore.IsZero() {
+		startsAt["$lt"] = f.StartsBefore
+	}
+	if len(startsAt) > 0 {
+		filter["starts_at"] = startsAt
+	}
+	endsAt := bson.M{}
+	if !f.EndsAfter.IsZero() {
+		endsAt["$gt"] = f.EndsAfter
+	}
+	if !f.EndsBy.IsZero() {
+		endsAt["$lte"] = f.EndsBy
+	}
+	if len(endsAt) > 0 {
+		filter["ends_at"] = endsAt
+	}
+	return filter
+}
+
+// bson returns the sort order and the condition selecting events after
+// page.After
+func (p EventPage) bson() (bson.D, bson.M) {
+	direction, after := 1, "$gt"
+	if p.Descending {
+		direction, after = -1, "$lt"
+	}
+
+	var key string
+	var value interface{}
+	switch p.Sort {
+	case eventSortStart:
+		key = "starts_at"
+		if p.After != nil {
+			value = p.After.StartsAt
+		}
+	case eventSortName:
+		key = "name"
+		if p.After != nil {
+			value = p.After.Name
+		}
+	}
+
+	order := bson.D{{Key: "_id", Value: direction}}
+	if key != "" {
+		order = append(bson.D{{Key: key, Value: direction}}, order...)
+	}
+	if p.After == nil {
+		return order, nil
+	}
+	if key == "" {
+		return order, bson.M{"_id": bson.M{after: p.After.ID}}
+	}
+	return order, bson.M{"$or": bson.A{
+		bson.M{key: bson.M{after: value}},
+		bson.M{key: value, "_id": bson.M{after: p.After.ID}},
+	}}
+}
+
+func (r mongoEvents) List(ctx context.Context, filter EventFilter, page EventPage) ([]Event, error) {
+	order, after := page.bson()
+	query := filter.bson()
+	if after != nil {
+		query = bson.M{"$and": bson.A{query, after}}
+	}
+	opts := options.Find().SetSort(order)
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit))
+	}
+
+	var events []Event
+	err := mongoFindAll(ctx, r.c, query, &events, opts)
+	return events, err
+}
+
+func (r mongoEvents) Count(ctx context.Context, filter EventFilter) (int, error) {
+	n, err := r.c.CountDocuments(ctx, filter.bson())
+	return int(n), err
+}
+
+func (r mongoEvents) Update(ctx context.Context, event Event) error {
+	return mongoReplace(ctx, r.c, event.ID, event)
+}
+
+func (r mongoEvents) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return mongoDelete(ctx, r.c, id)
+}
+
+type mongoRoles struct{ c *mongo.Collection }
+
+func (r mongoRoles) Create(ctx context.Context, role Role) error {
+	_, err := r.c.InsertOne(ctx, role)
+	return mongoError(err)
+}
+
+func (r mongoRoles) Get(ctx context.Context, id primitive.ObjectID) (Role, error) {
+	var role Role
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &role)
+	return role, err
+}
+
+// GetForUpdate writes to the role document so that any other transaction
+// touching the same role hits a write conflict and is retried
+func (r mongoRoles) GetForUpdate(ctx context.Context, id primitive.ObjectID) (Role, error) {
+	var role Role
+	err := r.c.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"assignment_version": 1}},
+	).Decode(&role)
+	return role, mongoError(err)
+}
+
+func (r mongoRoles) List(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	err := mongoFindAll(ctx, r.c, bson.M{}, &roles, mongoByID)
+	return roles, err
+}
+
+func (r mongoRoles) ListByEvent(ctx context.Context, eventID primitive.ObjectID) ([]Role, error) {
+	var roles []Role
+	err := mongoFindAll(ctx, r.c, bson.M{"event_id": eventID}, &roles, mongoByID)
+	return roles, err
+}
+
+func (r mongoRoles) Update(ctx context.Context, role Role) error {
+	return mongoReplace(ctx, r.c, role.ID, role)
+}
+
+func (r mongoRoles) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return mongoDelete(ctx, r.c, id)
+}
+
+type mongoAssignments struct{ c *mongo.Collection }
+
+func (f AssignmentFilter) bson() bson.M {
+	filter := bson.M{}
+	if !f.TeacherID.IsZero() {
+		filter["teacher_id"] = f.TeacherID
+	}
+	if !f.RoleID.IsZero() {
+		filter["role_id"] = f.RoleID
+	}
+	if !f.EventID.IsZero() {
+		filter["event_id"] = f.EventID
+	}
+	if f.Attendance != "" {
+		filter["attendance"] = f.Attendance
+	}
+	return filter
+}
+
+func (r mongoAssignments) Create(ctx context.Context, assignment Assignment) error {
+	_, err := r.c.InsertOne(ctx, assignment)
+	return mongoError(err)
+}
+
+func (r mongoAssignments) Get(ctx context.Context, id primitive.ObjectID) (Assignment, error) {
+	var assignment Assignment
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &assignment)
+	return assignment, err
+}
+
+func (r mongoAssignments) List(ctx context.Context, filter AssignmentFilter) ([]Assignment, error) {
+	var assignments []Assignment
+	err := mongoFindAll(ctx, r.c, filter.bson(), &assignments, mongoByID)
+	return assignments, err
+}
+
+func (r mongoAssignments) Count(ctx context.Context, filter AssignmentFilter) (int, error) {
+	count, err := r.c.CountDocuments(ctx, filter.bson())
+	return int(count), err
+}
+
+func (r mongoAssignments) Update(ctx context.Context, assignment Assignment) error {
+	return mongoReplace(ctx, r.c, assignment.ID, assignment)
+}
+
+func (r mongoAssignments) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return mongoDelete(ctx, r.c, id)
+}
+
+type mongoDepartments struct{ c *mongo.Collection }
+
+func (r mongoDepartments) Create(ctx context.Context, department Department) error {
+	_, err := r.c.InsertOne(ctx, department)
+	return mongoError(err)
+}
+
+func (r mongoDepartments) Get(ctx context.Context, id primitive.ObjectID) (Department, error) {
+	var department Department
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &department)
+	return department, err
+}
+
+func (r mongoDepartments) GetByName(ctx context.Context, name string) (Department, error) {
+	var department Department
+	err := mongoFindOne(ctx, r.c, bson.M{"department_name": name}, &department)
+	return department, err
+}
+
+func (r mongoDepartments) List(ctx context.Context) ([]Department, error) {
+	var departments []Department
+	opts := options.Find().SetSort(bson.D{{Key: "department_name", Value: 1}})
+	err := mongoFindAll(ctx, r.c, bson.M{}, &departments, opts)
+	return departments, err
+}
+
+func (r mongoDepartments) Update(ctx context.Context, department Department) error {
+	return mongoReplace(ctx, r.c, department.ID, department)
+}
+
+func (r mongoDepartments) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return mongoDelete(ctx, r.c, id)
+}
+
+type mongoLedger struct{ c *mongo.Collection }
+
+func (f LedgerFilter) bson() bson.M {
+	filter := bson.M{}
+	if !f.TeacherID.IsZero() {
+		filter["teacher_id"] = f.TeacherID
+	}
+	if !f.AssignmentID.IsZero() {
+		filter["assignment_id"] = f.AssignmentID
+	}
+	if !f.TermID.IsZero() {
+		filter["term_id"] = f.TermID
+	}
+	return filter
+}
+
+func (r mongoLedger) Append(ctx context.Context, entries ...LedgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		docs[i] = entry
+	}
+	_, err := r.c.InsertMany(ctx, docs)
+	return mongoError(err)
+}
+
+func (r mongoLedger) Get(ctx context.Context, id primitive.ObjectID) (LedgerEntry, error) {
+	var entry LedgerEntry
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &entry)
+	return entry, err
+}
+
+func (r mongoLedger) List(ctx context.Context, filter LedgerFilter) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	err := mongoFindAll(ctx, r.c, filter.bson(), &entries, opts)
+	return entries, err
+}
+
+func (r mongoLedger) Sum(ctx context.Context, filter LedgerFilter) (int, error) {
+	balances, err := r.sumBy(ctx, filter.bson(), nil)
+	if err != nil || len(balances) == 0 {
+		return 0, err
+	}
+	return balances[0].Total, nil
+}
+
+func (r mongoLedger) Balances(ctx context.Context, filter LedgerFilter) (map[primitive.ObjectID]int, error) {
+	balances, err := r.sumBy(ctx, filter.bson(), "$teacher_id")
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[primitive.ObjectID]int, len(balances))
+	for _, balance := range balances {
+		result[balance.TeacherID] = balance.Total
+	}
+	return result, nil
+}
+
+type mongoBalance struct {
+	TeacherID primitive.ObjectID `bson:"_id"`
+	Total     int                `bson:"total"`
+}
+
+// sumBy totals the points of matching entries grouped by the given key
+func (r mongoLedger) sumBy(ctx context.Context, filter bson.M, key interface{}) ([]mongoBalance, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": key, "total": bson.M{"$sum": "$points"}}}},
+	}
+
+	cursor, err := r.c.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []mongoBalance
+	err = cursor.All(ctx, &result)
+	return result, err
+}
+
+type mongoTerms struct {
+	c         *mongo.Collection
+	standings *mongo.Collection
+}
+
+func (r mongoTerms) Create(ctx context.Context, term Term) error {
+	_, err := r.c.InsertOne(ctx, term)
+	return mongoError(err)
+}
+
+func (r mongoTerms) Get(ctx context.Context, id primitive.ObjectID) (Term, error) {
+	var term Term
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &term)
+	return term, err
+}
+
+func (r mongoTerms) List(ctx context.Context) ([]Term, error) {
+	var terms []Term
+	opts := options.Find().SetSort(bson.D{{Key: "start_date", Value: 1}, {Key: "_id", Value: 1}})
+	err := mongoFindAll(ctx, r.c, bson.M{}, &terms, opts)
+	return terms, err
+}
+
+func (r mongoTerms) Update(ctx context.Context, term Term) error {
+	return mongoReplace(ctx, r.c, term.ID, term)
+}
+
+func (r mongoTerms) ArchiveStandings(ctx context.Context, termID primitive.ObjectID, standings []TermStanding) error {
+	count, err := r.standings.CountDocuments(ctx, bson.M{"term_id": termID})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDuplicate
+	}
+	if len(standings) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(standings))
+	for i, standing := range standings {
+		docs[i] = standing
+	}
+	_, err = r.standings.InsertMany(ctx, docs)
+	return mongoError(err)
+}
+
+func (r mongoTerms) Standings(ctx context.Context, termID primitive.ObjectID) ([]TermStanding, error) {
+	var standings []TermStanding
+	opts := options.Find().SetSort(bson.D{{Key: "rank", Value: 1}, {Key: "teacher_name", Value: 1}})
+	err := mongoFindAll(ctx, r.standings, bson.M{"term_id": termID}, &standings, opts)
+	return standings, err
+}
+
+type mongoSessions struct{ c *mongo.Collection }
+
+func (r mongoSessions) Create(ctx context.Context, session Session) error {
+	_, err := r.c.InsertOne(ctx, session)
+	return mongoError(err)
+}
+
+func (r mongoSessions) GetActive(ctx context.Context, id primitive.ObjectID) (Session, error) {
+	var session Session
+	err := mongoFindOne(ctx, r.c, bson.M{
+		"_id":        id,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, &session)
+	return session, err
+}
+
+func (r mongoSessions) RotateRefresh(ctx context.Context, id primitive.ObjectID, oldRefreshID, newRefreshID string) (bool, error) {
+	result, err := r.c.UpdateOne(ctx,
+		bson.M{"_id": id, "refresh_id": oldRefreshID},
+		bson.M{"$set": bson.M{"refresh_id": newRefreshID}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (r mongoSessions) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	return r.revoke(ctx, bson.M{"_id": id})
+}
+
+func (r mongoSessions) RevokeAllForUser(ctx context.Context, userID primitive.ObjectID) error {
+	return r.revoke(ctx, bson.M{"user_id": userID})
+}
+
+func (r mongoSessions) revoke(ctx context.Context, filter bson.M) error {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	_, err := r.c.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+type mongoWaitlist struct{ c *mongo.Collection }
+
+func (f WaitlistFilter) bson() bson.M {
+	filter := bson.M{}
+	if !f.RoleID.IsZero() {
+		filter["role_id"] = f.RoleID
+	}
+	if !f.EventID.IsZero() {
+		filter["event_id"] = f.EventID
+	}
+	if !f.TeacherID.IsZero() {
+		filter["teacher_id"] = f.TeacherID
+	}
+	return filter
+}
+
+var mongoByPosition = options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "_id", Value: 1}})
+
+func (r mongoWaitlist) Create(ctx context.Context, entry WaitlistEntry) error {
+	_, err := r.c.InsertOne(ctx, entry)
+	return mongoError(err)
+}
+
+func (r mongoWaitlist) Get(ctx context.Context, id primitive.ObjectID) (WaitlistEntry, error) {
+	var entry WaitlistEntry
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &entry)
+	return entry, err
+}
+
+func (r mongoWaitlist) List(ctx context.Context, filter WaitlistFilter) ([]WaitlistEntry, error) {
+	var entries []WaitlistEntry
+	err := mongoFindAll(ctx, r.c, filter.bson(), &entries, mongoByPosition)
+	return entries, err
+}
+
+func (r mongoWaitlist) Update(ctx context.Context, entry WaitlistEntry) error {
+	return mongoReplace(ctx, r.c, entry.ID, entry)
+}
+
+func (r mongoWaitlist) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return mongoDelete(ctx, r.c, id)
+}
+
+type mongoVolunteerRequests struct{ c *mongo.Collection }
+
+func (f VolunteerRequestFilter) bson() bson.M {
+	filter := bson.M{}
+	if !f.EventID.IsZero() {
+		filter["event_id"] = f.EventID
+	}
+	if !f.RoleID.IsZero() {
+		filter["role_id"] = f.RoleID
+	}
+	if !f.TeacherID.IsZero() {
+		filter["teacher_id"] = f.TeacherID
+	}
+	if f.Status != "" {
+		filter["status"] = f.Status
+	}
+	if f.Decided {
+		filter["$and"] = bson.A{bson.M{"status": bson.M{"$in": bson.A{requestApproved, requestRejected}}}}
+	}
+	return filter
+}
+
+func (r mongoVolunteerRequests) Create(ctx context.Context, request VolunteerRequest) error {
+	_, err := r.c.InsertOne(ctx, request)
+	return mongoError(err)
+}
+
+func (r mongoVolunteerRequests) Get(ctx context.Context, id primitive.ObjectID) (VolunteerRequest, error) {
+	var request VolunteerRequest
+	err := mongoFindOne(ctx, r.c, bson.M{"_id": id}, &request)
+	return request, err
+}
+
+func (r mongoVolunteerRequests) List(ctx context.Context, filter VolunteerRequestFilter) ([]VolunteerRequest, error) {
+	var requests []VolunteerRequest
+	err := mongoFindAll(ctx, r.c, filter.bson(), &requests, mongoByID)
+	return requests, err
+}
+
+func (r mongoVolunteerRequests) Update(ctx context.Context, request VolunteerRequest) error {
+	return mongoReplace(ctx, r.c, request.ID, request)
+}
+
+func (r mongoVolunteerRequests) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return mongoDelete(ctx, r.c, id)
+}
+
+type mongoUserTokens struct{ c *mongo.Collection }
+
+func (r mongoUserTokens) Create(ctx context.Context, token UserToken) error {
+	_, err := r.c.InsertOne(ctx, token)
+	return mongoError(err)
+}
+
+func (r mongoUserTokens) GetActive(ctx context.Context, purpose, hash string) (UserToken, error) {
+	var token UserToken
+	err := mongoFindOne(ctx, r.c, bson.M{
+		"purpose":    purpose,
+		"hash":       hash,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, &token)
+	return token, err
+}
+
+func (r mongoUserTokens) Use(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := r.c.UpdateOne(ctx,
+		bson.M{"_id": id, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (r mongoUserTokens) UseAllForUser(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	_, err := r.c.UpdateMany(ctx,
+		bson.M{"user_id": userID, "purpose": purpose, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	return err
+}
+
+type mongoLoginFailures struct{ c *mongo.Collection }
+
+func (f LoginFailureFilter) bson() bson.M {
+	filter := bson.M{}
+	if f.Email != "" {
+		filter["email"] = f.Email
+	}
+	if f.IP != "" {
+		filter["ip"] = f.IP
+	}
+	if len(f.Reasons) > 0 {
+		filter["reason"] = bson.M{"$in": f.Reasons}
+	}
+	if !f.Since.IsZero() {
+		filter["created_at"] = bson.M{"$gte": f.Since}
+	}
+	if f.Uncleared {
+		filter["cleared_at"] = bson.M{"$exists": false}
+	}
+	return filter
+}
+
+func (r mongoLoginFailures) Create(ctx context.Context, failure LoginFailure) error {
+	_, err := r.c.InsertOne(ctx, failure)
+	return mongoError(err)
+}
+
+func (r mongoLoginFailures) List(ctx context.Context, filter LoginFailureFilter) ([]LoginFailure, error) {
+	var failures []LoginFailure
+	err := mongoFindAll(ctx, r.c, filter.bson(), &failures, mongoByID)
+	return failures, err
+}
+
+func (r mongoLoginFailures) Clear(ctx context.Context, email string) error {
+	_, err := r.c.UpdateMany(ctx,
+		bson.M{"email": email, "cleared_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"cleared_at": time.Now()}},
+	)
+	return err
+}
+
+// migrateEmailVerification marks the accounts created before email
+// verification as verified. It runs before anything rewrites users, since a
+// rewritten user stores email_verified_at even when it is unset.
+func (s *mongoStore) migrateEmailVerification(ctx context.Context) error {
+	_, err := s.db.Collection(userCollection).UpdateMany(ctx,
+		bson.M{"email_verified_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified_at": time.Now()}},
+	)
+	return err
+}
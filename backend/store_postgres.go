@@ -0,0 +1,1268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// postgresMigrationLock is the advisory lock key held while migrating, so
+// two servers starting at once do not apply the same migration twice
+const postgresMigrationLock = 7265321
+
+// postgresTxAttempts bounds how often a transaction is retried after a
+// serialization failure or deadlock
+const postgresTxAttempts = 5
+
+// postgresStore keeps everything in PostgreSQL using the schema in
+// migrations/postgres
+type postgresStore struct {
+	db *sql.DB
+}
+
+func newPostgresStore(ctx context.Context, url string) (*postgresStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// Ping the database to verify connection
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresStore{db: db}, nil
+}
+
+// pgQuerier is satisfied by both *sql.DB and *sql.Tx
+type pgQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// pgRow is satisfied by both *sql.Row and *sql.Rows
+type pgRow interface {
+	Scan(dest ...interface{}) error
+}
+
+type postgresTxKey struct{}
+
+// conn returns the transaction carried by ctx, or the pool outside one
+func (s *postgresStore) conn(ctx context.Context) pgQuerier {
+	if tx, ok := ctx.Value(postgresTxKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+func (s *postgresStore) Users() UserRepository             { return postgresUsers{s} }
+func (s *postgresStore) Teachers() TeacherRepository       { return postgresTeachers{s} }
+func (s *postgresStore) Events() EventRepository           { return postgresEvents{s} }
+func (s *postgresStore) Roles() RoleRepository             { return postgresRoles{s} }
+func (s *postgresStore) Assignments() AssignmentRepository { return postgresAssignments{s} }
+func (s *postgresStore) Departments() DepartmentRepository { return postgresDepartments{s} }
+func (s *postgresStore) Ledger() LedgerRepository          { return postgresLedger{s} }
+func (s *postgresStore) Terms() TermRepository             { return postgresTerms{s} }
+func (s *postgresStore) Sessions() SessionRepository       { return postgresSessions{s} }
+func (s *postgresStore) UserTokens() UserTokenRepository   { return postgresUserTokens{s} }
+func (s *postgresStore) Waitlist() WaitlistRepository      { return postgresWaitlist{s} }
+func (s *postgresStore) VolunteerRequests() VolunteerRequestRepository {
+	return postgresVolunteerRequests{s}
+}
+func (s *postgresStore) LoginFailures() LoginFailureRepository {
+	return postgresLoginFailures{s}
+}
+
+// RunInTransaction runs fn in a database transaction, retrying it after
+// serialization failures and deadlocks. Calls made while already inside a
+// transaction join it.
+func (s *postgresStore) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(postgresTxKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	var err error
+	for attempt := 0; attempt < postgresTxAttempts; attempt++ {
+		err = s.runTransaction(ctx, fn)
+		if !postgresRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *postgresStore) runTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(context.WithValue(ctx, postgresTxKey{}, tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Migrate applies the embedded migrations that have not run yet, each in
+// its own transaction, recording them in schema_migrations, then migrates
+// legacy data that SQL alone cannot convert
+func (s *postgresStore) Migrate(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, postgresMigrationLock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, postgresMigrationLock)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INT PRIMARY KEY,
+		name       VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int]bool)
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	files, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		name := path.Base(file)
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("migration %s: file name must start with a version number", name)
+		}
+		if applied[version] {
+			continue
+		}
+
+		script, err := postgresMigrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, version, name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("applied migration %s", name)
+	}
+
+	if err := migrateEventSchedules(ctx, s); err != nil {
+		return err
+	}
+	if err := migrateDepartments(ctx, s); err != nil {
+		return err
+	}
+	if err := migrateEmails(ctx, s); err != nil {
+		return err
+	}
+	return migrateUserLinks(ctx, s)
+}
+
+func (s *postgresStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+// postgresError maps driver errors onto the Store errors
+func postgresError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// postgresRetryable reports whether a transaction failed only because it
+// lost a race with another one
+func postgresRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01")
+}
+
+// pgID converts an ObjectID to a column value, storing the zero ID as NULL
+func pgID(id primitive.ObjectID) interface{} {
+	if id.IsZero() {
+		return nil
+	}
+	return id.Hex()
+}
+
+// pgTime converts a time to a column value, storing the zero time as NULL
+func pgTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// pgTimeScanner scans a nullable timestamp column into a time.Time
+type pgTimeScanner struct{ dst *time.Time }
+
+func (s pgTimeScanner) Scan(src interface{}) error {
+	var t sql.NullTime
+	if err := t.Scan(src); err != nil {
+		return err
+	}
+	*s.dst = t.Time
+	return nil
+}
+
+func scanTime(dst *time.Time) sql.Scanner {
+	return pgTimeScanner{dst}
+}
+
+// pgIDScanner scans a nullable ID column into an ObjectID
+type pgIDScanner struct{ dst *primitive.ObjectID }
+
+func (s pgIDScanner) Scan(src interface{}) error {
+	var hex string
+	switch v := src.(type) {
+	case nil:
+		*s.dst = primitive.NilObjectID
+		return nil
+	case string:
+		hex = v
+	case []byte:
+		hex = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into an ID", src)
+	}
+
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(hex))
+	if err != nil {
+		return err
+	}
+	*s.dst = id
+	return nil
+}
+
+func scanID(dst *primitive.ObjectID) sql.Scanner {
+	return pgIDScanner{dst}
+}
+
+// pgQueryOne runs a query expected to return a single row
+func pgQueryOne[T any](ctx context.Context, q pgQuerier, scan func(pgRow) (T, error), query string, args ...interface{}) (T, error) {
+	v, err := scan(q.QueryRowContext(ctx, query, args...))
+	return v, postgresError(err)
+}
+
+// pgQueryAll runs a query and scans every row
+func pgQueryAll[T any](ctx context.Context, q pgQuerier, scan func(pgRow) (T, error), query string, args ...interface{}) ([]T, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []T
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// pgExec runs an insert, returning ErrDuplicate on unique violations
+func pgExec(ctx context.Context, q pgQuerier, query string, args ...interface{}) error {
+	_, err := q.ExecContext(ctx, query, args...)
+	return postgresError(err)
+}
+
+// pgExecOne runs an update or delete that must affect exactly one row
+func pgExecOne(ctx context.Context, q pgQuerier, query string, args ...interface{}) error {
+	result, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return postgresError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// pgWhere joins conditions into a WHERE clause
+func pgWhere(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+type postgresUsers struct{ s *postgresStore }
+
+const userColumns = `id, name, email, password, role, user_id, email_verified_at,
+	totp_secret, totp_enabled_at, totp_last_step, recovery_codes`
+
+func scanUser(row pgRow) (User, error) {
+	var u User
+	err := row.Scan(scanID(&u.ID), &u.Name, &u.Email, &u.Password, &u.Role, scanID(&u.UserID), &u.EmailVerifiedAt,
+		&u.TOTPSecret, &u.TOTPEnabledAt, &u.TOTPLastStep, pq.Array(&u.RecoveryCodes))
+	return u, err
+}
+
+func (r postgresUsers) Create(ctx context.Context, user User) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		pgID(user.ID), user.Name, user.Email, user.Password, user.Role, pgID(user.UserID), user.EmailVerifiedAt,
+		user.TOTPSecret, user.TOTPEnabledAt, user.TOTPLastStep, pq.Array(user.RecoveryCodes))
+}
+
+func (r postgresUsers) Get(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanUser,
+		`SELECT `+userColumns+` FROM users WHERE id = $1`, id.Hex())
+}
+
+func (r postgresUsers) GetByEmail(ctx context.Context, email string) (User, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanUser,
+		`SELECT `+userColumns+` FROM users WHERE email = $1`, normalizeEmail(email))
+}
+
+func (r postgresUsers) List(ctx context.Context) ([]User, error) {
+	return pgQueryAll(ctx, r.s.conn(ctx), scanUser,
+		`SELECT `+userColumns+` FROM users ORDER BY id`)
+}
+
+func (r postgresUsers) CountByRole(ctx context.Context, role string) (int, error) {
+	var count int
+	err := r.s.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE role = $1`, role).Scan(&count)
+	return count, err
+}
+
+func (r postgresUsers) Update(ctx context.Context, user User) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE users
+		    SET name = $2, email = $3, password = $4, role = $5, user_id = $6, email_verified_at = $7,
+		        totp_secret = $8, totp_enabled_at = $9, totp_last_step = $10, recovery_codes = $11
+		  WHERE id = $1`,
+		user.ID.Hex(), user.Name, user.Email, user.Password, user.Role, pgID(user.UserID), user.EmailVerifiedAt,
+		user.TOTPSecret, user.TOTPEnabledAt, user.TOTPLastStep, pq.Array(user.RecoveryCodes))
+}
+
+func (r postgresUsers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return pgExecOne(ctx, r.s.conn(ctx), `DELETE FROM users WHERE id = $1`, id.Hex())
+}
+
+type postgresTeachers struct{ s *postgresStore }
+
+const teacherColumns = `id, name, email, department_id, departmentname, profile_photo, point, user_id, deactivated_at`
+
+func scanTeacher(row pgRow) (Teacher, error) {
+	var t Teacher
+	err := row.Scan(scanID(&t.ID), &t.Name, &t.Email, scanID(&t.DepartmentID), &t.Departmentname,
+		&t.ProfilePhoto, &t.Point, scanID(&t.UserID), &t.DeactivatedAt)
+	return t, err
+}
+
+func (f TeacherFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if !f.DepartmentID.IsZero() {
+		args = append(args, f.DepartmentID.Hex())
+		conditions = append(conditions, fmt.Sprintf("department_id = $%d", len(args)))
+	}
+	if f.Active {
+		conditions = append(conditions, "deactivated_at IS NULL")
+	}
+	return pgWhere(conditions), args
+}
+
+func (r postgresTeachers) Create(ctx context.Context, teacher Teacher) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO teachers (`+teacherColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		pgID(teacher.ID), teacher.Name, teacher.Email, pgID(teacher.DepartmentID), teacher.Departmentname,
+		teacher.ProfilePhoto, teacher.Point, pgID(teacher.UserID), teacher.DeactivatedAt)
+}
+
+func (r postgresTeachers) Get(ctx context.Context, id primitive.ObjectID) (Teacher, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanTeacher,
+		`SELECT `+teacherColumns+` FROM teachers WHERE id = $1`, id.Hex())
+}
+
+func (r postgresTeachers) GetByEmail(ctx context.Context, email string) (Teacher, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanTeacher,
+		`SELECT `+teacherColumns+` FROM teachers WHERE email = $1 ORDER BY id LIMIT 1`, normalizeEmail(email))
+}
+
+func (r postgresTeachers) List(ctx context.Context, filter TeacherFilter) ([]Teacher, error) {
+	where, args := filter.where()
+	return pgQueryAll(ctx, r.s.conn(ctx), scanTeacher,
+		`SELECT `+teacherColumns+` FROM teachers`+where+` ORDER BY id`, args...)
+}
+
+func (r postgresTeachers) Update(ctx context.Context, teacher Teacher) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE teachers SET name = $2, email = $3, department_id = $4, departmentname = $5,
+		        profile_photo = $6, point = $7, user_id = $8, deactivated_at = $9
+		  WHERE id = $1`,
+		teacher.ID.Hex(), teacher.Name, teacher.Email, pgID(teacher.DepartmentID), teacher.Departmentname,
+		teacher.ProfilePhoto, teacher.Point, pgID(teacher.UserID), teacher.DeactivatedAt)
+}
+
+func (r postgresTeachers) SetPoints(ctx context.Context, id primitive.ObjectID, points int) error {
+	_, err := r.s.conn(ctx).ExecContext(ctx, `UPDATE teachers SET point = $2 WHERE id = $1`, id.Hex(), points)
+	return err
+}
+
+func (r postgresTeachers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return pgExecOne(ctx, r.s.conn(ctx), `DELETE FROM teachers WHERE id = $1`, id.Hex())
+}
+
+// postgresEvents stores only the event's own columns. Its Roles and
+// Assginedteachers arrays are derived from the roles and teacher_assignments
+// tables when the event is read.
+type postgresEvents struct{ s *postgresStore }
+
+const eventColumns = `id, name, start_date, start_time, end_date, end_time, starts_at, ends_at, all_day,
+	description, term_id, department_id, status, completed_at, cancelled_at, cancelled_by, cancel_policy,
+	cancel_reason`
+
+func scanEvent(row pgRow) (Event, error) {
+	var e Event
+	err := row.Scan(scanID(&e.ID), &e.Name, &e.StartDate, &e.StartTime, &e.EndDate, &e.EndTime,
+		scanTime(&e.StartsAt), scanTime(&e.EndsAt), &e.AllDay, &e.Description, scanID(&e.TermID),
+		scanID(&e.DepartmentID), &e.Status, &e.CompletedAt, &e.CancelledAt, scanID(&e.CancelledBy),
+		&e.CancelPolicy, &e.CancelReason)
+	e.EventID = e.ID
+	return e, err
+}
+
+// attachRefs fills in the roles and assigned teachers of each event
+func (r postgresEvents) attachRefs(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(events))
+	index := make(map[primitive.ObjectID]int, len(events))
+	for i, event := range events {
+		ids[i] = event.ID.Hex()
+		index[event.ID] = i
+	}
+
+	q := r.s.conn(ctx)
+	rows, err := q.QueryContext(ctx,
+		`SELECT event_id, id, name FROM roles WHERE event_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var eventID primitive.ObjectID
+		var ref RoleRef
+		if err := rows.Scan(scanID(&eventID), scanID(&ref.ID), &ref.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		events[index[eventID]].Roles = append(events[index[eventID]].Roles, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = q.QueryContext(ctx,
+		`SELECT a.event_id, a.role_id, a.role_name, t.name, a.id
+		   FROM teacher_assignments a JOIN teachers t ON t.id = a.teacher_id
+		  WHERE a.event_id = ANY($1) ORDER BY a.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID primitive.ObjectID
+		var ref RoleRef1
+		if err := rows.Scan(scanID(&eventID), scanID(&ref.ID), &ref.RoleName, &ref.TeacherleName, scanID(&ref.Assignment_ID)); err != nil {
+			return err
+		}
+		events[index[eventID]].Assginedteachers = append(events[index[eventID]].Assginedteachers, ref)
+	}
+	return rows.Err()
+}
+
+func (r postgresEvents) Create(ctx context.Context, event Event) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO events (`+eventColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
+		pgID(event.ID), event.Name, event.StartDate, event.StartTime, event.EndDate, event.EndTime,
+		pgTime(event.StartsAt), pgTime(event.EndsAt), event.AllDay, event.Description, pgID(event.TermID),
+		pgID(event.DepartmentID), event.Status, event.CompletedAt, event.CancelledAt, pgID(event.CancelledBy),
+		event.CancelPolicy, event.CancelReason)
+}
+
+func (r postgresEvents) Get(ctx context.Context, id primitive.ObjectID) (Event, error) {
+	event, err := pgQueryOne(ctx, r.s.conn(ctx), scanEvent,
+		`SELECT `+eventColumns+` FROM events WHERE id = $1`, id.Hex())
+	if err != nil {
+		return event, err
+	}
+	events := []Event{event}
+	err = r.attachRefs(ctx, events)
+	return events[0], err
+}
+
+// pgLikeEscaper escapes the LIKE wildcards in a search string
+var pgLikeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (f EventFilter) conditions() ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if !f.TermID.IsZero() {
+		add("term_id = $%d", f.TermID.Hex())
+	}
+	if !f.DepartmentID.IsZero() {
+		add("department_id = $%d", f.DepartmentID.Hex())
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.Search != "" {
+		add("(name ILIKE $%[1]d OR description ILIKE $%[1]d)", "%"+pgLikeEscaper.Replace(f.Search)+"%")
+	}
+	if f.Scheduled {
+		conditions = append(conditions, "starts_at IS NOT NULL")
+	}
+	if !f.StartsFrom.IsZero() {
+		add("starts_at >= $%d", f.StartsFrom)
+	}
+	if !f.StartsBefore.IsZero() {
+		add("starts_at < $%d", f.StartsBefore)
+	}
+	if !f.EndsAfter.IsZero() {
+		add("ends_at > $%d", f.EndsAfter)
+	}
+	if !f.EndsBy.IsZero() {
+		add("ends_at <= $%d", f.EndsBy)
+	}
+	return conditions, args
+}
+
+func (r postgresEvents) List(ctx context.Context, filter EventFilter, page EventPage) ([]Event, error) {
+	conditions, args := filter.conditions()
+
+	direction, after := "ASC", ">"
+	if page.Descending {
+		direction, after = "DESC", "<"
+	}
+	var key string
+	var value interface{}
+	switch page.Sort {
+	case eventSortStart:
+		key = "starts_at"
+		if page.After != nil {
+			value = page.After.StartsAt
+		}
+	case eventSortName:
+		key = "name"
+		if page.After != nil {
+			value = page.After.Name
+		}
+	}
+
+	order := "id " + direction
+	if key != "" {
+		order = key + " " + direction + ", " + order
+	}
+	if page.After != nil {
+		if key == "" {
+			args = append(args, page.After.ID.Hex())
+			conditions = append(conditions, fmt.Sprintf("id %s $%d", after, len(args)))
+		} else {
+			args = append(args, value, page.After.ID.Hex())
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", key, after, len(args)-1, len(args)))
+		}
+	}
+	query := `SELECT ` + eventColumns + ` FROM events` + pgWhere(conditions) + ` ORDER BY ` + order
+	if page.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(page.Limit)
+	}
+
+	events, err := pgQueryAll(ctx, r.s.conn(ctx), scanEvent, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return events, r.attachRefs(ctx, events)
+}
+
+func (r postgresEvents) Count(ctx context.Context, filter EventFilter) (int, error) {
+	conditions, args := filter.conditions()
+	var count int
+	err := r.s.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM events`+pgWhere(conditions), args...).Scan(&count)
+	return count, err
+}
+
+func (r postgresEvents) Update(ctx context.Context, event Event) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE events SET name = $2, start_date = $3, start_time = $4, end_date = $5, end_time = $6,
+		        starts_at = $7, ends_at = $8, all_day = $9, description = $10, term_id = $11,
+		        department_id = $12, status = $13, completed_at = $14, cancelled_at = $15, cancelled_by = $16,
+		        cancel_policy = $17, cancel_reason = $18
+		  WHERE id = $1`,
+		event.ID.Hex(), event.Name, event.StartDate, event.StartTime, event.EndDate, event.EndTime,
+		pgTime(event.StartsAt), pgTime(event.EndsAt), event.AllDay, event.Description, pgID(event.TermID),
+		pgID(event.DepartmentID), event.Status, event.CompletedAt, event.CancelledAt, pgID(event.CancelledBy),
+		event.CancelPolicy, event.CancelReason)
+}
+
+func (r postgresEvents) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return pgExecOne(ctx, r.s.conn(ctx), `DELETE FROM events WHERE id = $1`, id.Hex())
+}
+
+type postgresRoles struct{ s *postgresStore }
+
+const roleColumns = `id, event_id, event_name, name, point, head_count`
+
+func scanRole(row pgRow) (Role, error) {
+	var role Role
+	err := row.Scan(scanID(&role.ID), scanID(&role.EventID), &role.EventName, &role.Name, &role.Point, &role.HeadCount)
+	role.RoleID = role.ID
+	return role, err
+}
+
+func (r postgresRoles) Create(ctx context.Context, role Role) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO roles (`+roleColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		pgID(role.ID), pgID(role.EventID), role.EventName, role.Name, role.Point, role.HeadCount)
+}
+
+func (r postgresRoles) Get(ctx context.Context, id primitive.ObjectID) (Role, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanRole,
+		`SELECT `+roleColumns+` FROM roles WHERE id = $1`, id.Hex())
+}
+
+// GetForUpdate takes a row lock on the role for the rest of the transaction
+func (r postgresRoles) GetForUpdate(ctx context.Context, id primitive.ObjectID) (Role, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanRole,
+		`SELECT `+roleColumns+` FROM roles WHERE id = $1 FOR UPDATE`, id.Hex())
+}
+
+func (r postgresRoles) List(ctx context.Context) ([]Role, error) {
+	return pgQueryAll(ctx, r.s.conn(ctx), scanRole,
+		`SELECT `+roleColumns+` FROM roles ORDER BY id`)
+}
+
+func (r postgresRoles) ListByEvent(ctx context.Context, eventID primitive.ObjectID) ([]Role, error) {
+	return pgQueryAll(ctx, r.s.conn(ctx), scanRole,
+		`SELECT `+roleColumns+` FROM roles WHERE event_id = $1 ORDER BY id`, eventID.Hex())
+}
+
+func (r postgresRoles) Update(ctx context.Context, role Role) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE roles SET event_id = $2, event_name = $3, name = $4, point = $5, head_count = $6 WHERE id = $1`,
+		role.ID.Hex(), pgID(role.EventID), role.EventName, role.Name, role.Point, role.HeadCount)
+}
+
+func (r postgresRoles) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return pgExecOne(ctx, r.s.conn(ctx), `DELETE FROM roles WHERE id = $1`, id.Hex())
+}
+
+type postgresAssignments struct{ s *postgresStore }
+
+const assignmentColumns = `id, event_id, event_name, teacher_id, role_id, role_name, points_status, attendance,
+	attendance_at, attendance_by, checked_in_at, self_signup, awarded_points`
+
+func scanAssignment(row pgRow) (Assignment, error) {
+	var a Assignment
+	err := row.Scan(scanID(&a.ID), scanID(&a.EventID), &a.EventName, scanID(&a.TeacherID), scanID(&a.RoleID), &a.RoletName, &a.PointsStatus,
+		&a.Attendance, &a.AttendanceAt, scanID(&a.AttendanceBy), &a.CheckedInAt, &a.SelfSignup, &a.AwardedPoints)
+	return a, err
+}
+
+func (f AssignmentFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(column string, id primitive.ObjectID) {
+		if !id.IsZero() {
+			args = append(args, id.Hex())
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	add("teacher_id", f.TeacherID)
+	add("role_id", f.RoleID)
+	add("event_id", f.EventID)
+	if f.Attendance != "" {
+		args = append(args, f.Attendance)
+		conditions = append(conditions, fmt.Sprintf("attendance = $%d", len(args)))
+	}
+	return pgWhere(conditions), args
+}
+
+func (r postgresAssignments) Create(ctx context.Context, assignment Assignment) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO teacher_assignments (`+assignmentColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		pgID(assignment.ID), pgID(assignment.EventID), assignment.EventName,
+		pgID(assignment.TeacherID), pgID(assignment.RoleID), assignment.RoletName, assignment.PointsStatus,
+		assignment.Attendance, assignment.AttendanceAt, pgID(assignment.AttendanceBy), assignment.CheckedInAt,
+		assignment.SelfSignup, assignment.AwardedPoints)
+}
+
+func (r postgresAssignments) Get(ctx context.Context, id primitive.ObjectID) (Assignment, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanAssignment,
+		`SELECT `+assignmentColumns+` FROM teacher_assignments WHERE id = $1`, id.Hex())
+}
+
+func (r postgresAssignments) List(ctx context.Context, filter AssignmentFilter) ([]Assignment, error) {
+	where, args := filter.where()
+	return pgQueryAll(ctx, r.s.conn(ctx), scanAssignment,
+		`SELECT `+assignmentColumns+` FROM teacher_assignments`+where+` ORDER BY id`, args...)
+}
+
+func (r postgresAssignments) Count(ctx context.Context, filter AssignmentFilter) (int, error) {
+	where, args := filter.where()
+	var count int
+	err := r.s.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM teacher_assignments`+where, args...).Scan(&count)
+	return count, err
+}
+
+func (r postgresAssignments) Update(ctx context.Context, assignment Assignment) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE teacher_assignments
+		    SET event_name = $2, role_name = $3, points_status = $4, attendance = $5, attendance_at = $6,
+		        attendance_by = $7, checked_in_at = $8, awarded_points = $9
+		  WHERE id = $1`,
+		assignment.ID.Hex(), assignment.EventName, assignment.RoletName, assignment.PointsStatus,
+		assignment.Attendance, assignment.AttendanceAt, pgID(assignment.AttendanceBy), assignment.CheckedInAt,
+		assignment.AwardedPoints)
+}
+
+func (r postgresAssignments) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return pgExecOne(ctx, r.s.conn(ctx), `DELETE FROM teacher_assignments WHERE id = $1`, id.Hex())
+}
+
+type postgresDepartments struct{ s *postgresStore }
+
+func scanDepartment(row pgRow) (Department, error) {
+	var d Department
+	err := row.Scan(scanID(&d.ID), &d.Name)
+	return d, err
+}
+
+func (r postgresDepartments) Create(ctx context.Context, department Department) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO departments (id, department_name) VALUES ($1, $2)`,
+		pgID(department.ID), department.Name)
+}
+
+func (r postgresDepartments) Get(ctx context.Context, id primitive.ObjectID) (Department, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanDepartment,
+		`SELECT id, department_name FROM departments WHERE id = $1`, id.Hex())
+}
+
+func (r postgresDepartments) GetByName(ctx context.Context, name string) (Department, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanDepartment,
+		`SELECT id, department_name FROM departments WHERE department_name = $1`, name)
+}
+
+func (r postgresDepartments) List(ctx context.Context) ([]Department, error) {
+	return pgQueryAll(ctx, r.s.conn(ctx), scanDepartment,
+		`SELECT id, department_name FROM departments ORDER BY department_name`)
+}
+
+func (r postgresDepartments) Update(ctx context.Context, department Department) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE departments SET department_name = $2 WHERE id = $1`,
+		department.ID.Hex(), department.Name)
+}
+
+func (r postgresDepartments) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return pgExecOne(ctx, r.s.conn(ctx), `DELETE FROM departments WHERE id = $1`, id.Hex())
+}
+
+type postgresLedger struct{ s *postgresStore }
+
+const ledgerColumns = `id, teacher_id, type, points, event_id, event_name, role_id, role_name,
+	assignment_id, reverses_id, term_id, actor_id, reason, created_at`
+
+func scanLedgerEntry(row pgRow) (LedgerEntry, error) {
+	var e LedgerEntry
+	err := row.Scan(scanID(&e.ID), scanID(&e.TeacherID), &e.Type, &e.Points,
+		scanID(&e.EventID), &e.EventName, scanID(&e.RoleID), &e.RoleName,
+		scanID(&e.AssignmentID), scanID(&e.ReversesID), scanID(&e.TermID), scanID(&e.ActorID), &e.Reason, &e.CreatedAt)
+	return e, err
+}
+
+func (f LedgerFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(column string, id primitive.ObjectID) {
+		if !id.IsZero() {
+			args = append(args, id.Hex())
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	add("teacher_id", f.TeacherID)
+	add("assignment_id", f.AssignmentID)
+	add("term_id", f.TermID)
+	return pgWhere(conditions), args
+}
+
+func (r postgresLedger) Append(ctx context.Context, entries ...LedgerEntry) error {
+	q := r.s.conn(ctx)
+	for _, e := range entries {
+		err := pgExec(ctx, q,
+			`INSERT INTO points_ledger (`+ledgerColumns+`)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+			pgID(e.ID), pgID(e.TeacherID), e.Type, e.Points,
+			pgID(e.EventID), e.EventName, pgID(e.RoleID), e.RoleName,
+			pgID(e.AssignmentID), pgID(e.ReversesID), pgID(e.TermID), pgID(e.ActorID), e.Reason, e.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r postgresLedger) Get(ctx context.Context, id primitive.ObjectID) (LedgerEntry, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanLedgerEntry,
+		`SELECT `+ledgerColumns+` FROM points_ledger WHERE id = $1`, id.Hex())
+}
+
+func (r postgresLedger) List(ctx context.Context, filter LedgerFilter) ([]LedgerEntry, error) {
+	where, args := filter.where()
+	return pgQueryAll(ctx, r.s.conn(ctx), scanLedgerEntry,
+		`SELECT `+ledgerColumns+` FROM points_ledger`+where+` ORDER BY created_at, id`, args...)
+}
+
+func (r postgresLedger) Sum(ctx context.Context, filter LedgerFilter) (int, error) {
+	where, args := filter.where()
+	var total int
+	err := r.s.conn(ctx).QueryRowContext(ctx, `SELECT COALESCE(SUM(points), 0) FROM points_ledger`+where, args...).Scan(&total)
+	return total, err
+}
+
+func (r postgresLedger) Balances(ctx context.Context, filter LedgerFilter) (map[primitive.ObjectID]int, error) {
+	type balance struct {
+		teacherID primitive.ObjectID
+		total     int
+	}
+	where, args := filter.where()
+	rows, err := pgQueryAll(ctx, r.s.conn(ctx), func(row pgRow) (balance, error) {
+		var b balance
+		err := row.Scan(scanID(&b.teacherID), &b.total)
+		return b, err
+	}, `SELECT teacher_id, SUM(points) FROM points_ledger`+where+` GROUP BY teacher_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[primitive.ObjectID]int, len(rows))
+	for _, b := range rows {
+		balances[b.teacherID] = b.total
+	}
+	return balances, nil
+}
+
+type postgresTerms struct{ s *postgresStore }
+
+const termColumns = `id, name, start_date, end_date, status, closed_at, closed_by`
+
+func scanTerm(row pgRow) (Term, error) {
+	var t Term
+	var start, end time.Time
+	var closedAt sql.NullTime
+	err := row.Scan(scanID(&t.ID), &t.Name, &start, &end, &t.Status, &closedAt, scanID(&t.ClosedBy))
+	t.StartDate = start.Format(termDateLayout)
+	t.EndDate = end.Format(termDateLayout)
+	if closedAt.Valid {
+		t.ClosedAt = &closedAt.Time
+	}
+	return t, err
+}
+
+func (r postgresTerms) Create(ctx context.Context, term Term) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO terms (`+termColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		pgID(term.ID), term.Name, term.StartDate, term.EndDate, term.Status, term.ClosedAt, pgID(term.ClosedBy))
+}
+
+func (r postgresTerms) Get(ctx context.Context, id primitive.ObjectID) (Term, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanTerm,
+		`SELECT `+termColumns+` FROM terms WHERE id = $1`, id.Hex())
+}
+
+func (r postgresTerms) List(ctx context.Context) ([]Term, error) {
+	return pgQueryAll(ctx, r.s.conn(ctx), scanTerm,
+		`SELECT `+termColumns+` FROM terms ORDER BY start_date, id`)
+}
+
+func (r postgresTerms) Update(ctx context.Context, term Term) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE terms SET name = $2, start_date = $3, end_date = $4, status = $5, closed_at = $6, closed_by = $7 WHERE id = $1`,
+		term.ID.Hex(), term.Name, term.StartDate, term.EndDate, term.Status, term.ClosedAt, pgID(term.ClosedBy))
+}
+
+const termStandingColumns = `id, term_id, rank, department_rank, teacher_id, teacher_name,
+	department_id, department_name, points, created_at`
+
+func scanTermStanding(row pgRow) (TermStanding, error) {
+	var t TermStanding
+	err := row.Scan(scanID(&t.ID), scanID(&t.TermID), &t.Rank, &t.DepartmentRank, scanID(&t.TeacherID), &t.TeacherName,
+		scanID(&t.DepartmentID), &t.DepartmentName, &t.Points, &t.CreatedAt)
+	return t, err
+}
+
+// ArchiveStandings locks the term row so that two concurrent archives of the
+// same term cannot both see it without standings
+func (r postgresTerms) ArchiveStandings(ctx context.Context, termID primitive.ObjectID, standings []TermStanding) error {
+	q := r.s.conn(ctx)
+	var archived bool
+	err := q.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM term_standings WHERE term_id = t.id) FROM terms t WHERE t.id = $1 FOR UPDATE`,
+		termID.Hex()).Scan(&archived)
+	if err != nil {
+		return postgresError(err)
+	}
+	if archived {
+		return ErrDuplicate
+	}
+
+	for _, t := range standings {
+		err := pgExec(ctx, q,
+			`INSERT INTO term_standings (`+termStandingColumns+`)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			pgID(t.ID), pgID(t.TermID), t.Rank, t.DepartmentRank, pgID(t.TeacherID), t.TeacherName,
+			pgID(t.DepartmentID), t.DepartmentName, t.Points, t.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r postgresTerms) Standings(ctx context.Context, termID primitive.ObjectID) ([]TermStanding, error) {
+	return pgQueryAll(ctx, r.s.conn(ctx), scanTermStanding,
+		`SELECT `+termStandingColumns+` FROM term_standings WHERE term_id = $1 ORDER BY rank, teacher_name`, termID.Hex())
+}
+
+type postgresSessions struct{ s *postgresStore }
+
+func scanSession(row pgRow) (Session, error) {
+	var s Session
+	var revokedAt sql.NullTime
+	err := row.Scan(scanID(&s.ID), scanID(&s.UserID), &s.RefreshID, &s.CreatedAt, &s.ExpiresAt, &revokedAt)
+	if revokedAt.Valid {
+		s.RevokedAt = &revokedAt.Time
+	}
+	return s, err
+}
+
+func (r postgresSessions) Create(ctx context.Context, session Session) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO sessions (id, user_id, refresh_id, created_at, expires_at, revoked_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		pgID(session.ID), pgID(session.UserID), session.RefreshID, session.CreatedAt, session.ExpiresAt, session.RevokedAt)
+}
+
+func (r postgresSessions) GetActive(ctx context.Context, id primitive.ObjectID) (Session, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanSession,
+		`SELECT id, user_id, refresh_id, created_at, expires_at, revoked_at FROM sessions
+		  WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2`, id.Hex(), time.Now())
+}
+
+func (r postgresSessions) RotateRefresh(ctx context.Context, id primitive.ObjectID, oldRefreshID, newRefreshID string) (bool, error) {
+	result, err := r.s.conn(ctx).ExecContext(ctx,
+		`UPDATE sessions SET refresh_id = $3 WHERE id = $1 AND refresh_id = $2`,
+		id.Hex(), oldRefreshID, newRefreshID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (r postgresSessions) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.s.conn(ctx).ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`, id.Hex(), time.Now())
+	return err
+}
+
+func (r postgresSessions) RevokeAllForUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.s.conn(ctx).ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`, userID.Hex(), time.Now())
+	return err
+}
+
+type postgresWaitlist struct{ s *postgresStore }
+
+const waitlistColumns = `id, role_id, event_id, teacher_id, teacher_name, position, self_signup, created_at`
+
+func scanWaitlistEntry(row pgRow) (WaitlistEntry, error) {
+	var e WaitlistEntry
+	err := row.Scan(scanID(&e.ID), scanID(&e.RoleID), scanID(&e.EventID), scanID(&e.TeacherID), &e.TeacherName,
+		&e.Position, &e.SelfSignup, &e.CreatedAt)
+	return e, err
+}
+
+func (f WaitlistFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(column string, id primitive.ObjectID) {
+		if !id.IsZero() {
+			args = append(args, id.Hex())
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	add("role_id", f.RoleID)
+	add("event_id", f.EventID)
+	add("teacher_id", f.TeacherID)
+	return pgWhere(conditions), args
+}
+
+func (r postgresWaitlist) Create(ctx context.Context, entry WaitlistEntry) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO role_waitlist (`+waitlistColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		pgID(entry.ID), pgID(entry.RoleID), pgID(entry.EventID), pgID(entry.TeacherID), entry.TeacherName,
+		entry.Position, entry.SelfSignup, entry.CreatedAt)
+}
+
+func (r postgresWaitlist) Get(ctx context.Context, id primitive.ObjectID) (WaitlistEntry, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanWaitlistEntry,
+		`SELECT `+waitlistColumns+` FROM role_waitlist WHERE id = $1`, id.Hex())
+}
+
+func (r postgresWaitlist) List(ctx context.Context, filter WaitlistFilter) ([]WaitlistEntry, error) {
+	where, args := filter.where()
+	return pgQueryAll(ctx, r.s.conn(ctx), scanWaitlistEntry,
+		`SELECT `+waitlistColumns+` FROM role_waitlist`+where+` ORDER BY position, id`, args...)
+}
+
+func (r postgresWaitlist) Update(ctx context.Context, entry WaitlistEntry) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE role_waitlist SET teacher_name = $2, position = $3 WHERE id = $1`,
+		entry.ID.Hex(), entry.TeacherName, entry.Position)
+}
+
+func (r postgresWaitlist) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return pgExecOne(ctx, r.s.conn(ctx), `DELETE FROM role_waitlist WHERE id = $1`, id.Hex())
+}
+
+type postgresVolunteerRequests struct{ s *postgresStore }
+
+const volunteerRequestColumns = `id, event_id, event_name, role_id, role_name, points, teacher_id, teacher_name, status,
+	created_at, decided_at, decided_by, reason, assignment_id`
+
+func scanVolunteerRequest(row pgRow) (VolunteerRequest, error) {
+	var r VolunteerRequest
+	err := row.Scan(scanID(&r.ID), scanID(&r.EventID), &r.EventName, scanID(&r.RoleID), &r.RoleName, &r.Points,
+		scanID(&r.TeacherID), &r.TeacherName, &r.Status, &r.CreatedAt, &r.DecidedAt, scanID(&r.DecidedBy), &r.Reason,
+		scanID(&r.AssignmentID))
+	return r, err
+}
+
+func (f VolunteerRequestFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if !f.EventID.IsZero() {
+		add("event_id", f.EventID.Hex())
+	}
+	if !f.RoleID.IsZero() {
+		add("role_id", f.RoleID.Hex())
+	}
+	if !f.TeacherID.IsZero() {
+		add("teacher_id", f.TeacherID.Hex())
+	}
+	if f.Status != "" {
+		add("status", f.Status)
+	}
+	if f.Decided {
+		conditions = append(conditions, fmt.Sprintf("status IN ('%s', '%s')", requestApproved, requestRejected))
+	}
+	return pgWhere(conditions), args
+}
+
+func (r postgresVolunteerRequests) Create(ctx context.Context, request VolunteerRequest) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO volunteer_requests (`+volunteerRequestColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		pgID(request.ID), pgID(request.EventID), request.EventName, pgID(request.RoleID), request.RoleName,
+		request.Points, pgID(request.TeacherID), request.TeacherName, request.Status, request.CreatedAt,
+		request.DecidedAt, pgID(request.DecidedBy), request.Reason, pgID(request.AssignmentID))
+}
+
+func (r postgresVolunteerRequests) Get(ctx context.Context, id primitive.ObjectID) (VolunteerRequest, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanVolunteerRequest,
+		`SELECT `+volunteerRequestColumns+` FROM volunteer_requests WHERE id = $1`, id.Hex())
+}
+
+func (r postgresVolunteerRequests) List(ctx context.Context, filter VolunteerRequestFilter) ([]VolunteerRequest, error) {
+	where, args := filter.where()
+	return pgQueryAll(ctx, r.s.conn(ctx), scanVolunteerRequest,
+		`SELECT `+volunteerRequestColumns+` FROM volunteer_requests`+where+` ORDER BY id`, args...)
+}
+
+func (r postgresVolunteerRequests) Update(ctx context.Context, request VolunteerRequest) error {
+	return pgExecOne(ctx, r.s.conn(ctx),
+		`UPDATE volunteer_requests
+		    SET status = $2, decided_at = $3, decided_by = $4, reason = $5, assignment_id = $6
+		  WHERE id = $1`,
+		request.ID.Hex(), request.Status, request.DecidedAt, pgID(request.DecidedBy), request.Reason,
+		pgID(request.AssignmentID))
+}
+
+func (r postgresVolunteerRequests) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return pgExecOne(ctx, r.s.conn(ctx), `DELETE FROM volunteer_requests WHERE id = $1`, id.Hex())
+}
+
+type postgresUserTokens struct{ s *postgresStore }
+
+const userTokenColumns = `id, user_id, purpose, hash, created_at, expires_at, used_at`
+
+func scanUserToken(row pgRow) (UserToken, error) {
+	var t UserToken
+	err := row.Scan(scanID(&t.ID), scanID(&t.UserID), &t.Purpose, &t.Hash, &t.CreatedAt, &t.ExpiresAt, &t.UsedAt)
+	return t, err
+}
+
+func (r postgresUserTokens) Create(ctx context.Context, token UserToken) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO user_tokens (`+userTokenColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		pgID(token.ID), pgID(token.UserID), token.Purpose, token.Hash, token.CreatedAt, token.ExpiresAt, token.UsedAt)
+}
+
+func (r postgresUserTokens) GetActive(ctx context.Context, purpose, hash string) (UserToken, error) {
+	return pgQueryOne(ctx, r.s.conn(ctx), scanUserToken,
+		`SELECT `+userTokenColumns+` FROM user_tokens
+		  WHERE purpose = $1 AND hash = $2 AND used_at IS NULL AND expires_at > $3`, purpose, hash, time.Now())
+}
+
+func (r postgresUserTokens) Use(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := r.s.conn(ctx).ExecContext(ctx,
+		`UPDATE user_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL`, id.Hex(), time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (r postgresUserTokens) UseAllForUser(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	_, err := r.s.conn(ctx).ExecContext(ctx,
+		`UPDATE user_tokens SET used_at = $3 WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		userID.Hex(), purpose, time.Now())
+	return err
+}
+
+type postgresLoginFailures struct{ s *postgresStore }
+
+const loginFailureColumns = `id, email, user_id, ip, reason, created_at, cleared_at`
+
+func scanLoginFailure(row pgRow) (LoginFailure, error) {
+	var l LoginFailure
+	err := row.Scan(scanID(&l.ID), &l.Email, scanID(&l.UserID), &l.IP, &l.Reason, &l.CreatedAt, &l.ClearedAt)
+	return l, err
+}
+
+func (f LoginFailureFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if f.Email != "" {
+		add("email = $%d", f.Email)
+	}
+	if f.IP != "" {
+		add("ip = $%d", f.IP)
+	}
+	if len(f.Reasons) > 0 {
+		add("reason = ANY($%d)", pq.Array(f.Reasons))
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if f.Uncleared {
+		conditions = append(conditions, "cleared_at IS NULL")
+	}
+	return pgWhere(conditions), args
+}
+
+func (r postgresLoginFailures) Create(ctx context.Context, failure LoginFailure) error {
+	return pgExec(ctx, r.s.conn(ctx),
+		`INSERT INTO login_failures (`+loginFailureColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		pgID(failure.ID), failure.Email, pgID(failure.UserID), failure.IP, failure.Reason, failure.CreatedAt,
+		failure.ClearedAt)
+}
+
+func (r postgresLoginFailures) List(ctx context.Context, filter LoginFailureFilter) ([]LoginFailure, error) {
+	where, args := filter.where()
+	return pgQueryAll(ctx, r.s.conn(ctx), scanLoginFailure,
+		`SELECT `+loginFailureColumns+` FROM login_failures`+where+` ORDER BY id`, args...)
+}
+
+func (r postgresLoginFailures) Clear(ctx context.Context, email string) error {
+	_, err := r.s.conn(ctx).ExecContext(ctx,
+		`UPDATE login_failures SET cleared_at = $2 WHERE email = $1 AND cleared_at IS NULL`, email, time.Now())
+	return err
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errTeacherDeactivated is returned when staffing a role with a teacher who
+// has left
+var errTeacherDeactivated = &requestError{http.StatusBadRequest, "Teacher has been deactivated"}
+
+// teacherUsers returns the user accounts that name a teacher as theirs.
+// Normally that is only the linked account, but links that were never
+// completed are included so that nothing is left pointing at the teacher.
+func teacherUsers(ctx context.Context, teacher Teacher) ([]User, error) {
+	users, err := store.Users().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var linked []User
+	for _, user := range users {
+		if user.UserID == teacher.ID {
+			linked = append(linked, user)
+		}
+	}
+	return linked, nil
+}
+
+// loadTeacher loads a teacher inside a transaction
+func loadTeacher(ctx context.Context, id primitive.ObjectID) (Teacher, error) {
+	teacher, err := store.Teachers().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return teacher, &requestError{http.StatusNotFound, "Teacher not found"}
+	}
+	return teacher, err
+}
+
+// removeTeacherAssignment deletes one of a teacher's assignments and hands
+// the freed place to the role's waitlist. Assignments on completed or
+// cancelled events are a record of what happened and are kept; it reports
+// whether the assignment was removed.
+func removeTeacherAssignment(ctx context.Context, assignment Assignment) (bool, error) {
+	event, err := store.Events().Get(ctx, assignment.EventID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if err == nil {
+		if event.Status == eventCompleted || event.Status == eventCancelled {
+			return false, nil
+		}
+		removeAssignmentRef(&event, assignment.ID)
+		if err := store.Events().Update(ctx, event); err != nil {
+			return false, err
+		}
+	}
+	if err := store.Assignments().Delete(ctx, assignment.ID); err != nil {
+		return false, err
+	}
+	_, err = promoteWaitlist(ctx, assignment.RoleID)
+	return err == nil, err
+}
+
+// renameTeacherAssignments copies a teacher's new name to the events they
+// are assigned to, which keep the names of their assigned teachers
+func renameTeacherAssignments(ctx context.Context, teacher Teacher) error {
+	assignments, err := store.Assignments().List(ctx, AssignmentFilter{TeacherID: teacher.ID})
+	if err != nil {
+		return err
+	}
+	byEvent := make(map[primitive.ObjectID]map[primitive.ObjectID]bool)
+	for _, assignment := range assignments {
+		if byEvent[assignment.EventID] == nil {
+			byEvent[assignment.EventID] = make(map[primitive.ObjectID]bool)
+		}
+		byEvent[assignment.EventID][assignment.ID] = true
+	}
+	for eventID, assignmentIDs := range byEvent {
+		event, err := store.Events().Get(ctx, eventID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for i := range event.Assginedteachers {
+			if assignmentIDs[event.Assginedteachers[i].Assignment_ID] {
+				event.Assginedteachers[i].TeacherleName = teacher.Name
+			}
+		}
+		if err := store.Events().Update(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateTeacher replaces a teacher's name, email, department and photo. The
+// department is given by department_id or departmentname as in
+// CreateTeacher; leaving both out clears it. The email is required and
+// normalized. Accounts linked to the teacher stay linked when it changes.
+func UpdateTeacher(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+		return
+	}
+
+	var req Teacher
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	req.Email = normalizeEmail(req.Email)
+	if req.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+	if !validEmail(req.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is not a valid address"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var teacher Teacher
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		teacher, err = loadTeacher(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if req.Email != teacher.Email {
+			other, err := store.Teachers().GetByEmail(ctx, req.Email)
+			if err == nil && other.ID != teacher.ID {
+				return &requestError{http.StatusConflict, "Another teacher already uses this email"}
+			}
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+
+		renamed := req.Name != teacher.Name
+		teacher.Name = req.Name
+		teacher.Email = req.Email
+		teacher.ProfilePhoto = req.ProfilePhoto
+		teacher.DepartmentID = req.DepartmentID
+		teacher.Departmentname = req.Departmentname
+		if err := resolveTeacherDepartment(ctx, &teacher); err != nil {
+			return err
+		}
+		if teacher.DepartmentID.IsZero() {
+			teacher.Departmentname = ""
+		}
+		if err := store.Teachers().Update(ctx, teacher); err != nil {
+			return err
+		}
+		if !renamed {
+			return nil
+		}
+		return renameTeacherAssignments(ctx, teacher)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to update teacher")
+		return
+	}
+
+	c.JSON(http.StatusOK, teacher)
+}
+
+// DeactivateTeacher marks a teacher who has left. They disappear from
+// teacher lists and leaderboards and can no longer be assigned, but their
+// assignments and points are kept. Their places on published events that
+// have not started are given up to the waitlist, they leave every waitlist,
+// and their pending volunteer requests are rejected.
+func DeactivateTeacher(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	var teacher Teacher
+	var dropped int
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		dropped = 0
+		var err error
+		teacher, err = loadTeacher(ctx, id)
+		if err != nil {
+			return err
+		}
+		if teacher.DeactivatedAt != nil {
+			return &requestError{http.StatusConflict, "Teacher is already deactivated"}
+		}
+		now := time.Now()
+
+		entries, err := store.Waitlist().List(ctx, WaitlistFilter{TeacherID: teacher.ID})
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := store.Waitlist().Delete(ctx, entry.ID); err != nil {
+				return err
+			}
+		}
+
+		requests, err := store.VolunteerRequests().List(ctx, VolunteerRequestFilter{TeacherID: teacher.ID, Status: requestPending})
+		if err != nil {
+			return err
+		}
+		for _, request := range requests {
+			request.Status = requestRejected
+			request.DecidedAt = &now
+			request.DecidedBy = actor.ID
+			request.Reason = "Teacher deactivated"
+			if err := store.VolunteerRequests().Update(ctx, request); err != nil {
+				return err
+			}
+		}
+
+		// Mark the teacher first so waitlist promotions cannot pick them
+		teacher.DeactivatedAt = &now
+		if err := store.Teachers().Update(ctx, teacher); err != nil {
+			return err
+		}
+
+		assignments, err := store.Assignments().List(ctx, AssignmentFilter{TeacherID: teacher.ID})
+		if err != nil {
+			return err
+		}
+		for _, assignment := range assignments {
+			event, err := store.Events().Get(ctx, assignment.EventID)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if event.Status != eventPublished || !event.StartsAt.After(now) {
+				continue
+			}
+			ok, err := removeTeacherAssignment(ctx, assignment)
+			if err != nil {
+				return err
+			}
+			if ok {
+				dropped++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to deactivate teacher")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"teacher": teacher,
+		"dropped": dropped,
+	})
+}
+
+// ReactivateTeacher reverses DeactivateTeacher. Places given up on
+// deactivation are not restored.
+func ReactivateTeacher(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var teacher Teacher
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		teacher, err = loadTeacher(ctx, id)
+		if err != nil {
+			return err
+		}
+		if teacher.DeactivatedAt == nil {
+			return &requestError{http.StatusConflict, "Teacher is not deactivated"}
+		}
+		teacher.DeactivatedAt = nil
+		return store.Teachers().Update(ctx, teacher)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to reactivate teacher")
+		return
+	}
+
+	c.JSON(http.StatusOK, teacher)
+}
+
+// DeleteTeacher permanently removes a teacher, for records created by
+// mistake. Teachers with points history or assignments on completed or
+// cancelled events must be deactivated instead, since those records are
+// kept. Their other assignments, waitlist entries and volunteer requests
+// are deleted. Linked user accounts are unlinked, or deleted with
+// ?delete_user=true unless they belong to an admin.
+func DeleteTeacher(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid teacher ID format"})
+		return
+	}
+	deleteUser := c.Query("delete_user") == "true"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var teacher Teacher
+	var removed, usersDeleted, usersUnlinked int
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		removed, usersDeleted, usersUnlinked = 0, 0, 0
+		var err error
+		teacher, err = loadTeacher(ctx, id)
+		if err != nil {
+			return err
+		}
+		entries, err := store.Ledger().List(ctx, LedgerFilter{TeacherID: teacher.ID})
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &requestError{http.StatusConflict, "Teacher has points history; deactivate them instead"}
+		}
+
+		users, err := teacherUsers(ctx, teacher)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if !deleteUser {
+				user.UserID = primitive.NilObjectID
+				if err := store.Users().Update(ctx, user); err != nil {
+					return err
+				}
+				usersUnlinked++
+				continue
+			}
+			if user.Role == userRoleAdmin {
+				return &requestError{http.StatusConflict, "The linked account " + user.Email + " belongs to an admin; change its role before deleting it"}
+			}
+			if err := store.Sessions().RevokeAllForUser(ctx, user.ID); err != nil {
+				return err
+			}
+			if err := store.Users().Delete(ctx, user.ID); err != nil {
+				return err
+			}
+			usersDeleted++
+		}
+
+		// Remove the teacher from waitlists first so freed places are not
+		// promoted back to them
+		waitlist, err := store.Waitlist().List(ctx, WaitlistFilter{TeacherID: teacher.ID})
+		if err != nil {
+			return err
+		}
+		for _, entry := range waitlist {
+			if err := store.Waitlist().Delete(ctx, entry.ID); err != nil {
+				return err
+			}
+		}
+		requests, err := store.VolunteerRequests().List(ctx, VolunteerRequestFilter{TeacherID: teacher.ID})
+		if err != nil {
+			return err
+		}
+		for _, request := range requests {
+			if err := store.VolunteerRequests().Delete(ctx, request.ID); err != nil {
+				return err
+			}
+		}
+
+		assignments, err := store.Assignments().List(ctx, AssignmentFilter{TeacherID: teacher.ID})
+		if err != nil {
+			return err
+		}
+		for _, assignment := range assignments {
+			ok, err := removeTeacherAssignment(ctx, assignment)
+			if err != nil {
+				return err
+			}
+			// Deleting the teacher would take the kept assignment with it
+			if !ok {
+				return &requestError{http.StatusConflict, "Teacher has assignments on completed or cancelled events; deactivate them instead"}
+			}
+			removed++
+		}
+		return store.Teachers().Delete(ctx, teacher.ID)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to delete teacher")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Teacher deleted successfully",
+		"teacher_name":        teacher.Name,
+		"assignments_removed": removed,
+		"users_deleted":       usersDeleted,
+		"users_unlinked":      usersUnlinked,
+	})
+}
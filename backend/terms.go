@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Term statuses. Points can only be booked in open terms.
+const (
+	termOpen   = "open"
+	termClosed = "closed"
+)
+
+// termDateLayout is the format of Term.StartDate and Term.EndDate
+const termDateLayout = "2006-01-02"
+
+// termScopeAll is the term_id query value that selects every term
+const termScopeAll = "all"
+
+// TermRequest is the body accepted when creating or updating a term
+type TermRequest struct {
+	Name      string `json:"name" binding:"required"`
+	StartDate string `json:"start_date" binding:"required"`
+	EndDate   string `json:"end_date" binding:"required"`
+}
+
+// Contains reports whether a calendar day (YYYY-MM-DD) falls within the term
+func (t Term) Contains(day string) bool {
+	return t.StartDate <= day && day <= t.EndDate
+}
+
+// Overlaps reports whether two terms share at least one day
+func (t Term) Overlaps(other Term) bool {
+	return t.StartDate <= other.EndDate && other.StartDate <= t.EndDate
+}
+
+// validate checks the dates of a term request
+func (req TermRequest) validate() error {
+	if strings.TrimSpace(req.Name) == "" {
+		return &requestError{http.StatusBadRequest, "A term name is required"}
+	}
+	start, err := time.Parse(termDateLayout, req.StartDate)
+	if err != nil {
+		return &requestError{http.StatusBadRequest, "start_date must be a date in YYYY-MM-DD format"}
+	}
+	end, err := time.Parse(termDateLayout, req.EndDate)
+	if err != nil {
+		return &requestError{http.StatusBadRequest, "end_date must be a date in YYYY-MM-DD format"}
+	}
+	if end.Before(start) {
+		return &requestError{http.StatusBadRequest, "end_date must not be before start_date"}
+	}
+	return nil
+}
+
+// checkTermOverlap rejects a term whose dates overlap another term, so every
+// day belongs to at most one term
+func checkTermOverlap(ctx context.Context, term Term) error {
+	terms, err := store.Terms().List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range terms {
+		if other.ID != term.ID && term.Overlaps(other) {
+			return &requestError{http.StatusConflict, "Term dates overlap with " + other.Name}
+		}
+	}
+	return nil
+}
+
+// termForDay returns the term containing a calendar day (YYYY-MM-DD)
+func termForDay(ctx context.Context, day string) (Term, bool, error) {
+	terms, err := store.Terms().List(ctx)
+	if err != nil {
+		return Term{}, false, err
+	}
+	for _, term := range terms {
+		if term.Contains(day) {
+			return term, true, nil
+		}
+	}
+	return Term{}, false, nil
+}
+
+// currentTerm returns the term containing today in the school timezone
+func currentTerm(ctx context.Context) (Term, bool, error) {
+	return termForDay(ctx, time.Now().In(config.SchoolLocation).Format(termDateLayout))
+}
+
+// defaultEntryTerm is the term for ledger entries that are not tied to an
+// event, such as manual adjustments: the current term while it is open,
+// otherwise no term
+func defaultEntryTerm(ctx context.Context) (primitive.ObjectID, error) {
+	term, ok, err := currentTerm(ctx)
+	if err != nil || !ok || term.Status != termOpen {
+		return primitive.NilObjectID, err
+	}
+	return term.ID, nil
+}
+
+// requireOpenTerm loads a term that events and points may still be added to
+func requireOpenTerm(ctx context.Context, id primitive.ObjectID) (Term, error) {
+	term, err := store.Terms().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return term, &requestError{http.StatusBadRequest, "Term does not exist"}
+	}
+	if err != nil {
+		return term, err
+	}
+	if term.Status != termOpen {
+		return term, &requestError{http.StatusConflict, "Term " + term.Name + " is closed"}
+	}
+	return term, nil
+}
+
+// checkTermsOpen rejects ledger entries booked in a closed term; the
+// standings of a closed term must not change
+func checkTermsOpen(ctx context.Context, entries ...LedgerEntry) error {
+	checked := make(map[primitive.ObjectID]bool)
+	for _, entry := range entries {
+		if entry.TermID.IsZero() || checked[entry.TermID] {
+			continue
+		}
+		checked[entry.TermID] = true
+		if _, err := requireOpenTerm(ctx, entry.TermID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveEventTerm checks the term an event is attached to. Events without
+// one are attached to the open term containing their start date, if any.
+func resolveEventTerm(ctx context.Context, event *Event) error {
+	if !event.TermID.IsZero() {
+		_, err := requireOpenTerm(ctx, event.TermID)
+		return err
+	}
+
+	term, ok, err := termForDay(ctx, eventDay(*event))
+	if err != nil {
+		return err
+	}
+	if ok && term.Status == termOpen {
+		event.TermID = term.ID
+	}
+	return nil
+}
+
+// termScope reads the term_id query parameter that scopes point totals: a
+// term ID, "all" for every term, or nothing for the current term. The zero
+// Term means no scoping. On failure the error response has been written.
+func termScope(ctx context.Context, c *gin.Context) (Term, bool) {
+	value := c.Query("term_id")
+	switch value {
+	case termScopeAll:
+		return Term{}, true
+	case "":
+		term, _, err := currentTerm(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return Term{}, false
+		}
+		return term, true
+	}
+
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid term ID format"})
+		return Term{}, false
+	}
+	term, err := store.Terms().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Term not found"})
+		return Term{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return Term{}, false
+	}
+	return term, true
+}
+
+// scopeResponse is the value reported as "term" alongside scoped totals;
+// nil means the totals cover every term
+func scopeResponse(term Term) *Term {
+	if term.ID.IsZero() {
+		return nil
+	}
+	return &term
+}
+
+// termStandings converts a ranked leaderboard into a term's standings
+func termStandings(termID primitive.ObjectID, entries []LeaderboardEntry) []TermStanding {
+	now := time.Now()
+	standings := make([]TermStanding, len(entries))
+	for i, entry := range entries {
+		standings[i] = TermStanding{
+			ID:             primitive.NewObjectID(),
+			TermID:         termID,
+			Rank:           entry.Rank,
+			DepartmentRank: entry.DepartmentRank,
+			TeacherID:      entry.TeacherID,
+			TeacherName:    entry.TeacherName,
+			DepartmentID:   entry.DepartmentID,
+			DepartmentName: entry.DepartmentName,
+			Points:         entry.Points,
+			CreatedAt:      now,
+		}
+	}
+	return standings
+}
+
+// termParam parses the :id parameter and loads the term, writing the error
+// response itself if that fails
+func termParam(ctx context.Context, c *gin.Context) (Term, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid term ID format"})
+		return Term{}, false
+	}
+
+	term, err := store.Terms().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Term not found"})
+		return Term{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return Term{}, false
+	}
+	return term, true
+}
+
+// ListTerms handler
+func ListTerms(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	terms, err := store.Terms().List(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if terms == nil {
+		terms = []Term{}
+	}
+
+	c.JSON(http.StatusOK, terms)
+}
+
+// GetCurrentTerm returns the term containing today
+func GetCurrentTerm(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	term, ok, err := currentTerm(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No term covers today"})
+		return
+	}
+
+	c.JSON(http.StatusOK, term)
+}
+
+// GetTerm handler
+func GetTerm(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	term, ok := termParam(ctx, c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, term)
+}
+
+// CreateTerm handler. Terms may not overlap.
+func CreateTerm(c *gin.Context) {
+	var req TermRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A name, start_date and end_date are required"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondTransactionError(c, err, "Invalid term")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	term := Term{
+		ID:        primitive.NewObjectID(),
+		Name:      strings.TrimSpace(req.Name),
+		StartDate: req.StartDate,
+		EndDate:   req.EndDate,
+		Status:    termOpen,
+	}
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := checkTermOverlap(ctx, term); err != nil {
+			return err
+		}
+		return store.Terms().Create(ctx, term)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to create term")
+		return
+	}
+
+	c.JSON(http.StatusCreated, term)
+}
+
+// UpdateTerm renames an open term or changes its dates
+func UpdateTerm(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid term ID format"})
+		return
+	}
+
+	var req TermRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A name, start_date and end_date are required"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondTransactionError(c, err, "Invalid term")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var term Term
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		term, err = store.Terms().Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Term not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if term.Status != termOpen {
+			return &requestError{http.StatusConflict, "A closed term cannot be changed"}
+		}
+
+		term.Name = strings.TrimSpace(req.Name)
+		term.StartDate = req.StartDate
+		term.EndDate = req.EndDate
+		if err := checkTermOverlap(ctx, term); err != nil {
+			return err
+		}
+		return store.Terms().Update(ctx, term)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to update term")
+		return
+	}
+
+	c.JSON(http.StatusOK, term)
+}
+
+// CloseTerm closes a term and archives its final standings. Once closed, no
+// points can be awarded, deducted or adjusted in the term. The tie_break
+// query parameter chooses how tied teachers are ranked in the archive.
+func CloseTerm(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid term ID format"})
+		return
+	}
+
+	rule := c.DefaultQuery("tie_break", tieBreakShared)
+	if !validTieBreak(rule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tie_break must be shared, name or earliest"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	actor, _ := currentUser(c)
+	var term Term
+	var standings []TermStanding
+
+	err = store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		term, err = store.Terms().Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "Term not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if term.Status != termOpen {
+			return &requestError{http.StatusConflict, "Term is already closed"}
+		}
+
+		entries, err := leaderboardEntries(ctx, term.ID)
+		if err != nil {
+			return err
+		}
+		standings = termStandings(term.ID, rankLeaderboard(entries, rule))
+		err = store.Terms().ArchiveStandings(ctx, term.ID, standings)
+		if errors.Is(err, ErrDuplicate) {
+			return &requestError{http.StatusConflict, "Term standings have already been archived"}
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		term.Status = termClosed
+		term.ClosedAt = &now
+		term.ClosedBy = actor.ID
+		return store.Terms().Update(ctx, term)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to close term")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"term":      term,
+		"standings": standings,
+	})
+}
+
+// GetTermStandings returns the archived standings of a closed term, or the
+// live standings of an open one
+func GetTermStandings(c *gin.Context) {
+	rule := c.DefaultQuery("tie_break", tieBreakShared)
+	if !validTieBreak(rule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tie_break must be shared, name or earliest"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	term, ok := termParam(ctx, c)
+	if !ok {
+		return
+	}
+
+	var standings []TermStanding
+	if term.Status == termClosed {
+		var err error
+		standings, err = store.Terms().Standings(ctx, term.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		entries, err := leaderboardEntries(ctx, term.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		standings = termStandings(term.ID, rankLeaderboard(entries, rule))
+	}
+	if standings == nil {
+		standings = []TermStanding{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"term":      term,
+		"archived":  term.Status == termClosed,
+		"standings": standings,
+	})
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// mfaTokenTTL is how long the second step of a login may take
+	mfaTokenTTL = 5 * time.Minute
+	// totpPeriod is how often authenticator codes change
+	totpPeriod        = 30 * time.Second
+	recoveryCodeCount = 10
+)
+
+var totpOptions = totp.ValidateOpts{
+	Period:    uint(totpPeriod / time.Second),
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+var errBadSecondFactor = &requestError{http.StatusUnauthorized, "Invalid two-factor code"}
+
+// twoFactorSetupRoutes are the routes open to admins who have to set up
+// two-factor authentication before anything else
+var twoFactorSetupRoutes = map[string]bool{
+	"/logout":         true,
+	"/me":             true,
+	"/me/password":    true,
+	"/me/2fa/enroll":  true,
+	"/me/2fa/confirm": true,
+}
+
+// twoFactorSetupRequired reports whether policy requires a user to enroll in
+// two-factor authentication before doing anything else
+func twoFactorSetupRequired(user User) bool {
+	return config.RequireAdminTwoFactor && user.Role == userRoleAdmin && user.TOTPEnabledAt == nil
+}
+
+// signMFAToken signs the token that carries a login from the password step
+// to the two-factor step
+func signMFAToken(user User) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(mfaTokenTTL)
+	claims := TokenClaims{
+		Type: mfaTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.AuthSecret)
+	return token, expiresAt, err
+}
+
+// checkTOTP accepts a code for the current time step or either neighbour,
+// unless that step was already used. The user's last step is updated.
+func checkTOTP(user *User, code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	now := time.Now()
+	for skew := -1; skew <= 1; skew++ {
+		t := now.Add(time.Duration(skew) * totpPeriod)
+		step := t.Unix() / int64(totpPeriod/time.Second)
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(user.TOTPSecret, t, totpOptions)
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			user.TOTPLastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in recovery codes
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(buf)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashUserToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// useRecoveryCode removes a recovery code from a user, reporting whether
+// they had it
+func useRecoveryCode(user *User, code string) bool {
+	hash := hashUserToken(normalizeRecoveryCode(code))
+	for i, stored := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			remaining := make([]string, 0, len(user.RecoveryCodes)-1)
+			remaining = append(remaining, user.RecoveryCodes[:i]...)
+			user.RecoveryCodes = append(remaining, user.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecondFactor accepts an authenticator code or, failing that, a
+// recovery code
+func checkSecondFactor(user *User, code, recoveryCode string) bool {
+	if code != "" {
+		return checkTOTP(user, code)
+	}
+	if recoveryCode != "" {
+		return useRecoveryCode(user, recoveryCode)
+	}
+	return false
+}
+
+// verifySecondFactor checks a user's code in a transaction, so that a code
+// is only accepted once. Wrong codes are recorded as failed logins.
+func verifySecondFactor(ctx context.Context, c *gin.Context, userID primitive.ObjectID, code, recoveryCode string) (User, bool) {
+	var user User
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = store.Users().Get(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabledAt == nil {
+			return &requestError{http.StatusConflict, "Two-factor authentication is not enabled"}
+		}
+		if !checkSecondFactor(&user, code, recoveryCode) {
+			return errBadSecondFactor
+		}
+		return store.Users().Update(ctx, user)
+	})
+	if errors.Is(err, errBadSecondFactor) {
+		recordLoginFailure(ctx, normalizeEmail(user.Email), c.ClientIP(), loginBadCode, user.ID)
+	}
+	if err != nil {
+		respondTransactionError(c, err, "Failed to check two-factor code")
+		return User{}, false
+	}
+	return user, true
+}
+
+// LoginTwoFactor finishes a login started with Login, given an authenticator
+// code or a recovery code
+func LoginTwoFactor(c *gin.Context) {
+	type TwoFactorRequest struct {
+		MFAToken     string `json:"mfa_token" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	var req TwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	claims, err := parseToken(req.MFAToken, mfaTokenType)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login has expired; please log in again"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := findUserByID(ctx, claims.Subject)
+	if err != nil {
+		if err == errInvalidToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	if !checkLoginAllowed(ctx, c, normalizeEmail(user.Email), user.ID) {
+		return
+	}
+	user, ok := verifySecondFactor(ctx, c, user.ID, req.Code, req.RecoveryCode)
+	if !ok {
+		return
+	}
+
+	completeLogin(ctx, c, user)
+}
+
+// EnrollTwoFactor starts two-factor enrollment with a new secret, returned
+// as an otpauth:// URI and a QR code of it for authenticator apps. It takes
+// effect once confirmed with ConfirmTwoFactor.
+func EnrollTwoFactor(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	caller, _ := currentUser(c)
+	user, err := store.Users().Get(ctx, caller.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      config.TOTPIssuer,
+		AccountName: user.Email,
+		Period:      totpOptions.Period,
+		Digits:      totpOptions.Digits,
+		Algorithm:   totpOptions.Algorithm,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	png, err := qrcode.Encode(key.URL(), qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user.TOTPSecret = key.Secret()
+	user.TOTPLastStep = 0
+	if err := store.Users().Update(ctx, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      key.Secret(),
+		"otpauth_url": key.URL(),
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// ConfirmTwoFactor turns on two-factor authentication once the caller shows
+// a code from the secret given by EnrollTwoFactor, and returns their
+// recovery codes. They are not shown again.
+func ConfirmTwoFactor(c *gin.Context) {
+	type ConfirmRequest struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	var req ConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	caller, _ := currentUser(c)
+	var codes []string
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		user, err := store.Users().Get(ctx, caller.ID)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabledAt != nil {
+			return &requestError{http.StatusConflict, "Two-factor authentication is already enabled"}
+		}
+		if user.TOTPSecret == "" {
+			return &requestError{http.StatusBadRequest, "Start enrollment with POST /me/2fa/enroll first"}
+		}
+		if !checkTOTP(&user, req.Code) {
+			return &requestError{http.StatusBadRequest, "Invalid two-factor code"}
+		}
+
+		var hashes []string
+		if codes, hashes, err = newRecoveryCodes(); err != nil {
+			return err
+		}
+		now := time.Now()
+		user.TOTPEnabledAt = &now
+		user.RecoveryCodes = hashes
+		return store.Users().Update(ctx, user)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes, given a
+// current authenticator code
+func RegenerateRecoveryCodes(c *gin.Context) {
+	type RegenerateRequest struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	var req RegenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	caller, _ := currentUser(c)
+	if !checkLoginAllowed(ctx, c, normalizeEmail(caller.Email), caller.ID) {
+		return
+	}
+	user, ok := verifySecondFactor(ctx, c, caller.ID, req.Code, "")
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	user.RecoveryCodes = hashes
+	if err := store.Users().Update(ctx, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save recovery codes"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns off the caller's two-factor authentication, given
+// their password and a code. Admins cannot while policy requires it.
+func DisableTwoFactor(c *gin.Context) {
+	type DisableRequest struct {
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	var req DisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	caller, _ := currentUser(c)
+	if config.RequireAdminTwoFactor && caller.Role == userRoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins must keep two-factor authentication enabled"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := normalizeEmail(caller.Email)
+	if !checkLoginAllowed(ctx, c, email, caller.ID) {
+		return
+	}
+	user, err := store.Users().Get(ctx, caller.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		recordLoginFailure(ctx, email, c.ClientIP(), loginBadPassword, user.ID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password is incorrect"})
+		return
+	}
+	user, ok := verifySecondFactor(ctx, c, user.ID, req.Code, req.RecoveryCode)
+	if !ok {
+		return
+	}
+	if err := clearTwoFactor(ctx, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// clearTwoFactor removes a user's secret and recovery codes
+func clearTwoFactor(ctx context.Context, user User) error {
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	return store.Users().Update(ctx, user)
+}
+
+// resetTwoFactor turns off a user's two-factor authentication and ends their
+// sessions
+func resetTwoFactor(ctx context.Context, user User) error {
+	if user.TOTPSecret == "" {
+		return &requestError{http.StatusConflict, "Two-factor authentication is not enabled"}
+	}
+	if err := clearTwoFactor(ctx, user); err != nil {
+		return err
+	}
+	return store.Sessions().RevokeAllForUser(ctx, user.ID)
+}
+
+// ResetUserTwoFactor turns off two-factor authentication for a user who has
+// lost their authenticator and recovery codes, and ends their sessions.
+// Admins still have to enroll again while policy requires it.
+func ResetUserTwoFactor(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		user, err := store.Users().Get(ctx, userID)
+		if errors.Is(err, ErrNotFound) {
+			return &requestError{http.StatusNotFound, "User not found"}
+		}
+		if err != nil {
+			return err
+		}
+		return resetTwoFactor(ctx, user)
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to reset two-factor authentication")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset", "user_id": userID})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errRoleFull is returned by assignTeacher when a role has no places left
+var errRoleFull = &requestError{http.StatusConflict, "Role has reached its maximum head count"}
+
+// joinWaitlist puts a teacher at the end of a role's waitlist. It is meant to
+// follow an assignTeacher call that failed with errRoleFull in the same
+// transaction, which has already locked the role and checked the teacher.
+func joinWaitlist(ctx context.Context, roleID, eventID, teacherID primitive.ObjectID, selfSignup bool) (WaitlistEntry, error) {
+	teacher, err := store.Teachers().Get(ctx, teacherID)
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	entries, err := store.Waitlist().List(ctx, WaitlistFilter{RoleID: roleID})
+	if err != nil {
+		return WaitlistEntry{}, err
+	}
+	position := 1
+	if len(entries) > 0 {
+		position = entries[len(entries)-1].Position + 1
+	}
+
+	entry := WaitlistEntry{
+		ID:          primitive.NewObjectID(),
+		RoleID:      roleID,
+		EventID:     eventID,
+		TeacherID:   teacherID,
+		TeacherName: teacher.Name,
+		Position:    position,
+		SelfSignup:  selfSignup,
+		CreatedAt:   time.Now(),
+	}
+	err = store.Waitlist().Create(ctx, entry)
+	if errors.Is(err, ErrDuplicate) {
+		return WaitlistEntry{}, &requestError{http.StatusBadRequest, "Teacher is already on the waitlist for this role"}
+	}
+	return entry, err
+}
+
+// promoteWaitlist fills the open places of a role from its waitlist, in
+// order, while the event is published. Entries that can no longer be
+// assigned, for example because the teacher was deleted, are dropped. It
+// must run in a transaction.
+func promoteWaitlist(ctx context.Context, roleID primitive.ObjectID) ([]Assignment, error) {
+	role, err := store.Roles().GetForUpdate(ctx, roleID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	event, err := store.Events().Get(ctx, role.EventID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if event.Status != eventPublished {
+		return nil, nil
+	}
+
+	var promoted []Assignment
+	for {
+		assigned, err := store.Assignments().Count(ctx, AssignmentFilter{RoleID: roleID})
+		if err != nil {
+			return nil, err
+		}
+		if assigned >= role.HeadCount {
+			return promoted, nil
+		}
+		entries, err := store.Waitlist().List(ctx, WaitlistFilter{RoleID: roleID})
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return promoted, nil
+		}
+
+		next := entries[0]
+		if err := store.Waitlist().Delete(ctx, next.ID); err != nil {
+			return nil, err
+		}
+		assignment, err := assignTeacher(ctx, next.TeacherID, roleID, event.ID, next.SelfSignup)
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, assignment)
+	}
+}
+
+// clearWaitlist drops every waitlist entry of an event
+func clearWaitlist(ctx context.Context, eventID primitive.ObjectID) error {
+	entries, err := store.Waitlist().List(ctx, WaitlistFilter{EventID: eventID})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := store.Waitlist().Delete(ctx, entry.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitlistRole loads the role named by the id path parameter. On failure the
+// error response has been written.
+func waitlistRole(ctx context.Context, c *gin.Context) (Role, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID format"})
+		return Role{}, false
+	}
+	role, err := store.Roles().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return Role{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return Role{}, false
+	}
+	return role, true
+}
+
+// GetRoleWaitlist returns a role's waitlist in promotion order
+func GetRoleWaitlist(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	role, ok := waitlistRole(ctx, c)
+	if !ok {
+		return
+	}
+	entries, err := store.Waitlist().List(ctx, WaitlistFilter{RoleID: role.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []WaitlistEntry{}
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// ReorderRoleWaitlist sets the promotion order of a role's waitlist. The
+// request lists every entry ID of the waitlist, first to be promoted first.
+func ReorderRoleWaitlist(c *gin.Context) {
+	type ReorderRequest struct {
+		EntryIDs []primitive.ObjectID `json:"entry_ids" binding:"required"`
+	}
+
+	var req ReorderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	role, ok := waitlistRole(ctx, c)
+	if !ok {
+		return
+	}
+
+	var entries []WaitlistEntry
+	err := store.RunInTransaction(ctx, func(ctx context.Context) error {
+		// Lock the role so promotions cannot interleave with the reorder
+		if _, err := store.Roles().GetForUpdate(ctx, role.ID); err != nil {
+			return err
+		}
+		current, err := store.Waitlist().List(ctx, WaitlistFilter{RoleID: role.ID})
+		if err != nil {
+			return err
+		}
+		byID := make(map[primitive.ObjectID]WaitlistEntry, len(current))
+		for _, entry := range current {
+			byID[entry.ID] = entry
+		}
+		if len(req.EntryIDs) != len(current) {
+			return &requestError{http.StatusBadRequest, "entry_ids must list every entry of the waitlist exactly once"}
+		}
+
+		entries = make([]WaitlistEntry, 0, len(current))
+		for i, id := range req.EntryIDs {
+			entry, ok := byID[id]
+			if !ok {
+				return &requestError{http.StatusBadRequest, "entry_ids must list every entry of the waitlist exactly once"}
+			}
+			delete(byID, id)
+			entry.Position = i + 1
+			if err := store.Waitlist().Update(ctx, entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		respondTransactionError(c, err, "Failed to reorder waitlist")
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// RemoveWaitlistEntry takes a teacher off a waitlist. Teachers can remove
+// their own entries; anyone else needs assignments:write.
+func RemoveWaitlistEntry(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid waitlist entry ID format"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entry, err := store.Waitlist().Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Waitlist entry not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, _ := currentUser(c)
+	if !hasPermission(user, PermAssignmentsWrite) {
+		teacher, err := findTeacherForUser(ctx, user)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil || teacher.ID != entry.TeacherID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only leave your own waitlist entries"})
+			return
+		}
+	}
+
+	if err := store.Waitlist().Delete(ctx, id); err != nil && !errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Removed from the waitlist"})
+}